@@ -0,0 +1,72 @@
+package tcr
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const defaultBackoffMultiplier = 2.0
+
+// Backoff calculates capped exponential delays, with optional jitter, between reconnect attempts.
+type Backoff struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64 // growth per attempt, defaults to 2
+	Jitter     float64 // randomizes each delay by up to this fraction (0.0 - 1.0), still capped by MaxDelay
+}
+
+// ReconnectAttempt is a failed reconnect attempt and the delay before the next one.
+type ReconnectAttempt struct {
+	ConnectionID uint64
+	Attempt      int
+	Delay        time.Duration
+	Time         time.Time
+}
+
+// NewBackoffFromConfig creates the reconnect Backoff from the PoolConfig.
+// Without a ReconnectBaseDelay the SleepOnErrorInterval is used as a fixed delay.
+func NewBackoffFromConfig(config *PoolConfig) *Backoff {
+
+	if config.ReconnectBaseDelay == 0 {
+		sleepOnErrorInterval := time.Duration(config.SleepOnErrorInterval) * time.Millisecond
+		return &Backoff{
+			BaseDelay:  sleepOnErrorInterval,
+			MaxDelay:   sleepOnErrorInterval,
+			Multiplier: 1,
+		}
+	}
+
+	return &Backoff{
+		BaseDelay:  time.Duration(config.ReconnectBaseDelay) * time.Millisecond,
+		MaxDelay:   time.Duration(config.ReconnectMaxDelay) * time.Millisecond,
+		Multiplier: config.ReconnectMultiplier,
+		Jitter:     config.ReconnectJitter,
+	}
+}
+
+// Delay is the time to wait after the given failed attempt (starting at 1).
+func (b *Backoff) Delay(attempt int) time.Duration {
+
+	if b.BaseDelay <= 0 || attempt < 1 {
+		return 0
+	}
+
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	delay := float64(b.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		delay += delay * jitter * (rand.Float64()*2 - 1)
+	}
+
+	// Clamped after the jitter, so MaxDelay is a cap.
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+
+	return time.Duration(delay)
+}
@@ -16,6 +16,10 @@ type ChannelHost struct {
 	CachedChannel bool
 	Confirmations chan amqp.Confirmation
 	Errors        chan *amqp.Error
+	Returns       chan amqp.Return
+	publishCount  uint64
+	currentLease  *channelLease
+	reclaimed     bool
 	connHost      *ConnectionHost
 	chanLock      *sync.Mutex
 }
@@ -63,6 +67,8 @@ func (ch *ChannelHost) MakeChannel() (err error) {
 		return err
 	}
 
+	// Confirm mode is set once per channel, every publish on it is then tracked by its delivery tag.
+	ch.publishCount = 0
 	if ch.Ackable {
 		err = ch.Channel.Confirm(false)
 		if err != nil {
@@ -76,9 +82,91 @@ func (ch *ChannelHost) MakeChannel() (err error) {
 	ch.Errors = make(chan *amqp.Error, 100)
 	ch.Channel.NotifyClose(ch.Errors)
 
+	ch.Returns = make(chan amqp.Return, 100)
+	ch.Channel.NotifyReturn(ch.Returns)
+
 	return nil
 }
 
+// Publish sends the message on the channel and returns its delivery tag, the tag its publish confirmation will have
+// when the channel is Ackable.
+func (ch *ChannelHost) Publish(exchange, routingKey string, mandatory, immediate bool, msg amqp.Publishing) (uint64, error) {
+
+	deliveryTag, _, err := ch.publish(exchange, routingKey, mandatory, immediate, msg)
+	return deliveryTag, err
+}
+
+// publish is Publish also returning the Confirmations of the channel the message was published on. They are closed
+// when that channel closes, so a confirmation that can't arrive anymore doesn't go unnoticed after a reconnect.
+func (ch *ChannelHost) publish(exchange, routingKey string, mandatory, immediate bool, msg amqp.Publishing) (uint64, <-chan amqp.Confirmation, error) {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+
+	err := ch.Channel.Publish(exchange, routingKey, mandatory, immediate, msg)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ch.publishCount++
+
+	return ch.publishCount, ch.Confirmations, nil
+}
+
+// confirmations are the Confirmations of the current channel, closed when that channel closes.
+func (ch *ChannelHost) confirmations() <-chan amqp.Confirmation {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+
+	return ch.Confirmations
+}
+
+// returned are the Returns of the current channel.
+func (ch *ChannelHost) returned() <-chan amqp.Return {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+
+	return ch.Returns
+}
+
+// isChannelClosedError checks for the error of using a channel (or its connection) after it closed.
+func isChannelClosedError(err error) bool {
+
+	amqpErr, ok := err.(*amqp.Error)
+	return ok && (amqpErr == amqp.ErrClosed || amqpErr.Code == amqp.ChannelError)
+}
+
+// FlushReturns removes all pending returned messages (basic.return) and forwards them to returns without blocking.
+func (ch *ChannelHost) FlushReturns(returns chan<- *ReturnMessage) {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+
+	for {
+		select {
+		case amqpReturn := <-ch.Returns:
+			forwardReturn(&amqpReturn, returns)
+		default:
+			return
+		}
+	}
+}
+
+// forwardReturns converts returned messages until the amqp channel closes amqpReturns.
+func forwardReturns(amqpReturns <-chan amqp.Return, returns chan<- *ReturnMessage) {
+
+	for amqpReturn := range amqpReturns {
+		forwardReturn(&amqpReturn, returns)
+	}
+}
+
+// forwardReturn drops the returned message when returns is full so an unread returns channel can't block the amqp channel.
+func forwardReturn(amqpReturn *amqp.Return, returns chan<- *ReturnMessage) {
+
+	select {
+	case returns <- NewReturnMessage(amqpReturn):
+	default:
+	}
+}
+
 // FlushConfirms removes all previous confirmations pending processing.
 func (ch *ChannelHost) FlushConfirms() {
 	ch.chanLock.Lock()
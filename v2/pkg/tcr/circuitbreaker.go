@@ -0,0 +1,245 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultCircuitBreakerCooldown is how long a circuit stays open when CircuitBreakerCooldown isn't set.
+const defaultCircuitBreakerCooldown = 5 * time.Second
+
+// ErrCircuitOpen indicates a letter wasn't published because its exchange and routing key kept failing.
+var ErrCircuitOpen = errors.New("publish circuit is open")
+
+// CircuitState is the state of the circuit breaker of an exchange and routing key.
+type CircuitState int
+
+const (
+	// CircuitClosed publishes normally.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen short-circuits publishes with ErrCircuitOpen until the cooldown has passed.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a single probe publish through, its success closes the circuit and its failure opens it again.
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitOpen:
+		return "Open"
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	default:
+		return "Closed"
+	}
+}
+
+// CircuitStats is a snapshot of the circuit breaker of an exchange and routing key.
+type CircuitStats struct {
+	Exchange            string
+	RoutingKey          string
+	State               CircuitState
+	ConsecutiveFailures int
+	OpenedAt            time.Time // zero unless Open or HalfOpen
+}
+
+type circuitKey struct {
+	exchange   string
+	routingKey string
+}
+
+type circuit struct {
+	state      CircuitState
+	failures   int
+	openedAt   time.Time
+	probeStart time.Time
+}
+
+// circuitBreaker opens the circuit of an exchange and routing key after threshold consecutive publish failures.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	circuits  map[circuitKey]*circuit
+	lock      *sync.Mutex
+}
+
+// newCircuitBreaker creates the circuitBreaker configured in the PublisherConfig, nil when it is disabled.
+func newCircuitBreaker(config *PublisherConfig) *circuitBreaker {
+
+	if config == nil || config.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+
+	cooldown := time.Duration(config.CircuitBreakerCooldown) * time.Millisecond
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+
+	return &circuitBreaker{
+		threshold: config.CircuitBreakerThreshold,
+		cooldown:  cooldown,
+		circuits:  make(map[circuitKey]*circuit),
+		lock:      &sync.Mutex{},
+	}
+}
+
+// allow errors with ErrCircuitOpen when the circuit of the exchange and routing key is open. Once the cooldown has
+// passed, a single probe is allowed through every cooldown until one succeeds.
+func (cb *circuitBreaker) allow(exchange, routingKey string) error {
+
+	if cb == nil {
+		return nil
+	}
+
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	c, ok := cb.circuits[circuitKey{exchange, routingKey}]
+	if !ok || c.state == CircuitClosed {
+		return nil
+	}
+
+	// A probe without an outcome (ex. its letter was dropped) doesn't keep the circuit half open forever.
+	if (c.state == CircuitOpen && time.Since(c.openedAt) >= cb.cooldown) ||
+		(c.state == CircuitHalfOpen && time.Since(c.probeStart) >= cb.cooldown) {
+		c.state = CircuitHalfOpen
+		c.probeStart = time.Now()
+		return nil
+	}
+
+	return fmt.Errorf("%w: exchange %q routing key %q failed %d times in a row", ErrCircuitOpen, exchange, routingKey, c.failures)
+}
+
+// record counts the outcome of a publish to the exchange and routing key. Short-circuited and cancelled publishes
+// aren't counted.
+func (cb *circuitBreaker) record(exchange, routingKey string, err error) {
+
+	if cb == nil ||
+		errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	key := circuitKey{exchange, routingKey}
+	if err == nil {
+		delete(cb.circuits, key)
+		return
+	}
+
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = &circuit{}
+		cb.circuits[key] = c
+	}
+
+	c.failures++
+	if c.state == CircuitHalfOpen || c.failures >= cb.threshold {
+		c.state = CircuitOpen
+		c.openedAt = time.Now()
+	}
+}
+
+// allowLetters errors with ErrCircuitOpen when the circuit of any of the letters is open, asking once per exchange
+// and routing key so letters sharing a half open circuit go through together as its probe.
+func (cb *circuitBreaker) allowLetters(letters []*Letter) error {
+
+	if cb == nil {
+		return nil
+	}
+
+	allowed := make(map[circuitKey]bool, len(letters))
+	for _, letter := range letters {
+		key := circuitKey{letter.Envelope.Exchange, letter.Envelope.RoutingKey}
+		if allowed[key] {
+			continue
+		}
+
+		if err := cb.allow(key.exchange, key.routingKey); err != nil {
+			return err
+		}
+		allowed[key] = true
+	}
+
+	return nil
+}
+
+// recordLetters counts the outcome of publishing the letters together, once per exchange and routing key.
+func (cb *circuitBreaker) recordLetters(letters []*Letter, err error) {
+
+	if cb == nil {
+		return
+	}
+
+	recorded := make(map[circuitKey]bool, len(letters))
+	for _, letter := range letters {
+		key := circuitKey{letter.Envelope.Exchange, letter.Envelope.RoutingKey}
+		if !recorded[key] {
+			cb.record(key.exchange, key.routingKey, err)
+			recorded[key] = true
+		}
+	}
+}
+
+// stats gets a snapshot of the circuits with failures, sorted by exchange and routing key.
+func (cb *circuitBreaker) stats() []CircuitStats {
+
+	if cb == nil {
+		return nil
+	}
+
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	stats := make([]CircuitStats, 0, len(cb.circuits))
+	for key, c := range cb.circuits {
+		circuitStats := CircuitStats{
+			Exchange:            key.exchange,
+			RoutingKey:          key.routingKey,
+			State:               c.state,
+			ConsecutiveFailures: c.failures,
+		}
+
+		if c.state != CircuitClosed {
+			circuitStats.OpenedAt = c.openedAt
+		}
+
+		stats = append(stats, circuitStats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Exchange != stats[j].Exchange {
+			return stats[i].Exchange < stats[j].Exchange
+		}
+		return stats[i].RoutingKey < stats[j].RoutingKey
+	})
+
+	return stats
+}
+
+// CircuitStats gets a snapshot of the circuit breaker of every exchange and routing key with failing publishes.
+// Empty when the circuit breaker is disabled (CircuitBreakerThreshold is 0).
+func (pub *Publisher) CircuitStats() []CircuitStats {
+	return pub.breaker.stats()
+}
+
+// circuitOpen sends a failed receipt with ErrCircuitOpen, and returns true, when the letter's circuit is open.
+func (pub *Publisher) circuitOpen(letter *Letter, publishStart time.Time) bool {
+
+	err := pub.breaker.allow(letter.Envelope.Exchange, letter.Envelope.RoutingKey)
+	if err == nil {
+		return false
+	}
+
+	pub.publishReceipt(letter, err, publishStart)
+	return true
+}
@@ -1,46 +1,106 @@
 package tcr
 
+import (
+	"crypto/tls"
+	"net"
+)
+
 // RabbitSeasoning represents the configuration values.
 type RabbitSeasoning struct {
-	EncryptionConfig  *EncryptionConfig          `json:"EncryptionConfig"`
-	CompressionConfig *CompressionConfig         `json:"CompressionConfig"`
-	PoolConfig        *PoolConfig                `json:"PoolConfig"`
-	ConsumerConfigs   map[string]*ConsumerConfig `json:"ConsumerConfigs"`
-	PublisherConfig   *PublisherConfig           `json:"PublisherConfig"`
+	EncryptionConfig   *EncryptionConfig          `json:"EncryptionConfig"`
+	CompressionConfig  *CompressionConfig         `json:"CompressionConfig"`
+	PoolConfig         *PoolConfig                `json:"PoolConfig"`
+	ConsumerConfigs    map[string]*ConsumerConfig `json:"ConsumerConfigs"`
+	PublisherConfig    *PublisherConfig           `json:"PublisherConfig"`
+	AppID              string                     `json:"AppID"`              // AppId published on every message unless the Envelope sets one
+	JSONConfig         *JSONConfig                `json:"JSONConfig"`         // JSON encoding of published payloads, defaults to compact without HTML escaping
+	Logger             Logger                     `json:"-"`                  // receives internal events (reconnects, retries, shutdown steps), defaults to NoopLogger
+	ManagementConfig   *ManagementConfig          `json:"ManagementConfig"`   // RabbitMQ management HTTP API, used by the tcrmgmt package
+	MonitorInterval    uint32                     `json:"MonitorInterval"`    // ms the RabbitService's background loops sleep between checks, defaults to 200
+	DefaultWrapPayload bool                       `json:"DefaultWrapPayload"` // wrapPayload used by RabbitService.PublishDefault and PublishWithConfirmationDefault
+	DefaultMetadata    string                     `json:"DefaultMetadata"`    // metadata used by RabbitService.PublishDefault and PublishWithConfirmationDefault
+
+	// PublishConfirmationTimeout (milliseconds) is how long RabbitService.PublishWithConfirmation waits for a
+	// confirmation, defaults to 300. Too short a timeout causes false negatives and unnecessary retries on a loaded broker.
+	PublishConfirmationTimeout uint32 `json:"PublishConfirmationTimeout"`
+}
+
+// ManagementConfig represents settings for the RabbitMQ management HTTP API (the rabbitmq_management plugin).
+type ManagementConfig struct {
+	URL      string `json:"URL"` // ex. http://localhost:15672
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+	Vhost    string `json:"Vhost"`   // defaults to /
+	Timeout  uint32 `json:"Timeout"` // request timeout in seconds, defaults to 10
 }
 
 // PoolConfig represents settings for creating/configuring pools.
 type PoolConfig struct {
-	ConnectionName       string     `json:"ConnectionName"`
-	URI                  string     `json:"URI"`
-	Heartbeat            uint32     `json:"Heartbeat"`
-	ConnectionTimeout    uint32     `json:"ConnectionTimeout"`
-	SleepOnErrorInterval uint32     `json:"SleepOnErrorInterval"` // sleep length on errors
-	MaxConnectionCount   uint64     `json:"MaxConnectionCount"`   // number of connections to create in the pool
-	MaxCacheChannelCount uint64     `json:"MaxCacheChannelCount"` // number of channels to be cached in the pool
-	TLSConfig            *TLSConfig `json:"TLSConfig"`            // TLS settings for connection with AMQPS.
+	ConnectionName             string                                       `json:"ConnectionName"`             // connection_name prefix, defaults to tcr-<hostname>
+	URI                        string                                       `json:"URI"`                        // amqp(s)://user:pass@host:port/vhost, a / in the vhost is %2F, query params heartbeat, connection_timeout and channel_max override the config
+	URIs                       []string                                     `json:"URIs"`                       // cluster node URIs used instead of URI, connections round-robin across them and fail over to the next
+	Heartbeat                  uint32                                       `json:"Heartbeat"`                  // heartbeat interval in seconds
+	ConnectionTimeout          uint32                                       `json:"ConnectionTimeout"`          // dial timeout in seconds
+	SleepOnErrorInterval       uint32                                       `json:"SleepOnErrorInterval"`       // sleep length on errors
+	MaxConnectionCount         uint64                                       `json:"MaxConnectionCount"`         // number of connections to create in the pool
+	MaxCacheChannelCount       uint64                                       `json:"MaxCacheChannelCount"`       // number of channels to be cached in the pool
+	MaxChannelsPerConnection   uint64                                       `json:"MaxChannelsPerConnection"`   // cached channels per connection before another connection is used, 0 is unlimited
+	MaxOverflowConnectionCount uint64                                       `json:"MaxOverflowConnectionCount"` // additional connections opened when every connection has MaxChannelsPerConnection
+	TLSConfig                  *TLSConfig                                   `json:"TLSConfig"`                  // TLS settings for connection with AMQPS.
+	Lazy                       bool                                         `json:"Lazy"`                       // connect on first use instead of in NewConnectionPool
+	ReconnectBaseDelay         uint32                                       `json:"ReconnectBaseDelay"`         // first reconnect delay in ms, 0 uses SleepOnErrorInterval without backoff
+	ReconnectMaxDelay          uint32                                       `json:"ReconnectMaxDelay"`          // reconnect delay cap in ms, 0 is uncapped
+	ReconnectMultiplier        float64                                      `json:"ReconnectMultiplier"`        // reconnect delay growth per attempt, defaults to 2
+	ReconnectJitter            float64                                      `json:"ReconnectJitter"`            // randomizes each reconnect delay by up to this fraction (0.0 - 1.0)
+	ChannelLeaseTimeout        uint32                                       `json:"ChannelLeaseTimeout"`        // ms a cached channel can be leased before a PoolChannelLeaked event, 0 disables leak detection
+	ReclaimLeakedChannels      bool                                         `json:"ReclaimLeakedChannels"`      // replace leaked channels in the cache, the leaked channel is closed
+	Dial                       func(network, addr string) (net.Conn, error) `json:"-"`                          // optional dialer, defaults to amqp.DefaultDial with the ConnectionTimeout
 }
 
 // TLSConfig represents settings for configuring TLS.
 type TLSConfig struct {
-	EnableTLS         bool   `json:"EnableTLS"` // Use TLSConfig to create connections with AMQPS uri.
-	PEMCertLocation   string `json:"PEMCertLocation"`
-	LocalCertLocation string `json:"LocalCertLocation"`
-	CertServerName    string `json:"CertServerName"`
+	EnableTLS         bool        `json:"EnableTLS"`         // Use TLSConfig to create connections with AMQPS uri.
+	PEMCertLocation   string      `json:"PEMCertLocation"`   // CA bundle the broker's certificate is verified with, the system roots when empty
+	LocalCertLocation string      `json:"LocalCertLocation"` // client certificate and key in one PEM file, CertLocation and KeyLocation take precedence
+	CertLocation      string      `json:"CertLocation"`      // client certificate for mutual TLS (mTLS)
+	KeyLocation       string      `json:"KeyLocation"`       // client certificate's private key, defaults to CertLocation
+	CertServerName    string      `json:"CertServerName"`
+	ClientConfig      *tls.Config `json:"-"` // Optional, used instead of the cert locations and dials the pool URI as is.
+
+	// InsecureSkipVerify skips verifying the broker's certificate and host name. UNSAFE, anyone in the middle can read
+	// and alter the connection, only use it against a self-signed development broker.
+	InsecureSkipVerify bool `json:"InsecureSkipVerify"`
 }
 
 // ConsumerConfig represents settings for configuring a consumer with ease.
 type ConsumerConfig struct {
-	Enabled              bool                   `json:"Enabled"`
-	QueueName            string                 `json:"QueueName"`
-	ConsumerName         string                 `json:"ConsumerName"`
-	AutoAck              bool                   `json:"AutoAck"`
-	Exclusive            bool                   `json:"Exclusive"`
-	NoWait               bool                   `json:"NoWait"`
-	Args                 map[string]interface{} `json:"Args"`
-	QosCountOverride     int                    `json:"QosCountOverride"`     // if zero ignored
-	SleepOnErrorInterval uint32                 `json:"SleepOnErrorInterval"` // sleep on error
-	SleepOnIdleInterval  uint32                 `json:"SleepOnIdleInterval"`  // sleep on idle
+	Enabled                bool                   `json:"Enabled"`
+	QueueName              string                 `json:"QueueName"` // if empty, consumes from a server named exclusive queue, see Consumer.GetQueueName
+	ConsumerName           string                 `json:"ConsumerName"`
+	AutoAck                bool                   `json:"AutoAck"` // at-most-once, messages are acknowledged on delivery and aren't IsAckable
+	Exclusive              bool                   `json:"Exclusive"`
+	NoWait                 bool                   `json:"NoWait"`
+	Args                   map[string]interface{} `json:"Args"`
+	QosCountOverride       int                    `json:"QosCountOverride"`       // if zero ignored
+	PrefetchCount          int                    `json:"PrefetchCount"`          // if zero, QosCountOverride is used
+	PrefetchSize           int                    `json:"PrefetchSize"`           // if zero ignored
+	GlobalQos              bool                   `json:"GlobalQos"`              // prefetch limits the consumer's channel instead of each consumer on it, not supported by quorum queues
+	QuorumQueue            bool                   `json:"QuorumQueue"`            // the queue is a quorum queue, consuming with GlobalQos or a server named queue is refused with ErrInvalidQuorumConsumer
+	ConcurrentHandlers     int                    `json:"ConcurrentHandlers"`     // workers used by ProcessWithHandler, defaults to 1
+	DeadLetterOnError      bool                   `json:"DeadLetterOnError"`      // dead letter instead of requeue on handler errors
+	DeadLetterExchange     string                 `json:"DeadLetterExchange"`     // if empty, relies on the queue's dead letter exchange
+	DeadLetterRoutingKey   string                 `json:"DeadLetterRoutingKey"`   // if empty, the original routing key is used
+	MaxRedeliveries        int                    `json:"MaxRedeliveries"`        // dead letter after this many redeliveries, if zero ignored
+	RetryExchange          string                 `json:"RetryExchange"`          // exchange Consumer.Retry republishes to, usually routing to a delay queue
+	RetryRoutingKey        string                 `json:"RetryRoutingKey"`        // if empty, the original routing key is used
+	MaxRetries             int                    `json:"MaxRetries"`             // Consumer.Retry dead letters after this many retries, if zero ignored
+	RequeueOnPanic         bool                   `json:"RequeueOnPanic"`         // requeue messages whose handler panicked, otherwise they are nacked without requeue
+	DropFiltered           bool                   `json:"DropFiltered"`           // nack messages rejected by the Consumer's filter without requeue, see Consumer.SetFilter
+	MaxDeliveriesPerSecond float64                `json:"MaxDeliveriesPerSecond"` // paces deliveries to the action or handler, waiting (never nacking) when over, 0 is unlimited
+	BufferSize             int                    `json:"BufferSize"`             // messages received and waiting to be consumed, defaults to 1000
+	DropWhenBufferFull     bool                   `json:"DropWhenBufferFull"`     // nack without requeue deliveries that don't fit in the buffer, otherwise the consumer waits and the prefetch backpressures the broker
+	SleepOnErrorInterval   uint32                 `json:"SleepOnErrorInterval"`   // sleep on error
+	SleepOnIdleInterval    uint32                 `json:"SleepOnIdleInterval"`    // sleep on idle
 }
 
 // PublisherConfig represents settings for configuring global settings for all Publishers with ease.
@@ -49,6 +109,16 @@ type PublisherConfig struct {
 	SleepOnIdleInterval    uint32 `json:"SleepOnIdleInterval"`
 	SleepOnErrorInterval   uint32 `json:"SleepOnErrorInterval"`
 	PublishTimeOutInterval uint32 `json:"PublishTimeOutInterval"`
+	MaxQueueSize           int    `json:"MaxQueueSize"`         // letters queued for AutoPublish, defaults to 1000
+	StreamChunkSize        int    `json:"StreamChunkSize"`      // bytes per PublishStream message, defaults to 1 MiB
+	MaxParallelPublishes   int    `json:"MaxParallelPublishes"` // letters AutoPublish publishes at once, defaults to half the pool's MaxCacheChannelCount plus one
+	DeduplicateLetters     bool   `json:"DeduplicateLetters"`   // QueueLetter skips a letter whose LetterID is already queued or publishing
+
+	// CircuitBreakerThreshold is how many publishes to an exchange and routing key fail in a row before their circuit
+	// opens and publishes fail fast with ErrCircuitOpen for CircuitBreakerCooldown (milliseconds, defaults to 5000).
+	// A single probe publish is then let through, closing the circuit on success. 0 disables the circuit breaker.
+	CircuitBreakerThreshold int    `json:"CircuitBreakerThreshold"`
+	CircuitBreakerCooldown  uint32 `json:"CircuitBreakerCooldown"`
 }
 
 // TopologyConfig allows you to build simple toplogies from a JSON file.
@@ -59,18 +129,29 @@ type TopologyConfig struct {
 	ExchangeBindings []*ExchangeBinding `json:"ExchangeBindings"`
 }
 
+// JSONConfig allows you to configure how RabbitService encodes payloads to JSON.
+type JSONConfig struct {
+	EscapeHTML bool   `json:"EscapeHTML"` // escape <, > and & like encoding/json, leave false for non-Go consumers
+	Indent     string `json:"Indent"`     // indents the JSON with this string (ex. two spaces) for debugging, empty is compact
+}
+
 // CompressionConfig allows you to configuration symmetric key encryption based on options
 type CompressionConfig struct {
-	Enabled bool   `json:"Enabled"`
-	Type    string `json:"Type,omitempty"`
+	Enabled      bool   `json:"Enabled"`
+	Type         string `json:"Type,omitempty"`         // gzip (default) or zstd, recorded in wrapped payloads for the reader
+	MinSizeBytes int    `json:"MinSizeBytes,omitempty"` // only payloads larger than this are compressed, 0 compresses everything
+	Checksum     string `json:"Checksum,omitempty"`     // crc32 or sha256 checksums the wrapped payload data, verified on unwrap, empty is none
 }
 
 // EncryptionConfig allows you to configuration symmetric key encryption based on options
 type EncryptionConfig struct {
 	Enabled           bool   `json:"Enabled"`
-	Type              string `json:"Type,omitempty"`
+	Type              string `json:"Type,omitempty"` // aes (default) or aesgcm, both seal with AES-GCM and prepend the nonce
 	Hashkey           []byte
-	TimeConsideration uint32 `json:"TimeConsideration,omitempty"`
-	MemoryMultiplier  uint32 `json:""`
-	Threads           uint8  `json:"Threads,omitempty"`
+	KeyID             string            `json:"KeyID,omitempty"`             // identifies the Hashkey in wrapped payloads, set by RabbitService
+	DecryptionKeys    map[string][]byte `json:"-"`                           // previous Hashkeys by KeyID, used to decrypt during a key rotation
+	TimeConsideration uint32            `json:"TimeConsideration,omitempty"` // Argon2 passes, defaults to 1
+	MemoryMultiplier  uint32            `json:""`                            // Argon2 memory in MiB, required, 64 or more is recommended
+	Threads           uint8             `json:"Threads,omitempty"`           // Argon2 parallelism, defaults to 1
+	KeyLength         uint32            `json:"KeyLength,omitempty"`         // Hashkey length in bytes, 16 (AES-128), 24 (AES-192) or 32 (AES-256, default)
 }
@@ -3,6 +3,10 @@ package tcr
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -15,10 +19,13 @@ type ConnectionHost struct {
 	ConnectionID       uint64
 	CachedChannelCount uint64
 	uri                string
+	uris               []string // tried in round-robin order starting at uriIndex
+	uriIndex           int
 	connectionName     string
 	heartbeatInterval  time.Duration
 	connectionTimeout  time.Duration
 	tlsConfig          *TLSConfig
+	dial               func(network, addr string) (net.Conn, error)
 	Errors             chan *amqp.Error
 	Blockers           chan amqp.Blocking
 	connLock           *sync.Mutex
@@ -33,8 +40,28 @@ func NewConnectionHost(
 	connectionTimeout time.Duration,
 	tlsConfig *TLSConfig) (*ConnectionHost, error) {
 
-	connHost := &ConnectionHost{
+	connHost := newConnectionHost(uri, connectionName, connectionID, heartbeatInterval, connectionTimeout, tlsConfig)
+
+	ok := connHost.Connect()
+	if !ok {
+		return nil, errors.New("unable to connect")
+	}
+
+	return connHost, nil
+}
+
+// newConnectionHost creates the ConnectionHost without connecting, the connection is established by Connect.
+func newConnectionHost(
+	uri string,
+	connectionName string,
+	connectionID uint64,
+	heartbeatInterval time.Duration,
+	connectionTimeout time.Duration,
+	tlsConfig *TLSConfig) *ConnectionHost {
+
+	return &ConnectionHost{
 		uri:               uri,
+		uris:              []string{uri},
 		connectionName:    connectionName,
 		ConnectionID:      connectionID,
 		heartbeatInterval: heartbeatInterval,
@@ -44,13 +71,6 @@ func NewConnectionHost(
 		Blockers:          make(chan amqp.Blocking, 10),
 		connLock:          &sync.Mutex{},
 	}
-
-	ok := connHost.Connect()
-	if !ok {
-		return nil, errors.New("unable to connect")
-	}
-
-	return connHost, nil
 }
 
 // Connect tries to connect (or reconnect) to the provided properties of the host one time.
@@ -76,31 +96,53 @@ func (ch *ConnectionHost) Connect() bool {
 
 	if ch.tlsConfig != nil && ch.tlsConfig.EnableTLS {
 
-		actualTLSConfig, err = CreateTLSConfig(
-			ch.tlsConfig.PEMCertLocation,
-			ch.tlsConfig.LocalCertLocation)
+		actualTLSConfig, err = NewTLSConfig(ch.tlsConfig)
 		if err != nil {
 			return false
 		}
 	}
 
-	if actualTLSConfig == nil {
-		amqpConn, err = amqp.DialConfig(ch.uri, amqp.Config{
-			Heartbeat: ch.heartbeatInterval,
-			Dial:      amqp.DefaultDial(ch.connectionTimeout),
-			Properties: amqp.Table{
-				"connection_name": ch.connectionName,
-			},
-		})
-	} else {
-		amqpConn, err = amqp.DialConfig("amqps://"+ch.tlsConfig.CertServerName, amqp.Config{
-			Heartbeat:       ch.heartbeatInterval,
-			Dial:            amqp.DefaultDial(ch.connectionTimeout),
-			TLSClientConfig: actualTLSConfig,
-			Properties: amqp.Table{
-				"connection_name": ch.connectionName,
-			},
-		})
+	config := amqp.Config{
+		Heartbeat: ch.heartbeatInterval,
+		Dial:      amqp.DefaultDial(ch.connectionTimeout),
+		Properties: amqp.Table{
+			"connection_name": ch.connectionName,
+		},
+	}
+
+	if actualTLSConfig != nil {
+		config.TLSClientConfig = actualTLSConfig
+	}
+
+	// Fail over to the next URI, the last successful one is tried first on reconnect.
+	for i := range ch.uris {
+		uriIndex := (ch.uriIndex + i) % len(ch.uris)
+		uri := ch.uris[uriIndex]
+
+		var uriConfig amqp.Config
+		uriConfig, err = applyURIOptions(uri, config)
+		if err != nil {
+			continue
+		}
+
+		if ch.dial != nil {
+			uriConfig.Dial = ch.dial
+		}
+
+		// A user supplied tls.Config dials the URI as is (amqps scheme expected).
+		if actualTLSConfig != nil && ch.tlsConfig.ClientConfig == nil {
+			uri, err = tlsURI(uri, ch.tlsConfig.CertServerName)
+			if err != nil {
+				continue
+			}
+		}
+
+		amqpConn, err = amqp.DialConfig(uri, uriConfig)
+		if err == nil {
+			ch.uri = ch.uris[uriIndex]
+			ch.uriIndex = uriIndex
+			break
+		}
 	}
 	if err != nil {
 		return false
@@ -116,6 +158,92 @@ func (ch *ConnectionHost) Connect() bool {
 	return true
 }
 
+// applyURIOptions applies the heartbeat (seconds), connection_timeout (milliseconds) and channel_max query parameters
+// of the URI to the config, amqp.ParseURI only reads the scheme, credentials, host, port and vhost.
+func applyURIOptions(uri string, config amqp.Config) (amqp.Config, error) {
+
+	if _, err := amqp.ParseURI(uri); err != nil {
+		return config, redactURIError(err)
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return config, redactURIError(err)
+	}
+
+	query := parsed.Query()
+	if value := query.Get("heartbeat"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return config, fmt.Errorf("uri heartbeat %q is invalid: %w", value, err)
+		}
+
+		config.Heartbeat = time.Duration(seconds) * time.Second
+	}
+
+	if value := query.Get("connection_timeout"); value != "" {
+		milliseconds, err := strconv.Atoi(value)
+		if err != nil {
+			return config, fmt.Errorf("uri connection_timeout %q is invalid: %w", value, err)
+		}
+
+		config.Dial = amqp.DefaultDial(time.Duration(milliseconds) * time.Millisecond)
+	}
+
+	if value := query.Get("channel_max"); value != "" {
+		channelMax, err := strconv.Atoi(value)
+		if err != nil {
+			return config, fmt.Errorf("uri channel_max %q is invalid: %w", value, err)
+		}
+
+		config.ChannelMax = channelMax
+	}
+
+	return config, nil
+}
+
+// redactURIError removes the URI, credentials included, quoted in url.Error.
+func redactURIError(err error) error {
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+
+	return err
+}
+
+// tlsURI points the URI at the CertServerName over amqps, keeping the URI's credentials and vhost.
+func tlsURI(uri string, certServerName string) (string, error) {
+
+	parsed, err := amqp.ParseURI(uri)
+	if err != nil {
+		return "", redactURIError(err)
+	}
+
+	parsed.Scheme = "amqps"
+	parsed.Host = certServerName
+	parsed.Port = 5671
+
+	if host, port, err := net.SplitHostPort(certServerName); err == nil {
+		parsed.Host = host
+		if parsed.Port, err = strconv.Atoi(port); err != nil {
+			return "", err
+		}
+	}
+
+	return parsed.String(), nil
+}
+
+// URI is the broker URI of the current connection.
+func (ch *ConnectionHost) URI() string {
+
+	ch.connLock.Lock()
+	defer ch.connLock.Unlock()
+
+	return ch.uri
+}
+
 // PauseOnFlowControl allows you to wait and sleep while receiving flow control messages.
 func (ch *ConnectionHost) PauseOnFlowControl() {
 
@@ -2,6 +2,8 @@ package tcr
 
 import (
 	"errors"
+	"fmt"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -23,6 +25,34 @@ type ConnectionPool struct {
 	flaggedConnections   map[uint64]bool
 	sleepOnErrorInterval time.Duration
 	errors               chan error
+	returns              chan *ReturnMessage
+	metrics              MetricsRecorder
+	logger               Logger
+	connectionHosts      []*ConnectionHost
+	channelHosts         []*ChannelHost
+	channelCounts        map[uint64]uint64
+	backoff              *Backoff
+	reconnectAttempts    chan *ReconnectAttempt
+	events               chan PoolEvent
+	lazyChannels         *sync.Once
+	leaseWaits           *leaseWaits
+	shutdownSignal       chan struct{}
+	shutdownOnce         *sync.Once
+	lastReconnect        time.Time
+}
+
+// HealthReport is a snapshot of the health of the ConnectionPool's connections and cached channels.
+type HealthReport struct {
+	Healthy            bool
+	LiveConnections    int
+	DeadConnections    int
+	PendingConnections int  // lazy connections not yet established
+	NotYetConnected    bool // lazy pool that hasn't been used yet
+	LiveChannels       int
+	DeadChannels       int
+	FlaggedConnections int
+	LastReconnect      time.Time
+	ChannelCounts      map[uint64]uint64 // cached channels by ConnectionID
 }
 
 func (cp *ConnectionPool) forwardError(err error) {
@@ -34,6 +64,68 @@ func (cp *ConnectionPool) Errors() <-chan error {
 	return cp.errors
 }
 
+// Returns yields all the messages returned (basic.return) by the server on the pool's channels.
+// Cached channels forward returns when given back with ReturnChannel.
+// Returns are dropped when this isn't being read and the buffer is full.
+func (cp *ConnectionPool) Returns() <-chan *ReturnMessage {
+	return cp.returns
+}
+
+// ReconnectAttempts yields the failed reconnect attempts for connections and cached channels.
+// Attempts are dropped when this isn't being read and the buffer is full.
+func (cp *ConnectionPool) ReconnectAttempts() <-chan *ReconnectAttempt {
+	return cp.reconnectAttempts
+}
+
+// Notify yields the ConnectionPool state transitions as connections are lost and recovered.
+// Events are dropped when this isn't being read and the buffer is full.
+func (cp *ConnectionPool) Notify() <-chan PoolEvent {
+	return cp.events
+}
+
+// WatchFlow sends a PoolChannelFlow event for every flow control notification of the channel, until flows is closed.
+// The ConnectionPool's cached and transient channels are already watched, watch another channel with:
+//
+//	cp.WatchFlow(connectionID, channel.NotifyFlow(make(chan bool, 1)))
+//
+// RabbitMQ deprecated channel.flow in favor of blocking publishing connections on memory and disk alarms, so the
+// server rarely sends it, but when it does publishes on the channel stall until it resumes.
+func (cp *ConnectionPool) WatchFlow(connectionID uint64, flows <-chan bool) {
+
+	go func() {
+		for active := range flows {
+			if active {
+				cp.logger.Infof("connection %d channel flow resumed", connectionID)
+			} else {
+				cp.logger.Warnf("connection %d channel flow paused by the server", connectionID)
+			}
+
+			select {
+			case cp.events <- PoolEvent{Type: PoolChannelFlow, ConnectionID: connectionID, Time: time.Now(), FlowActive: active}:
+			default: // drop when nobody is listening
+			}
+		}
+	}()
+}
+
+// watchChannelFlow watches the flow control notifications of the ChannelHost's current channel.
+func (cp *ConnectionPool) watchChannelFlow(chanHost *ChannelHost) {
+
+	cp.WatchFlow(chanHost.ConnectionID, chanHost.Channel.NotifyFlow(make(chan bool, 10)))
+}
+
+func (cp *ConnectionPool) notify(eventType PoolEventType, connectionID uint64) {
+	cp.notifyDetail(eventType, connectionID, "")
+}
+
+func (cp *ConnectionPool) notifyDetail(eventType PoolEventType, connectionID uint64, detail string) {
+
+	select {
+	case cp.events <- PoolEvent{Type: eventType, ConnectionID: connectionID, Time: time.Now(), Detail: detail}:
+	default: // drop when nobody is listening
+	}
+}
+
 // NewConnectionPool creates hosting structure for the ConnectionPool.
 func NewConnectionPool(config *PoolConfig) (*ConnectionPool, error) {
 
@@ -45,6 +137,17 @@ func NewConnectionPool(config *PoolConfig) (*ConnectionPool, error) {
 		return nil, errors.New("connectionpool maxconnectioncount can't be 0")
 	}
 
+	uris := config.URIs
+	if len(uris) == 0 {
+		uris = []string{config.URI}
+	}
+
+	for _, uri := range uris {
+		if _, err := applyURIOptions(uri, amqp.Config{}); err != nil {
+			return nil, fmt.Errorf("connectionpool uri is invalid: %w", err)
+		}
+	}
+
 	cp := &ConnectionPool{
 		Config:               *config,
 		uri:                  config.URI,
@@ -54,32 +157,50 @@ func NewConnectionPool(config *PoolConfig) (*ConnectionPool, error) {
 		channels:             make(chan *ChannelHost, config.MaxCacheChannelCount),
 		poolRWLock:           &sync.RWMutex{},
 		flaggedConnections:   make(map[uint64]bool),
+		channelCounts:        make(map[uint64]uint64),
+		backoff:              NewBackoffFromConfig(config),
+		reconnectAttempts:    make(chan *ReconnectAttempt, 100),
+		events:               make(chan PoolEvent, 100),
 		sleepOnErrorInterval: time.Duration(config.SleepOnErrorInterval) * time.Millisecond,
 		errors:               make(chan error),
+		returns:              make(chan *ReturnMessage, 1000),
+		metrics:              NoopMetricsRecorder{},
+		logger:               NoopLogger{},
+		leaseWaits:           newLeaseWaits(),
+		shutdownSignal:       make(chan struct{}),
+		shutdownOnce:         &sync.Once{},
 	}
 
 	if ok := cp.initializeConnections(); !ok {
 		return nil, errors.New("initialization failed during connection creation")
 	}
 
+	// Lazy pools create the cached channels, and so connect, on first use.
+	if cp.Config.Lazy {
+		cp.lazyChannels = &sync.Once{}
+	} else {
+		cp.initializeChannels()
+	}
+
+	if cp.Config.ChannelLeaseTimeout > 0 {
+		go cp.monitorChannelLeases(time.Duration(cp.Config.ChannelLeaseTimeout) * time.Millisecond)
+	}
+
 	return cp, nil
 }
 
 func (cp *ConnectionPool) initializeConnections() bool {
 
+	cp.poolRWLock.Lock()
 	cp.connectionID = 0
 	cp.connections = queue.New(int64(cp.Config.MaxConnectionCount))
+	cp.connectionHosts = make([]*ConnectionHost, 0, cp.Config.MaxConnectionCount)
+	cp.channelHosts = make([]*ChannelHost, 0, cp.Config.MaxCacheChannelCount)
+	cp.channelCounts = make(map[uint64]uint64)
+	cp.poolRWLock.Unlock()
 
 	for i := uint64(0); i < cp.Config.MaxConnectionCount; i++ {
-
-		connectionHost, err := NewConnectionHost(
-			cp.uri,
-			cp.Config.ConnectionName+"-"+strconv.FormatUint(cp.connectionID, 10),
-			cp.connectionID,
-			cp.heartbeatInterval,
-			cp.connectionTimeout,
-			cp.Config.TLSConfig)
-
+		connectionHost, err := cp.addConnection(!cp.Config.Lazy)
 		if err != nil {
 			return false
 		}
@@ -87,15 +208,127 @@ func (cp *ConnectionPool) initializeConnections() bool {
 		if err = cp.connections.Put(connectionHost); err != nil {
 			return false
 		}
-
-		cp.connectionID++
 	}
 
+	cp.metrics.SetConnectionPoolSize(int(cp.connections.Len()))
+
+	return true
+}
+
+// initializeChannels creates the cached channels for the pool.
+func (cp *ConnectionPool) initializeChannels() {
+
 	for i := uint64(0); i < cp.Config.MaxCacheChannelCount; i++ {
-		cp.channels <- cp.createCacheChannel(i)
+		chanHost := cp.createCacheChannel(i)
+
+		cp.poolRWLock.Lock()
+		cp.channelHosts = append(cp.channelHosts, chanHost)
+		cp.channelCounts[chanHost.ConnectionID]++
+		cp.poolRWLock.Unlock()
+
+		cp.channels <- chanHost
 	}
+}
 
-	return true
+// addConnection creates a new connection and registers it with the pool, connecting when connect is true.
+// Connections that aren't connected yet are established by GetConnection on first use.
+// The caller puts the connection in the queue, either directly or with ReturnConnection.
+func (cp *ConnectionPool) addConnection(connect bool) (*ConnectionHost, error) {
+
+	cp.poolRWLock.Lock()
+	connectionID := cp.connectionID
+	cp.connectionID++
+	cp.poolRWLock.Unlock()
+
+	connectionHost := newConnectionHost(
+		cp.uri,
+		cp.connectionNamePrefix()+"-"+strconv.FormatUint(connectionID, 10),
+		connectionID,
+		cp.heartbeatInterval,
+		cp.connectionTimeout,
+		cp.Config.TLSConfig)
+	connectionHost.dial = cp.Config.Dial
+
+	// Connections are spread across the URIs, each starting on the next one.
+	if len(cp.Config.URIs) > 0 {
+		connectionHost.uris = cp.Config.URIs
+		connectionHost.uriIndex = int(connectionID % uint64(len(cp.Config.URIs)))
+		connectionHost.uri = connectionHost.uris[connectionHost.uriIndex]
+	}
+
+	if connect {
+		if !connectionHost.Connect() {
+			return nil, errors.New("unable to connect")
+		}
+
+		cp.notify(PoolConnected, connectionID)
+	}
+
+	cp.poolRWLock.Lock()
+	cp.connectionHosts = append(cp.connectionHosts, connectionHost)
+	cp.poolRWLock.Unlock()
+
+	return connectionHost, nil
+}
+
+// getConnectionForCacheChannel gets the next connection with fewer than MaxChannelsPerConnection cached channels.
+// When every connection is full an overflow connection is opened, up to MaxOverflowConnectionCount, otherwise
+// the channel goes on the next connection regardless.
+func (cp *ConnectionPool) getConnectionForCacheChannel() (*ConnectionHost, error) {
+
+	if cp.Config.MaxChannelsPerConnection == 0 {
+		return cp.GetConnection()
+	}
+
+	cp.poolRWLock.RLock()
+	connectionCount := len(cp.connectionHosts)
+	cp.poolRWLock.RUnlock()
+
+	for i := 0; i < connectionCount; i++ {
+		connHost, err := cp.GetConnection()
+		if err != nil {
+			return nil, err
+		}
+
+		cp.poolRWLock.RLock()
+		full := cp.channelCounts[connHost.ConnectionID] >= cp.Config.MaxChannelsPerConnection
+		cp.poolRWLock.RUnlock()
+
+		if !full {
+			return connHost, nil
+		}
+
+		cp.ReturnConnection(connHost, false)
+	}
+
+	if uint64(connectionCount) < cp.Config.MaxConnectionCount+cp.Config.MaxOverflowConnectionCount {
+		connHost, err := cp.addConnection(true)
+		if err != nil {
+			return nil, err
+		}
+
+		cp.metrics.SetConnectionPoolSize(connectionCount + 1)
+
+		return connHost, nil
+	}
+
+	return cp.GetConnection()
+}
+
+// connectionNamePrefix is the ConnectionName from config or tcr-<hostname> when not provided.
+// Each connection appends its index to the prefix for the connection_name property.
+func (cp *ConnectionPool) connectionNamePrefix() string {
+
+	if cp.Config.ConnectionName != "" {
+		return cp.Config.ConnectionName
+	}
+
+	hostName, err := os.Hostname()
+	if err != nil {
+		return "tcr"
+	}
+
+	return "tcr-" + hostName
 }
 
 // GetConnection gets a connection based on whats in the ConnectionPool (blocking under bad network conditions).
@@ -145,7 +378,12 @@ func (cp *ConnectionPool) verifyHealthyConnection(connHost *ConnectionHost) {
 	flagged := cp.isConnectionFlagged(connHost.ConnectionID)
 
 	// Between these three states we do our best to determine that a connection is dead in the various lifecycles.
-	if flagged || !healthy || connHost.Connection.IsClosed( /* atomic */ ) {
+	if flagged || !healthy || connHost.Connection == nil || connHost.Connection.IsClosed( /* atomic */ ) {
+		if connHost.Connection != nil {
+			cp.logger.Warnf("connection %d is disconnected, reconnecting", connHost.ConnectionID)
+			cp.notify(PoolDisconnected, connHost.ConnectionID)
+		}
+
 		cp.triggerConnectionRecovery(connHost)
 	}
 
@@ -154,18 +392,27 @@ func (cp *ConnectionPool) verifyHealthyConnection(connHost *ConnectionHost) {
 
 func (cp *ConnectionPool) triggerConnectionRecovery(connHost *ConnectionHost) {
 
+	if connHost.Connection != nil { // lazy connections connect for the first time here
+		cp.notify(PoolReconnecting, connHost.ConnectionID)
+	}
+
 	// InfiniteLoop: Stay here till we reconnect.
-	for {
+	for attempt := 1; ; attempt++ {
 		ok := connHost.Connect()
 		if !ok {
-			if cp.sleepOnErrorInterval > 0 {
-				time.Sleep(cp.sleepOnErrorInterval)
-			}
+			cp.sleepBeforeReconnect(connHost.ConnectionID, attempt)
 			continue
 		}
 		break
 	}
 
+	cp.poolRWLock.Lock()
+	cp.lastReconnect = time.Now()
+	cp.poolRWLock.Unlock()
+
+	cp.logger.Infof("connection %d connected", connHost.ConnectionID)
+	cp.notify(PoolConnected, connHost.ConnectionID)
+
 	// Flush any pending errors.
 	for {
 		select {
@@ -177,6 +424,100 @@ func (cp *ConnectionPool) triggerConnectionRecovery(connHost *ConnectionHost) {
 	}
 }
 
+// Healthy indicates the ConnectionPool has connections and all of them are currently open.
+func (cp *ConnectionPool) Healthy() bool {
+	return cp.HealthReport().Healthy
+}
+
+// Ping opens and closes a channel on a live connection, a round trip proving the broker is responsive and not just
+// that a TCP connection exists. Errors when no connection is live, it doesn't wait for a reconnect.
+func (cp *ConnectionPool) Ping() error {
+
+	var connection *amqp.Connection
+
+	cp.poolRWLock.RLock()
+	for _, connHost := range cp.connectionHosts {
+		if connHost.Connection != nil && !connHost.Connection.IsClosed( /* atomic */ ) {
+			connection = connHost.Connection
+			break
+		}
+	}
+	cp.poolRWLock.RUnlock()
+
+	if connection == nil {
+		return errors.New("connectionpool has no live connection")
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	return channel.Close()
+}
+
+// HealthReport reports the number of live and dead connections and cached channels, how many connections are
+// flagged for recovery, and when a connection was last reconnected.
+func (cp *ConnectionPool) HealthReport() *HealthReport {
+	cp.poolRWLock.RLock()
+	defer cp.poolRWLock.RUnlock()
+
+	report := &HealthReport{
+		LastReconnect: cp.lastReconnect,
+		ChannelCounts: make(map[uint64]uint64, len(cp.channelCounts)),
+	}
+
+	for connectionID, count := range cp.channelCounts {
+		report.ChannelCounts[connectionID] = count
+	}
+
+	for _, connHost := range cp.connectionHosts {
+		if connHost.Connection == nil {
+			report.PendingConnections++
+		} else if connHost.Connection.IsClosed( /* atomic */ ) {
+			report.DeadConnections++
+		} else {
+			report.LiveConnections++
+		}
+	}
+
+	// A channel is considered dead when its connection is closed or a close notification is pending.
+	for _, chanHost := range cp.channelHosts {
+		if chanHost.connHost.Connection == nil || chanHost.connHost.Connection.IsClosed() || len(chanHost.Errors) > 0 {
+			report.DeadChannels++
+		} else {
+			report.LiveChannels++
+		}
+	}
+
+	for _, flagged := range cp.flaggedConnections {
+		if flagged {
+			report.FlaggedConnections++
+		}
+	}
+
+	report.Healthy = report.LiveConnections > 0 && report.DeadConnections == 0
+	report.NotYetConnected = report.PendingConnections > 0 && report.LiveConnections == 0 && report.DeadConnections == 0
+
+	return report
+}
+
+// ConnectionCount is the number of connections opened by the pool, including overflow connections.
+func (cp *ConnectionPool) ConnectionCount() int {
+	cp.poolRWLock.RLock()
+	defer cp.poolRWLock.RUnlock()
+
+	return len(cp.connectionHosts)
+}
+
+// ChannelCount is the number of cached channels in the pool.
+func (cp *ConnectionPool) ChannelCount() int {
+	cp.poolRWLock.RLock()
+	defer cp.poolRWLock.RUnlock()
+
+	return len(cp.channelHosts)
+}
+
 // ReturnConnection puts the connection back in the queue and flag it for error.
 // This helps maintain a Round Robin on Connections and their resources.
 func (cp *ConnectionPool) ReturnConnection(connHost *ConnectionHost, flag bool) {
@@ -194,7 +535,25 @@ func (cp *ConnectionPool) ReturnConnection(connHost *ConnectionHost, flag bool)
 // If you want a transient Ackable channel (un-managed), use CreateChannel directly.
 func (cp *ConnectionPool) GetChannelFromPool() *ChannelHost {
 
-	return <-cp.channels
+	cp.connectLazy()
+
+	leaseStart := time.Now()
+	chanHost := <-cp.channels
+	cp.leaseWaits.record(time.Since(leaseStart))
+
+	if cp.Config.ChannelLeaseTimeout > 0 {
+		chanHost.lease(leaseCaller(1))
+	}
+
+	return chanHost
+}
+
+// connectLazy creates the cached channels of a lazy pool, connecting it, the first time it's called.
+func (cp *ConnectionPool) connectLazy() {
+
+	if cp.lazyChannels != nil {
+		cp.lazyChannels.Do(cp.initializeChannels)
+	}
 }
 
 // ReturnChannel returns a Channel.
@@ -203,7 +562,18 @@ func (cp *ConnectionPool) GetChannelFromPool() *ChannelHost {
 func (cp *ConnectionPool) ReturnChannel(chanHost *ChannelHost, erred bool) {
 
 	// If called by user with the wrong channel don't add a non-managed channel back to the channel cache.
+	if chanHost.CachedChannel && !chanHost.release() {
+		go func(*ChannelHost) {
+			defer func() { _ = recover() }()
+
+			chanHost.Close()
+		}(chanHost)
+		return // reclaimed as leaked, already replaced in the cache
+	}
+
 	if chanHost.CachedChannel {
+		chanHost.FlushReturns(cp.returns)
+
 		if erred {
 			cp.reconnectChannel(chanHost) // <- blocking operation
 		} else {
@@ -223,24 +593,50 @@ func (cp *ConnectionPool) ReturnChannel(chanHost *ChannelHost, erred bool) {
 
 func (cp *ConnectionPool) reconnectChannel(chanHost *ChannelHost) {
 
+	cp.notify(PoolChannelClosed, chanHost.ConnectionID)
+
 	// InfiniteLoop: Stay here till we reconnect.
-	for {
+	for attempt := 1; ; attempt++ {
 		cp.verifyHealthyConnection(chanHost.connHost) // <- blocking operation
 
 		err := chanHost.MakeChannel() // Creates a new channel and flushes internal buffers automatically.
 		if err != nil {
+			cp.sleepBeforeReconnect(chanHost.ConnectionID, attempt)
 			continue
 		}
+
+		cp.watchChannelFlow(chanHost)
 		break
 	}
 }
 
+// sleepBeforeReconnect reports the failed reconnect attempt and waits the backoff delay before the next one.
+func (cp *ConnectionPool) sleepBeforeReconnect(connectionID uint64, attempt int) {
+
+	delay := cp.backoff.Delay(attempt)
+	cp.logger.Warnf("connection %d reconnect attempt %d failed, retrying in %s", connectionID, attempt, delay)
+
+	select {
+	case cp.reconnectAttempts <- &ReconnectAttempt{
+		ConnectionID: connectionID,
+		Attempt:      attempt,
+		Delay:        delay,
+		Time:         time.Now(),
+	}:
+	default: // drop when nobody is listening
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
 // createCacheChannel allows you create a cached ChannelHost which helps wrap Amqp Channel functionality.
 func (cp *ConnectionPool) createCacheChannel(id uint64) *ChannelHost {
 
 	// InfiniteLoop: Stay till we have a good channel.
 	for {
-		connHost, err := cp.GetConnection()
+		connHost, err := cp.getConnectionForCacheChannel()
 		if err != nil {
 			cp.forwardError(err)
 
@@ -262,6 +658,7 @@ func (cp *ConnectionPool) createCacheChannel(id uint64) *ChannelHost {
 		}
 
 		cp.ReturnConnection(connHost, false)
+		cp.watchChannelFlow(chanHost)
 		return chanHost
 	}
 }
@@ -294,6 +691,11 @@ func (cp *ConnectionPool) GetTransientChannel(ackable bool) *amqp.Channel {
 
 		cp.ReturnConnection(connHost, false)
 
+		amqpReturns := make(chan amqp.Return, 100)
+		channel.NotifyReturn(amqpReturns)
+		go forwardReturns(amqpReturns, cp.returns)
+		cp.WatchFlow(connHost.ConnectionID, channel.NotifyFlow(make(chan bool, 10)))
+
 		if ackable {
 			err := channel.Confirm(false)
 			if err != nil {
@@ -337,6 +739,9 @@ func (cp *ConnectionPool) isConnectionFlagged(connectionID uint64) bool {
 // Shutdown closes all connections in the ConnectionPool and resets the Pool to pre-initialized state.
 func (cp *ConnectionPool) Shutdown() {
 
+	cp.shutdownOnce.Do(func() { close(cp.shutdownSignal) })
+	cp.logger.Infof("connectionpool shutting down, closing channels and connections")
+
 	wg := &sync.WaitGroup{}
 
 ChannelFlushLoop:
@@ -372,7 +777,7 @@ ChannelFlushLoop:
 				defer wg.Done()
 				defer func() { _ = recover() }()
 
-				if !connectionHost.Connection.IsClosed() {
+				if connectionHost.Connection != nil && !connectionHost.Connection.IsClosed() {
 					connectionHost.Connection.Close()
 				}
 			}(connectionHost)
@@ -382,7 +787,34 @@ ChannelFlushLoop:
 
 	wg.Wait()
 
+	cp.poolRWLock.Lock()
 	cp.connections = queue.New(int64(cp.Config.MaxConnectionCount))
 	cp.flaggedConnections = make(map[uint64]bool)
+	cp.connectionHosts = nil
+	cp.channelHosts = nil
+	cp.channelCounts = make(map[uint64]uint64)
 	cp.connectionID = 0
+	cp.poolRWLock.Unlock()
+
+	cp.metrics.SetConnectionPoolSize(0)
+	cp.logger.Infof("connectionpool shut down")
+}
+
+// SetMetricsRecorder sets the MetricsRecorder used to record the ConnectionPool size.
+func (cp *ConnectionPool) SetMetricsRecorder(metrics MetricsRecorder) {
+	if metrics == nil {
+		metrics = NoopMetricsRecorder{}
+	}
+
+	cp.metrics = metrics
+	cp.metrics.SetConnectionPoolSize(int(cp.connections.Len()))
+}
+
+// SetLogger sets the Logger receiving reconnects, leaked channels, and shutdown steps.
+func (cp *ConnectionPool) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NoopLogger{}
+	}
+
+	cp.logger = logger
 }
@@ -1,14 +1,45 @@
 package tcr
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/streadway/amqp"
 )
 
+const (
+	// DeadLetterReasonHeader is the header annotating why a message was dead lettered by the Consumer.
+	DeadLetterReasonHeader = "x-tcr-death-reason"
+
+	// DeadLetterQueueHeader is the header annotating which queue a message was dead lettered from by the Consumer.
+	DeadLetterQueueHeader = "x-tcr-death-queue"
+
+	// DeadLetterTimeHeader is the header annotating when a message was dead lettered by the Consumer.
+	DeadLetterTimeHeader = "x-tcr-death-time"
+
+	// RetryCountHeader is the header counting how many times a message was republished by Consumer.Retry.
+	RetryCountHeader = "x-retry-count"
+
+	// DeliveryCountHeader is the header RabbitMQ quorum queues use to count redeliveries.
+	DeliveryCountHeader = "x-delivery-count"
+
+	// maxTrackedRedeliveries bounds the in-memory redelivery tracking of messages without a delivery count header.
+	maxTrackedRedeliveries = 10000
+
+	defaultBufferSize = 1000
+
+	// batchStopTimeout bounds how long StopConsuming waits for ConsumeBatch to handle its partial batch.
+	batchStopTimeout = time.Second * 5
+)
+
+// ErrInvalidQuorumConsumer indicates a Consumer of a quorum queue was configured with what quorum queues don't support.
+var ErrInvalidQuorumConsumer = errors.New("quorum queue consumers can't use global qos or a server named queue")
+
 // Consumer receives messages from a RabbitMQ location.
 type Consumer struct {
 	Config               *ConsumerConfig
@@ -21,14 +52,38 @@ type Consumer struct {
 	sleepOnIdleInterval  time.Duration
 	messageGroup         *sync.WaitGroup
 	receivedMessages     chan *ReceivedMessage
+	messages             chan *ReceivedMessage
 	consumeStop          chan bool
 	stopImmediate        bool
+	requeueInFlight      bool
+	dropWhenBufferFull   bool
 	started              bool
 	autoAck              bool
 	exclusive            bool
 	noWait               bool
 	args                 amqp.Table
 	qosCountOverride     int
+	prefetchCount        int
+	prefetchSize         int
+	globalQos            bool
+	quorumQueue          bool
+	maxRedeliveries      int
+	redeliveries         map[string]int
+	metrics              MetricsRecorder
+	serverNamedQueue     bool
+	paused               bool
+	draining             bool
+	deliveriesCancelled  bool
+	drained              bool
+	processing           bool
+	action               func(*ReceivedMessage) // of StartConsumingWithAction, kept for a restart
+	batchStop            chan chan struct{}
+	inFlight             int64
+	deliveryInterval     time.Duration // minimum time between deliveries, from MaxDeliveriesPerSecond
+	nextDelivery         time.Time
+	consumerTag          string
+	middleware           []Middleware
+	filter               func(*ReceivedMessage) bool
 	conLock              *sync.Mutex
 }
 
@@ -45,13 +100,23 @@ func NewConsumerFromConfig(config *ConsumerConfig, cp *ConnectionPool) *Consumer
 		sleepOnErrorInterval: time.Duration(config.SleepOnErrorInterval) * time.Millisecond,
 		sleepOnIdleInterval:  time.Duration(config.SleepOnIdleInterval) * time.Millisecond,
 		messageGroup:         &sync.WaitGroup{},
-		receivedMessages:     make(chan *ReceivedMessage, 1000),
+		receivedMessages:     make(chan *ReceivedMessage, bufferSize(config.BufferSize)),
+		dropWhenBufferFull:   config.DropWhenBufferFull,
 		consumeStop:          make(chan bool, 1),
 		autoAck:              config.AutoAck,
 		exclusive:            config.Exclusive,
 		noWait:               config.NoWait,
 		args:                 amqp.Table(config.Args),
 		qosCountOverride:     config.QosCountOverride,
+		prefetchCount:        config.PrefetchCount,
+		prefetchSize:         config.PrefetchSize,
+		globalQos:            config.GlobalQos,
+		quorumQueue:          config.QuorumQueue,
+		maxRedeliveries:      config.MaxRedeliveries,
+		redeliveries:         make(map[string]int),
+		deliveryInterval:     deliveryInterval(config.MaxDeliveriesPerSecond),
+		metrics:              NoopMetricsRecorder{},
+		serverNamedQueue:     config.QueueName == "",
 		conLock:              &sync.Mutex{},
 	}
 }
@@ -86,7 +151,8 @@ func NewConsumer(
 		sleepOnErrorInterval: time.Duration(sleepOnErrorInterval) * time.Millisecond,
 		sleepOnIdleInterval:  time.Duration(sleepOnIdleInterval) * time.Millisecond,
 		messageGroup:         &sync.WaitGroup{},
-		receivedMessages:     make(chan *ReceivedMessage, 1000),
+		receivedMessages:     make(chan *ReceivedMessage, bufferSize(config.BufferSize)),
+		dropWhenBufferFull:   config.DropWhenBufferFull,
 		consumeStop:          make(chan bool, 1),
 		stopImmediate:        false,
 		started:              false,
@@ -95,6 +161,15 @@ func NewConsumer(
 		noWait:               noWait,
 		args:                 args,
 		qosCountOverride:     qosCountOverride,
+		prefetchCount:        config.PrefetchCount,
+		prefetchSize:         config.PrefetchSize,
+		globalQos:            config.GlobalQos,
+		quorumQueue:          config.QuorumQueue,
+		maxRedeliveries:      config.MaxRedeliveries,
+		redeliveries:         make(map[string]int),
+		deliveryInterval:     deliveryInterval(config.MaxDeliveriesPerSecond),
+		metrics:              NoopMetricsRecorder{},
+		serverNamedQueue:     queuename == "",
 		conLock:              &sync.Mutex{},
 	}, nil
 }
@@ -155,8 +230,15 @@ GetBatchLoop:
 	return messages, nil
 }
 
-// StartConsuming starts the Consumer.
+// StartConsuming starts the Consumer. A Consumer of a quorum queue configured with what quorum queues don't support
+// isn't started, an error wrapping ErrInvalidQuorumConsumer is sent to Errors instead.
 func (con *Consumer) StartConsuming() {
+
+	if err := con.validateQuorumQueue(); err != nil {
+		con.errors <- con.consumerError(err)
+		return
+	}
+
 	con.conLock.Lock()
 	defer con.conLock.Unlock()
 
@@ -164,6 +246,8 @@ func (con *Consumer) StartConsuming() {
 
 		con.FlushErrors()
 		con.FlushStop()
+		con.drained = false
+		con.action = nil
 
 		go con.startConsumeLoop(nil)
 		con.started = true
@@ -171,7 +255,15 @@ func (con *Consumer) StartConsuming() {
 }
 
 // StartConsumingWithAction starts the Consumer invoking a method on every ReceivedMessage.
+// A panicking action is recovered, the panic is sent to Errors and an ackable message nacked. Like StartConsuming,
+// a misconfigured quorum queue Consumer isn't started.
 func (con *Consumer) StartConsumingWithAction(action func(*ReceivedMessage)) {
+
+	if err := con.validateQuorumQueue(); err != nil {
+		con.errors <- con.consumerError(err)
+		return
+	}
+
 	con.conLock.Lock()
 	defer con.conLock.Unlock()
 
@@ -179,12 +271,389 @@ func (con *Consumer) StartConsumingWithAction(action func(*ReceivedMessage)) {
 
 		con.FlushErrors()
 		con.FlushStop()
+		con.drained = false
+		con.action = action
 
 		go con.startConsumeLoop(action)
 		con.started = true
 	}
 }
 
+// ProcessWithHandler starts the Consumer and fans out ReceivedMessages across workers invoking the handler, blocking
+// until ctx is done and then stopping the Consumer. If workers is less than 1, ConcurrentHandlers from config is used.
+// Ackable messages are acknowledged when the handler returns nil and nacked with requeue on error, always on the
+// channel they were received on. With more than one worker, messages are handled concurrently so processing and
+// acknowledgement order is not guaranteed to match delivery order; use a single worker when order matters.
+// The handler is wrapped by the middleware added with Use. A panicking handler is recovered, the panic is sent to
+// Errors and the message nacked, requeued only when RequeueOnPanic is set.
+func (con *Consumer) ProcessWithHandler(ctx context.Context, handler func(*ReceivedMessage) error, workers int) error {
+
+	if handler == nil {
+		return errors.New("can't process messages with a nil handler")
+	}
+
+	if err := con.validateQuorumQueue(); err != nil {
+		return err
+	}
+
+	if workers < 1 && con.Config != nil {
+		workers = con.Config.ConcurrentHandlers
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	handler = con.chainHandler(handler)
+
+	con.conLock.Lock()
+	con.processing = true
+	con.conLock.Unlock()
+
+	defer func() {
+		con.conLock.Lock()
+		con.processing = false
+		con.conLock.Unlock()
+	}()
+
+	con.StartConsuming()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-con.receivedMessages:
+					con.handleMessage(msg, handler)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// A drained Consumer is already stopped.
+	if !con.isDrained() {
+		if err := con.StopConsuming(false, false); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// handleMessage invokes the handler and then acks or nacks the message (if ackable) based on the result.
+func (con *Consumer) handleMessage(msg *ReceivedMessage, handler func(*ReceivedMessage) error) {
+
+	defer atomic.AddInt64(&con.inFlight, -1)
+	defer con.recoverPanic(msg)
+
+	handlerErr := handler(msg)
+	if !msg.IsAckable {
+		return
+	}
+
+	var err error
+	if handlerErr != nil {
+		if con.Config != nil && con.Config.DeadLetterOnError {
+			err = con.DeadLetter(msg, handlerErr.Error())
+		} else {
+			err = msg.Nack(true)
+		}
+	} else {
+		err = msg.Acknowledge()
+	}
+
+	if err != nil {
+		con.errors <- con.messageError(msg, err)
+	}
+}
+
+// ConsumeBatch starts the Consumer and invokes the handler with batches of up to maxSize ReceivedMessages, blocking
+// until ctx is done and then stopping the Consumer. A batch is handled once full, or maxWait after its first message.
+// Ackable batches are acknowledged at once, with a multiple ack of the batch's last delivery tag, when the handler
+// returns nil and nacked with requeue on error or panic. When the channel drops mid-batch the partial batch is
+// discarded instead of handled, the server has already requeued its messages for redelivery. The partial batch is
+// still handled when ctx is done, or before StopConsuming completes, which also returns ConsumeBatch with nil.
+func (con *Consumer) ConsumeBatch(ctx context.Context, maxSize int, maxWait time.Duration, handler func([]*ReceivedMessage) error) error {
+
+	if handler == nil {
+		return errors.New("can't consume batches with a nil handler")
+	}
+
+	if maxSize < 1 || maxWait <= 0 {
+		return errors.New("can't consume batches whose size is less than 1 or max wait isn't positive")
+	}
+
+	if err := con.validateQuorumQueue(); err != nil {
+		return err
+	}
+
+	batchStop := make(chan chan struct{}, 1)
+
+	con.conLock.Lock()
+	con.processing = true
+	con.batchStop = batchStop
+	con.conLock.Unlock()
+
+	defer func() {
+		con.conLock.Lock()
+		con.processing = false
+		con.batchStop = nil
+		con.conLock.Unlock()
+	}()
+
+	con.StartConsuming()
+
+	batch := make([]*ReceivedMessage, 0, maxSize)
+	var batchTimeout <-chan time.Time
+	var batchHandled chan struct{}
+
+BatchLoop:
+	for {
+		select {
+		case <-ctx.Done():
+			break BatchLoop
+
+		case batchHandled = <-batchStop:
+			break BatchLoop
+
+		case <-batchTimeout:
+			con.flushBatch(batch, handler)
+			batch = make([]*ReceivedMessage, 0, maxSize)
+			batchTimeout = nil
+
+		case msg := <-con.receivedMessages:
+			// Messages are only received on one channel at a time, a new channel means the last one dropped.
+			if len(batch) > 0 && msg.amqpChan != batch[0].amqpChan {
+				con.discardBatch(batch)
+				batch = batch[:0]
+			}
+
+			if len(batch) == 0 {
+				batchTimeout = time.After(maxWait)
+			}
+
+			batch = append(batch, msg)
+			if len(batch) >= maxSize {
+				con.flushBatch(batch, handler)
+				batch = make([]*ReceivedMessage, 0, maxSize)
+				batchTimeout = nil
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		con.flushBatch(batch, handler)
+	}
+
+	// Stopping from here mustn't wait on this loop, but a StopConsuming racing ctx may already be waiting on it.
+	con.conLock.Lock()
+	con.batchStop = nil
+	con.conLock.Unlock()
+
+	if batchHandled == nil {
+		select {
+		case batchHandled = <-batchStop:
+		default:
+		}
+	}
+
+	if batchHandled != nil {
+		close(batchHandled)
+		return nil
+	}
+
+	// A drained Consumer is already stopped.
+	if !con.isDrained() {
+		if err := con.StopConsuming(false, false); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// flushBatch handles the batch, unless the channel it was received on dropped since.
+func (con *Consumer) flushBatch(batch []*ReceivedMessage, handler func([]*ReceivedMessage) error) {
+
+	if batch[0].channelDropped() {
+		con.discardBatch(batch)
+		return
+	}
+
+	con.handleBatch(batch, handler)
+}
+
+// discardBatch drops a batch received on a channel that dropped, the server has already requeued its messages for
+// redelivery.
+func (con *Consumer) discardBatch(batch []*ReceivedMessage) {
+
+	atomic.AddInt64(&con.inFlight, -int64(len(batch)))
+	con.errors <- con.messageError(batch[0], fmt.Errorf("consumer's channel dropped, discarded a partial batch of %d requeued messages", len(batch)))
+}
+
+// handleBatch invokes the handler and then acks or nacks the batch (if ackable) up to its last delivery tag.
+func (con *Consumer) handleBatch(batch []*ReceivedMessage, handler func([]*ReceivedMessage) error) {
+
+	defer atomic.AddInt64(&con.inFlight, -int64(len(batch)))
+
+	handlerErr := con.invokeBatchHandler(handler, batch)
+
+	last := batch[len(batch)-1]
+	if !last.IsAckable {
+		return
+	}
+
+	var err error
+	if handlerErr != nil {
+		err = last.amqpChan.Nack(last.deliveryTag, true, true)
+	} else {
+		err = last.amqpChan.Ack(last.deliveryTag, true)
+	}
+
+	if err != nil {
+		con.errors <- con.messageError(last, err)
+	}
+}
+
+// invokeBatchHandler invokes the handler for the batch, a panic is recovered, sent to Errors and returned.
+func (con *Consumer) invokeBatchHandler(handler func([]*ReceivedMessage) error, batch []*ReceivedMessage) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v\r\n%s", ErrHandlerPanic, r, debug.Stack())
+			con.errors <- con.consumerError(err)
+		}
+	}()
+
+	return handler(batch)
+}
+
+// invokeAction invokes the action for the message, recovering when it panics.
+func (con *Consumer) invokeAction(action func(*ReceivedMessage), msg *ReceivedMessage) {
+
+	defer con.recoverPanic(msg)
+
+	action(msg)
+}
+
+// recoverPanic recovers a panicking handler or action so the Consumer keeps running. The panic and its stack are sent
+// to Errors and an ackable message is nacked, requeued only when RequeueOnPanic is set.
+func (con *Consumer) recoverPanic(msg *ReceivedMessage) {
+
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	con.errors <- con.messageError(msg, fmt.Errorf("%w: %v\r\n%s", ErrHandlerPanic, r, debug.Stack()))
+
+	if !msg.IsAckable {
+		return
+	}
+
+	requeue := con.Config != nil && con.Config.RequeueOnPanic
+	if err := msg.Nack(requeue); err != nil {
+		con.errors <- con.messageError(msg, err)
+	}
+}
+
+// DeadLetter routes the message to a dead letter path and removes it from the queue.
+// When a DeadLetterExchange is configured, the message is republished there with headers annotating the reason
+// and then acknowledged, otherwise it is nacked without requeue for the queue's own dead letter exchange.
+func (con *Consumer) DeadLetter(msg *ReceivedMessage, reason string) error {
+
+	if con.Config == nil || con.Config.DeadLetterExchange == "" {
+		return msg.Nack(false)
+	}
+
+	headers := copyHeaders(msg.Headers)
+	headers[DeadLetterReasonHeader] = reason
+	headers[DeadLetterQueueHeader] = con.QueueName
+	headers[DeadLetterTimeHeader] = time.Now().UTC()
+
+	return con.republish(msg, con.Config.DeadLetterExchange, con.Config.DeadLetterRoutingKey, headers, 0)
+}
+
+// Retry republishes the message to the RetryExchange with its RetryCountHeader incremented and removes it from the
+// queue, the standard retry with backoff through the broker. The republished message expires after delay, so a delay
+// queue bound to the RetryExchange, with the consumed queue as its dead letter path, returns it once it expires.
+// The original is acknowledged rather than nacked, so the queue's own dead letter exchange doesn't get a copy.
+// A message already retried MaxRetries times is dead lettered with DeadLetter instead.
+func (con *Consumer) Retry(msg *ReceivedMessage, delay time.Duration) error {
+
+	if con.Config == nil || con.Config.RetryExchange == "" {
+		return errors.New("consumer has no retry exchange configured")
+	}
+
+	retries, _ := msg.HeaderInt(RetryCountHeader)
+	if con.Config.MaxRetries > 0 && retries >= int64(con.Config.MaxRetries) {
+		return con.DeadLetter(msg, fmt.Sprintf("retried %d times", retries))
+	}
+
+	headers := copyHeaders(msg.Headers)
+	headers[RetryCountHeader] = retries + 1
+
+	return con.republish(msg, con.Config.RetryExchange, con.Config.RetryRoutingKey, headers, delay)
+}
+
+// republish publishes a copy of the message, with the headers instead of its own and expiring after ttl (never when
+// zero), to the exchange and then acknowledges it. An empty routingKey republishes with the original routing key.
+func (con *Consumer) republish(msg *ReceivedMessage, exchange, routingKey string, headers amqp.Table, ttl time.Duration) error {
+
+	publishing := amqp.Publishing{
+		Headers:       headers,
+		Body:          msg.Body,
+		CorrelationId: msg.CorrelationId,
+		Timestamp:     msg.Timestamp,
+		DeliveryMode:  amqp.Persistent,
+		Expiration:    expiration(ttl),
+	}
+
+	if msg.AMQPDelivery != nil {
+		publishing.ContentType = msg.AMQPDelivery.ContentType
+		publishing.ContentEncoding = msg.AMQPDelivery.ContentEncoding
+		publishing.MessageId = msg.AMQPDelivery.MessageId
+		publishing.Type = msg.AMQPDelivery.Type
+		publishing.AppId = msg.AMQPDelivery.AppId
+
+		if routingKey == "" {
+			routingKey = msg.AMQPDelivery.RoutingKey
+		}
+	}
+
+	channel := con.ConnectionPool.GetTransientChannel(false)
+	defer channel.Close()
+
+	err := channel.Publish(exchange, routingKey, false, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	if !msg.IsAckable {
+		return nil
+	}
+
+	return msg.Acknowledge()
+}
+
+// copyHeaders copies the headers, leaving room for the headers added when republishing.
+func copyHeaders(headers amqp.Table) amqp.Table {
+
+	copied := make(amqp.Table, len(headers)+3)
+	for key, value := range headers {
+		copied[key] = value
+	}
+
+	return copied
+}
+
 func (con *Consumer) startConsumeLoop(action func(*ReceivedMessage)) {
 
 ConsumeLoop:
@@ -203,14 +672,27 @@ ConsumeLoop:
 		chanHost := con.ConnectionPool.GetChannelFromPool()
 
 		// Configure RabbitMQ channel QoS for Consumer
-		if con.qosCountOverride > 0 {
-			chanHost.Channel.Qos(con.qosCountOverride, 0, false)
+		if err := con.configureQos(chanHost); err != nil {
+			con.errors <- con.consumerError(err)
+			con.ConnectionPool.ReturnChannel(chanHost, true)
+			continue
+		}
+
+		// An empty QueueName consumes from a server named queue, declared again whenever the channel is replaced.
+		if con.serverNamedQueue {
+			if err := con.declareServerNamedQueue(chanHost); err != nil {
+				con.errors <- con.consumerError(err)
+				con.ConnectionPool.ReturnChannel(chanHost, true)
+				continue
+			}
 		}
 
 		// Initiate consuming process.
-		deliveryChan, err := chanHost.Channel.Consume(con.QueueName, con.ConsumerName, con.autoAck, con.exclusive, false, con.noWait, nil)
+		deliveryChan, err := con.consume(chanHost)
 		if err != nil {
 			con.ConnectionPool.ReturnChannel(chanHost, true)
+			con.errors <- con.consumerError(fmt.Errorf("consumer's consume failed: %w", amqpErrorMessage(err)))
+			time.Sleep(con.sleepOnErrorInterval)
 			continue
 		}
 
@@ -228,44 +710,290 @@ ConsumeLoop:
 		con.messageGroup.Wait() // wait for every message to be received to the internal queue
 	}
 
+	con.conLock.Lock()
+	requeueInFlight := con.requeueInFlight
+	con.conLock.Unlock()
+
+	if requeueInFlight {
+		con.requeueBufferedMessages()
+	}
+
 	con.conLock.Lock()
 	con.started = false
 	con.stopImmediate = false
+	con.requeueInFlight = false
+	con.draining = false
+	if con.messages != nil {
+		close(con.messages) // nothing sends to it once the consume loop has stopped
+		con.messages = nil
+	}
 	con.conLock.Unlock()
 }
 
+// declareServerNamedQueue declares an exclusive, auto delete queue with a server generated name for the Consumer.
+func (con *Consumer) declareServerNamedQueue(chanHost *ChannelHost) error {
+
+	queue, err := chanHost.Channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return err
+	}
+
+	con.conLock.Lock()
+	con.QueueName = queue.Name
+	con.conLock.Unlock()
+
+	return nil
+}
+
+// GetQueueName gets the queue the Consumer consumes from.
+// For a Consumer created with an empty QueueName, this is the server generated name once consuming has started.
+func (con *Consumer) GetQueueName() string {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.QueueName
+}
+
+// consume issues the basic.consume on the channel, the consumer tag is the ConsumerName or generated when empty.
+func (con *Consumer) consume(chanHost *ChannelHost) (<-chan amqp.Delivery, error) {
+
+	con.conLock.Lock()
+	if con.consumerTag == "" {
+		con.consumerTag = con.ConsumerName
+		if con.consumerTag == "" {
+			con.consumerTag = "tcr-" + RandomString(12)
+		}
+	}
+	consumerTag := con.consumerTag
+	queueName := con.QueueName
+	con.conLock.Unlock()
+
+	return chanHost.Channel.Consume(queueName, consumerTag, con.autoAck, con.exclusive, false, con.noWait, nil)
+}
+
+// Pause stops RabbitMQ delivering messages to the Consumer by cancelling the basic.consume, the channel is kept.
+// Messages already delivered are still received, unacknowledged ones stay with the Consumer until acknowledged.
+func (con *Consumer) Pause() {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	con.paused = true
+}
+
+// Resume issues the basic.consume again, on the same channel, for a paused Consumer.
+func (con *Consumer) Resume() {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	con.paused = false
+}
+
+// IsPaused indicates the Consumer is paused.
+func (con *Consumer) IsPaused() bool {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.paused
+}
+
+// consumePaused indicates the basic.consume should be cancelled, when paused or draining.
+func (con *Consumer) consumePaused() bool {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.paused || con.draining
+}
+
+func (con *Consumer) setDeliveriesCancelled(cancelled bool) {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	con.deliveriesCancelled = cancelled
+}
+
+// Drain stops RabbitMQ delivering new messages, by cancelling the basic.consume, and waits up to the ctx deadline for
+// the messages already delivered to be processed before stopping the Consumer. Messages are processed once handled by
+// ProcessWithHandler or the StartConsumingWithAction action, or, when consuming with StartConsuming, once read from
+// ReceivedMessages. When ctx is done first, the Consumer is stopped anyway and the ctx error returned.
+func (con *Consumer) Drain(ctx context.Context) error {
+
+	con.conLock.Lock()
+	if !con.started {
+		con.conLock.Unlock()
+		return errors.New("can't drain a stopped consumer")
+	}
+	con.draining = true
+	con.conLock.Unlock()
+
+	defer func() {
+		con.conLock.Lock()
+		con.drained = true
+		con.conLock.Unlock()
+	}()
+
+	for !con.deliveriesDrained() {
+		select {
+		case <-ctx.Done():
+			if err := con.StopConsuming(false, false); err != nil {
+				return err
+			}
+			return ctx.Err()
+		default:
+			time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
+		}
+	}
+
+	return con.StopConsuming(false, false)
+}
+
+// deliveriesDrained indicates the basic.consume is cancelled and every delivered message processed.
+func (con *Consumer) deliveriesDrained() bool {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.deliveriesCancelled && atomic.LoadInt64(&con.inFlight) == 0 && len(con.receivedMessages) == 0 && len(con.messages) == 0
+}
+
+// isDrained indicates the Consumer was stopped by Drain.
+func (con *Consumer) isDrained() bool {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.drained
+}
+
+// validateQuorumQueue returns an error wrapping ErrInvalidQuorumConsumer when the Consumer of a quorum queue uses
+// global qos or a server named (exclusive, auto delete) queue, instead of the server closing the channel on consume.
+func (con *Consumer) validateQuorumQueue() error {
+
+	if !con.quorumQueue {
+		return nil
+	}
+
+	if con.globalQos {
+		return fmt.Errorf("consumer %s: %w: global qos", con.ConsumerName, ErrInvalidQuorumConsumer)
+	}
+
+	if con.serverNamedQueue {
+		return fmt.Errorf("consumer %s: %w: server named queue", con.ConsumerName, ErrInvalidQuorumConsumer)
+	}
+
+	return nil
+}
+
+// configureQos sets the prefetch count and size on the channel, PrefetchCount takes precedence over QosCountOverride.
+func (con *Consumer) configureQos(chanHost *ChannelHost) error {
+
+	prefetchCount := con.prefetchCount
+	if prefetchCount == 0 {
+		prefetchCount = con.qosCountOverride
+	}
+
+	if prefetchCount <= 0 && con.prefetchSize <= 0 {
+		return nil
+	}
+
+	return chanHost.Channel.Qos(prefetchCount, con.prefetchSize, con.globalQos)
+}
+
 // ProcessDeliveries is the inner loop for processing the deliveries and returns true to break outer loop.
 func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHost *ChannelHost, action func(*ReceivedMessage)) bool {
 
+	con.conLock.Lock()
+	processing := con.processing
+	con.conLock.Unlock()
+
+	// Lets the messages tell whether the channel they were received on dropped.
+	channelClosed := chanHost.Channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	paused := false
 	for {
 		// Listen for channel closure (close errors).
 		// Highest priority so separated to it's own select.
 		select {
-		case errorMessage := <-chanHost.Errors:
-			if errorMessage != nil {
+		case amqpErr := <-chanHost.Errors:
+			if amqpErr != nil {
 				con.ConnectionPool.ReturnChannel(chanHost, true)
-				con.errors <- fmt.Errorf("consumer's current channel closed\r\n[reason: %s]\r\n[code: %d]", errorMessage.Reason, errorMessage.Code)
+				con.errors <- con.consumerError(fmt.Errorf("consumer's current channel closed: %w", NewErrorMessage(amqpErr)))
 				return false
 			}
 		default:
 			break
 		}
 
+		// Cancel or re-issue the basic.consume on the same channel when paused or resumed.
+		if con.consumePaused() != paused {
+			paused = !paused
+			con.setDeliveriesCancelled(false)
+
+			var err error
+			if paused {
+				err = chanHost.Channel.Cancel(con.consumerTag, false)
+			} else {
+				deliveryChan, err = con.consume(chanHost)
+			}
+
+			if err != nil {
+				con.ConnectionPool.ReturnChannel(chanHost, true)
+				con.errors <- con.consumerError(fmt.Errorf("consumer's pause or resume failed: %w", err))
+				return false
+			}
+		}
+
 		// Convert amqp.Delivery into our internal struct for later use.
 		select {
-		case delivery := <-deliveryChan: // all buffered deliveries are wiped on a channel close error
+		case delivery, ok := <-deliveryChan: // all buffered deliveries are wiped on a channel close error
+
+			if !ok {
+				if paused {
+					deliveryChan = nil // cancelled, wait for resume
+					con.setDeliveriesCancelled(true)
+					break
+				}
+
+				// The deliveries close before the channel close notification arrives.
+				select {
+				case amqpErr := <-chanHost.Errors:
+					if amqpErr != nil {
+						con.ConnectionPool.ReturnChannel(chanHost, true)
+						con.errors <- con.consumerError(fmt.Errorf("consumer's current channel closed: %w", NewErrorMessage(amqpErr)))
+						return false
+					}
+				default:
+				}
+
+				// cancelled by the server (ex., queue deleted), consume again
+				con.ConnectionPool.ReturnChannel(chanHost, false)
+				con.errors <- con.consumerError(errors.New("consumer's deliveries were cancelled by the server"))
+				return false
+			}
 
 			msg, _ := NewMessageFromDelivery(!con.autoAck, chanHost.Channel, &delivery)
+			msg.channelClosed = channelClosed
+			con.metrics.IncConsumed(con.ConsumerName)
+
+			if con.routePoisonMessage(msg, &delivery) || con.filterMessage(msg) {
+				break
+			}
+
+			con.paceDelivery()
 
 			if action != nil {
-				action(msg)
+				con.invokeAction(action, msg)
 			} else {
-				con.receivedMessages <- msg
+				if processing {
+					atomic.AddInt64(&con.inFlight, 1) // until handled by ProcessWithHandler
+				}
+				if !con.deliverMessage(msg, processing) && processing {
+					atomic.AddInt64(&con.inFlight, -1)
+				}
 			}
 
 		default:
 			if con.sleepOnIdleInterval > 0 {
 				time.Sleep(con.sleepOnIdleInterval)
+			} else if paused {
+				time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
 			}
 			break
 		}
@@ -283,39 +1011,335 @@ func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHo
 	}
 }
 
+// deliveryInterval is the minimum time between deliveries for maxDeliveriesPerSecond, zero when unlimited.
+func deliveryInterval(maxDeliveriesPerSecond float64) time.Duration {
+
+	if maxDeliveriesPerSecond <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(time.Second) / maxDeliveriesPerSecond)
+}
+
+// paceDelivery blocks the delivery loop until the next delivery is allowed by MaxDeliveriesPerSecond. Waiting
+// deliveries stay unacked, so the prefetch bounds how many wait in the Consumer.
+func (con *Consumer) paceDelivery() {
+
+	if con.deliveryInterval <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if wait := con.nextDelivery.Sub(now); wait > 0 {
+		time.Sleep(wait)
+		now = con.nextDelivery
+	}
+
+	con.nextDelivery = now.Add(con.deliveryInterval)
+}
+
+// SetFilter sets a predicate deliveries must match before they reach the action or handler. A message the filter
+// returns false for is nacked and requeued for another consumer of the queue (nacked without requeue when
+// DropFiltered is set), an AutoAck message is simply skipped. Beware, a requeued message is redelivered right away, so
+// if no consumer of the queue ever matches it, it loops between the broker and the Consumer(s) forever. A nil filter
+// lets every message through.
+func (con *Consumer) SetFilter(filter func(*ReceivedMessage) bool) {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	con.filter = filter
+}
+
+// filterMessage nacks the message when the filter rejects it, returns true when filtered out.
+func (con *Consumer) filterMessage(msg *ReceivedMessage) bool {
+
+	con.conLock.Lock()
+	filter := con.filter
+	con.conLock.Unlock()
+
+	if filter == nil || filter(msg) {
+		return false
+	}
+
+	if msg.IsAckable {
+		requeue := con.Config == nil || !con.Config.DropFiltered
+		if err := msg.Nack(requeue); err != nil {
+			con.errors <- con.messageError(msg, err)
+		}
+	}
+
+	return true
+}
+
+// routePoisonMessage dead letters the message when it has exceeded MaxRedeliveries, returns true when dead lettered.
+func (con *Consumer) routePoisonMessage(msg *ReceivedMessage, delivery *amqp.Delivery) bool {
+
+	if con.maxRedeliveries <= 0 || !msg.IsAckable || !delivery.Redelivered {
+		return false
+	}
+
+	redeliveries := con.redeliveryCount(delivery)
+	if redeliveries <= con.maxRedeliveries {
+		return false
+	}
+
+	delete(con.redeliveries, delivery.MessageId)
+
+	con.errors <- &PoisonMessageError{
+		QueueName:     con.QueueName,
+		ConsumerName:  con.ConsumerName,
+		MessageID:     delivery.MessageId,
+		Redeliveries:  redeliveries,
+		DeadLetterErr: con.DeadLetter(msg, fmt.Sprintf("exceeded max redeliveries (%d)", con.maxRedeliveries)),
+	}
+
+	return true
+}
+
+// redeliveryCount uses the delivery count header when present (quorum queues) or tracks the redeliveries by MessageId.
+// Redeliveries of messages without either can only be counted as one.
+func (con *Consumer) redeliveryCount(delivery *amqp.Delivery) int {
+
+	if count, ok := headerInt(delivery.Headers, DeliveryCountHeader); ok {
+		return int(count)
+	}
+
+	if delivery.MessageId == "" {
+		return 1
+	}
+
+	if len(con.redeliveries) >= maxTrackedRedeliveries {
+		con.redeliveries = make(map[string]int)
+	}
+
+	con.redeliveries[delivery.MessageId]++
+
+	return con.redeliveries[delivery.MessageId]
+}
+
+// QueueDepth gets the ready message count of the Consumer's queue.
+// The count is a point-in-time snapshot, see Topologer.QueueStats.
+func (con *Consumer) QueueDepth() (int, error) {
+
+	messages, _, err := NewTopologer(con.ConnectionPool).QueueStats(con.QueueName)
+	return messages, err
+}
+
+// StopOptions are how StopConsumingWithOptions treats the messages the Consumer received but that weren't processed.
+// Without RequeueInFlight or FlushMessages, received messages stay in the internal buffer (ReceivedMessages or
+// Messages) to be processed and acknowledged after the stop. Ackable messages that are never acknowledged stay
+// unacknowledged on their channel, kept open by the ConnectionPool, until it closes and RabbitMQ requeues them.
+type StopOptions struct {
+	// Immediate stops without waiting for the messages being received to reach the internal buffer.
+	Immediate bool
+
+	// RequeueInFlight nacks, with requeue, the ackable messages still in the internal buffer once the Consumer has
+	// stopped, so RabbitMQ redelivers them right away (ex. to another instance) instead of once their channel closes.
+	RequeueInFlight bool
+
+	// FlushMessages empties the internal buffer without acknowledging. Ackable messages are still in the RabbitMQ
+	// queue, while AutoAck messages will unfortunately be lost. Use wisely.
+	FlushMessages bool
+}
+
 // StopConsuming allows you to signal stop to the consumer.
 // Will stop on the consumer channelclose or responding to signal after getting all remaining deviveries.
-// FlushMessages empties the internal buffer of messages received by queue. Ackable messages are still in
-// RabbitMQ queue, while noAck messages will unfortunately be lost. Use wisely.
+// It is StopConsumingWithOptions with the Immediate and FlushMessages StopOptions.
 func (con *Consumer) StopConsuming(immediate bool, flushMessages bool) error {
+
+	return con.StopConsumingWithOptions(StopOptions{Immediate: immediate, FlushMessages: flushMessages})
+}
+
+// StopConsumingWithOptions allows you to signal stop to the consumer, handling the received messages that weren't
+// processed as set in the StopOptions. A Consumer in ConsumeBatch has its partial batch handled before the stop
+// completes, waiting up to 5 seconds for the handler.
+func (con *Consumer) StopConsumingWithOptions(options StopOptions) error {
 	con.conLock.Lock()
-	defer con.conLock.Unlock()
 
 	if !con.started {
+		con.conLock.Unlock()
 		return errors.New("can't stop a stopped consumer")
 	}
 
-	con.stopImmediate = immediate
+	con.stopImmediate = options.Immediate
+	con.requeueInFlight = options.RequeueInFlight
 	con.consumeStop <- true
 
 	// This helps terminate all goroutines trying to add messages too.
-	if flushMessages {
-		con.FlushMessages()
+	if options.FlushMessages {
+		flushMessages(con.receivedMessages, con.messages) // FlushMessages would take the held conLock
+	}
+
+	batchStop := con.batchStop
+	con.batchStop = nil
+	con.conLock.Unlock()
+
+	// Waiting unlocked, handling the batch may need the Consumer.
+	if batchStop != nil {
+		return awaitBatchStop(batchStop)
 	}
 
 	return nil
 }
 
+// awaitBatchStop asks ConsumeBatch to handle its partial batch and return, waiting up to the batchStopTimeout.
+func awaitBatchStop(batchStop chan chan struct{}) error {
+
+	batchHandled := make(chan struct{})
+	batchStop <- batchHandled
+
+	select {
+	case <-batchHandled:
+		return nil
+	case <-time.After(batchStopTimeout):
+		return fmt.Errorf("consumer stopped without its partial batch handled within %s", batchStopTimeout)
+	}
+}
+
+// requeueBufferedMessages nacks, with requeue, the ackable messages in the internal buffer.
+func (con *Consumer) requeueBufferedMessages() {
+
+	con.conLock.Lock()
+	messages := con.messages
+	con.conLock.Unlock()
+
+	for {
+		var msg *ReceivedMessage
+		select {
+		case msg = <-con.receivedMessages:
+		case msg = <-messages: // nil when Messages isn't used
+		default:
+			return
+		}
+
+		if !msg.IsAckable {
+			continue
+		}
+
+		if err := msg.Nack(true); err != nil {
+			con.errors <- con.messageError(msg, err)
+		}
+	}
+}
+
+// SetMetricsRecorder sets the MetricsRecorder used to record consumed messages. Set before consuming.
+func (con *Consumer) SetMetricsRecorder(metrics MetricsRecorder) {
+	if metrics == nil {
+		metrics = NoopMetricsRecorder{}
+	}
+
+	con.metrics = metrics
+}
+
 // ReceivedMessages yields all the internal messages ready for consuming.
 func (con *Consumer) ReceivedMessages() <-chan *ReceivedMessage {
 	return con.receivedMessages
 }
 
-// Errors yields all the internal errs for consuming messages.
+// Messages yields the messages ready for consuming on a channel that keeps delivering as the Consumer consumes again
+// on a new channel after a channel or connection failure, and is only closed once the Consumer has stopped (ex. with
+// StopConsuming), so it can be ranged over. Once Messages is called, messages are delivered to it instead of
+// ReceivedMessages. After the Consumer is stopped, call Messages again for the channel of the next StartConsuming.
+// Messages isn't used by ProcessWithHandler, which reads the messages itself.
+func (con *Consumer) Messages() <-chan *ReceivedMessage {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.messages == nil {
+		con.messages = make(chan *ReceivedMessage, cap(con.receivedMessages))
+	}
+
+	return con.messages
+}
+
+// deliverMessage sends the message to Messages when it is in use, otherwise to ReceivedMessages. Returns false when
+// the buffer is full and the message was dropped for DropWhenBufferFull.
+func (con *Consumer) deliverMessage(msg *ReceivedMessage, processing bool) bool {
+
+	con.conLock.Lock()
+	buffer := con.messages
+	con.conLock.Unlock()
+
+	if buffer == nil || processing {
+		buffer = con.receivedMessages
+	}
+
+	if !con.dropWhenBufferFull {
+		buffer <- msg
+		return true
+	}
+
+	select {
+	case buffer <- msg:
+		return true
+	default:
+	}
+
+	if msg.IsAckable {
+		if err := msg.Nack(false); err != nil {
+			con.errors <- con.messageError(msg, err)
+		}
+	}
+
+	return false
+}
+
+// Buffered is the number of messages received and waiting in the Consumer's buffer (ReceivedMessages or Messages)
+// to be consumed, up to the BufferSize.
+func (con *Consumer) Buffered() int {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return len(con.receivedMessages) + len(con.messages)
+}
+
+// bufferSize is the configured BufferSize or the default when not set.
+func bufferSize(size int) int {
+
+	if size <= 0 {
+		return defaultBufferSize
+	}
+
+	return size
+}
+
+// Errors yields all the internal errs for consuming messages, as ConsumerError or PoisonMessageError.
 func (con *Consumer) Errors() <-chan error {
 	return con.errors
 }
 
+// amqpErrorMessage converts an *amqp.Error into an ErrorMessage, other errors are returned as is.
+func amqpErrorMessage(err error) error {
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return NewErrorMessage(amqpErr)
+	}
+
+	return err
+}
+
+// consumerError wraps the error in a ConsumerError attributing it to the Consumer and its queue.
+func (con *Consumer) consumerError(err error) error {
+
+	return &ConsumerError{
+		ConsumerName: con.ConsumerName,
+		QueueName:    con.GetQueueName(),
+		Err:          err,
+	}
+}
+
+// messageError wraps the error in a ConsumerError attributing it to the Consumer, its queue and the message.
+func (con *Consumer) messageError(msg *ReceivedMessage, err error) error {
+
+	return &ConsumerError{
+		ConsumerName: con.ConsumerName,
+		QueueName:    con.GetQueueName(),
+		DeliveryTag:  msg.deliveryTag,
+		Err:          err,
+	}
+}
+
 func (con *Consumer) convertDelivery(amqpChan *amqp.Channel, delivery *amqp.Delivery, isAckable bool) {
 
 }
@@ -350,10 +1374,24 @@ FlushLoop:
 // WARNING: THIS WILL RESULT IN LOST MESSAGES.
 func (con *Consumer) FlushMessages() {
 
+	con.conLock.Lock()
+	messages := con.messages
+	con.conLock.Unlock()
+
+	flushMessages(con.receivedMessages, messages)
+}
+
+// flushMessages empties the received messages and Messages (nil when Messages isn't used) without taking the conLock.
+func flushMessages(receivedMessages <-chan *ReceivedMessage, messages <-chan *ReceivedMessage) {
+
 FlushLoop:
 	for {
 		select {
-		case <-con.receivedMessages:
+		case <-receivedMessages:
+		case _, ok := <-messages:
+			if !ok {
+				break FlushLoop
+			}
 		default:
 			break FlushLoop
 		}
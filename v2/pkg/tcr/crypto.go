@@ -4,9 +4,12 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/argon2"
@@ -14,8 +17,57 @@ import (
 
 const (
 	defaultNonceSize = 12 // 12 is the standard
+	defaultKeyLength = 32 // AES-256
 )
 
+// maxMemoryMultiplier keeps the Argon2 memory (MemoryMultiplier MiB in KiB) within a uint32.
+const maxMemoryMultiplier = (1<<32 - 1) / 1024
+
+var (
+	// ErrInvalidKeyLength indicates the EncryptionConfig KeyLength isn't an AES key size.
+	ErrInvalidKeyLength = errors.New("encryption key length must be 16, 24 or 32 bytes for aes")
+
+	// ErrInvalidArgon2Config indicates the EncryptionConfig Argon2 parameters can't derive a secure key.
+	ErrInvalidArgon2Config = errors.New("encryption argon2 parameters are invalid")
+)
+
+// validateArgon2 applies the defaults for a zero TimeConsideration (1 pass) and Threads (1) and rejects a
+// MemoryMultiplier (MiB of memory) that is 0, which derives the key with a trivial amount of memory, or overflows.
+// The defaults are the ones GetHashWithArgon has always applied, so existing keys are derived unchanged.
+func (ec *EncryptionConfig) validateArgon2() error {
+
+	if ec.MemoryMultiplier == 0 {
+		return fmt.Errorf("%w: memorymultiplier can't be 0, 64 (MiB) or more is recommended", ErrInvalidArgon2Config)
+	}
+
+	if ec.MemoryMultiplier > maxMemoryMultiplier {
+		return fmt.Errorf("%w: memorymultiplier can't be more than %d (MiB)", ErrInvalidArgon2Config, maxMemoryMultiplier)
+	}
+
+	if ec.TimeConsideration == 0 {
+		ec.TimeConsideration = 1
+	}
+
+	if ec.Threads == 0 {
+		ec.Threads = 1
+	}
+
+	return nil
+}
+
+// hashKeyLength gets the length of the Hashkey to create, validated for the AES cipher.
+func (ec *EncryptionConfig) hashKeyLength() (uint32, error) {
+
+	switch ec.KeyLength {
+	case 0:
+		return defaultKeyLength, nil
+	case 16, 24, 32:
+		return ec.KeyLength, nil
+	default:
+		return 0, fmt.Errorf("%w: %d bytes configured", ErrInvalidKeyLength, ec.KeyLength)
+	}
+}
+
 // GetHashWithArgon uses Argon2 version 0x13 to hash a plaintext password with a provided salt string and return hash as bytes.
 func GetHashWithArgon(passphrase, salt string, timeConsideration uint32, multiplier uint32, threads uint8, hashLength uint32) []byte {
 
@@ -34,6 +86,17 @@ func GetHashWithArgon(passphrase, salt string, timeConsideration uint32, multipl
 	return argon2.IDKey([]byte(passphrase), []byte(salt), timeConsideration, multiplier*1024, threads, hashLength)
 }
 
+// GetKeyID creates a short identifier for a hashed key so wrapped payloads can name the key that encrypted them without revealing it.
+func GetKeyID(hashedKey []byte) string {
+
+	if len(hashedKey) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(hashedKey)
+	return hex.EncodeToString(sum[:8])
+}
+
 // GetStringHashWithArgon uses Argon2 version 0x13 to hash a plaintext password with a provided salt string and return hash as base64 string.
 func GetStringHashWithArgon(passphrase, salt string, timeConsideration uint32, threads uint8, hashLength uint32) string {
 
@@ -0,0 +1,56 @@
+package tcr
+
+import "time"
+
+// PoolEventType identifies a ConnectionPool state transition.
+type PoolEventType int
+
+const (
+	// PoolConnected is a connection being established or re-established.
+	PoolConnected PoolEventType = iota
+
+	// PoolDisconnected is a connection found closed or erred.
+	PoolDisconnected
+
+	// PoolReconnecting is a connection recovery starting.
+	PoolReconnecting
+
+	// PoolChannelClosed is a cached channel that erred and is being recreated.
+	PoolChannelClosed
+
+	// PoolChannelLeaked is a cached channel leased for longer than the ChannelLeaseTimeout, see Detail.
+	PoolChannelLeaked
+
+	// PoolChannelFlow is the server asking a channel to pause (channel.flow), or resume, publishing, see FlowActive.
+	PoolChannelFlow
+)
+
+// String returns the name of the PoolEventType.
+func (pet PoolEventType) String() string {
+
+	switch pet {
+	case PoolConnected:
+		return "Connected"
+	case PoolDisconnected:
+		return "Disconnected"
+	case PoolReconnecting:
+		return "Reconnecting"
+	case PoolChannelClosed:
+		return "ChannelClosed"
+	case PoolChannelLeaked:
+		return "ChannelLeaked"
+	case PoolChannelFlow:
+		return "ChannelFlow"
+	default:
+		return "Unknown"
+	}
+}
+
+// PoolEvent is a ConnectionPool state transition for the connection with ConnectionID.
+type PoolEvent struct {
+	Type         PoolEventType
+	ConnectionID uint64
+	Time         time.Time
+	Detail       string // describes the event when there's more to it, such as the caller of a leaked channel
+	FlowActive   bool   // PoolChannelFlow only, false when the server asked to pause publishing
+}
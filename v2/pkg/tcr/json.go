@@ -2,7 +2,14 @@ package tcr
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -15,8 +22,38 @@ const (
 	// ZstdCompressionType helps identify which compression/decompression to use.
 	ZstdCompressionType = "zstd"
 
+	// WrappedBodyVersion is the version of the WrappedBody format written by CreateWrappedPayload.
+	// Version 0 are wrapped payloads created before the format was versioned, they are read the same as version 1.
+	WrappedBodyVersion = 1
+
 	//AesSymmetricType helps identity which encryption/decryption to use.
+	// Payloads are sealed with AES-GCM (AES-256 with the default 32 byte Hashkey) and the random nonce is prepended to the ciphertext.
 	AesSymmetricType = "aes"
+
+	// AesGcmSymmetricType explicitly selects AES-GCM authenticated encryption, tampered payloads fail to decrypt.
+	AesGcmSymmetricType = "aesgcm"
+
+	// PayloadPipelineHeader is the header RabbitService publishes with what it applied to a payload it created, comma
+	// separated in the order applied, ex. "compressed,encrypted,wrapped". Not published when nothing was applied.
+	PayloadPipelineHeader = "x-tcr-pipeline"
+
+	// Crc32ChecksumType checksums wrapped payload data with CRC-32 (IEEE), it detects accidental corruption.
+	Crc32ChecksumType = "crc32"
+
+	// Sha256ChecksumType checksums wrapped payload data with SHA-256.
+	Sha256ChecksumType = "sha256"
+
+	pipelineCompressed = "compressed"
+	pipelineEncrypted  = "encrypted"
+	pipelineWrapped    = "wrapped"
+)
+
+// ErrChecksumMismatch indicates the data of a wrapped payload doesn't match its checksum, it was corrupted.
+var ErrChecksumMismatch = errors.New("wrapped payload checksum mismatch")
+
+var (
+	gzipMagicNumber = []byte{0x1f, 0x8b}
+	zstdMagicNumber = []byte{0x28, 0xb5, 0x2f, 0xfd}
 )
 
 // ConvertJSONFileToConfig opens a file.json and converts to RabbitSeasoning.
@@ -83,34 +120,131 @@ func CreatePayload(
 	compression *CompressionConfig,
 	encryption *EncryptionConfig) ([]byte, error) {
 
-	var json = jsoniter.ConfigFastest
-	data, err := json.Marshal(&input)
+	return CreatePayloadWithJSONConfig(input, nil, compression, encryption)
+}
+
+// CreatePayloadWithJSONConfig is CreatePayload with the JSON encoded using the JSONConfig's options.
+func CreatePayloadWithJSONConfig(
+	input interface{},
+	jsonConfig *JSONConfig,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, error) {
+
+	data, _, err := createPayload(input, jsonConfig, compression, encryption)
+	return data, err
+}
+
+// createPayload is CreatePayloadWithJSONConfig also returning the payloadPipeline applied.
+func createPayload(
+	input interface{},
+	jsonConfig *JSONConfig,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, payloadPipeline, error) {
+
+	data, err := marshalJSON(input, jsonConfig)
 	if err != nil {
+		return nil, payloadPipeline{}, err
+	}
+
+	return modifyPayloadPipeline(data, compression, encryption)
+}
+
+// payloadPipeline is what was applied to a payload when it was created.
+type payloadPipeline struct {
+	compressed bool
+	encrypted  bool
+	wrapped    bool
+}
+
+// String lists what was applied, as published in the PayloadPipelineHeader.
+func (pp payloadPipeline) String() string {
+
+	applied := make([]string, 0, 3)
+	if pp.compressed {
+		applied = append(applied, pipelineCompressed)
+	}
+
+	if pp.encrypted {
+		applied = append(applied, pipelineEncrypted)
+	}
+
+	if pp.wrapped {
+		applied = append(applied, pipelineWrapped)
+	}
+
+	return strings.Join(applied, ",")
+}
+
+// marshalJSON encodes the input with the JSONConfig's options, a nil JSONConfig uses jsoniter.ConfigFastest.
+func marshalJSON(input interface{}, jsonConfig *JSONConfig) ([]byte, error) {
+
+	if jsonConfig == nil {
+		var json = jsoniter.ConfigFastest
+		return json.Marshal(&input)
+	}
+
+	var json = jsoniter.Config{
+		EscapeHTML:             jsonConfig.EscapeHTML,
+		SortMapKeys:            true,
+		ValidateJsonRawMessage: true,
+	}.Froze()
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(jsonConfig.EscapeHTML)
+	if jsonConfig.Indent != "" {
+		encoder.SetIndent("", jsonConfig.Indent)
+	}
+
+	if err := encoder.Encode(&input); err != nil {
 		return nil, err
 	}
 
+	// Encode terminates the JSON with a newline.
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// modifyPayload optionally compresses and then encrypts the data.
+func modifyPayload(
+	data []byte,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, error) {
+
+	data, _, err := modifyPayloadPipeline(data, compression, encryption)
+	return data, err
+}
+
+// modifyPayloadPipeline is modifyPayload also returning the payloadPipeline applied.
+func modifyPayloadPipeline(
+	data []byte,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, payloadPipeline, error) {
+
+	pipeline := payloadPipeline{}
 	buffer := &bytes.Buffer{}
-	if compression.Enabled {
+	if compression != nil && compressPayload(compression, data) {
 		err := handleCompression(compression, data, buffer)
 		if err != nil {
-			return nil, err
+			return nil, pipeline, err
 		}
 
 		// Update data - data is now compressed
 		data = buffer.Bytes()
+		pipeline.compressed = true
 	}
 
-	if encryption.Enabled {
+	if encryption != nil && encryption.Enabled {
 		err := handleEncryption(encryption, data, buffer)
 		if err != nil {
-			return nil, err
+			return nil, pipeline, err
 		}
 
 		// Update data - data is now encrypted
 		data = buffer.Bytes()
+		pipeline.encrypted = true
 	}
 
-	return data, nil
+	return data, pipeline, nil
 }
 
 // CreateWrappedPayload wraps your data in a plaintext wrapper called ModdedLetter and performs the selected modifications to data.
@@ -121,54 +255,222 @@ func CreateWrappedPayload(
 	compression *CompressionConfig,
 	encryption *EncryptionConfig) ([]byte, error) {
 
+	return CreateWrappedPayloadWithJSONConfig(input, letterID, metadata, nil, compression, encryption)
+}
+
+// CreateWrappedPayloadWithJSONConfig is CreateWrappedPayload with the JSON encoded using the JSONConfig's options.
+func CreateWrappedPayloadWithJSONConfig(
+	input interface{},
+	letterID uint64,
+	metadata string,
+	jsonConfig *JSONConfig,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, error) {
+
+	data, _, err := createWrappedPayload(input, letterID, metadata, jsonConfig, compression, encryption)
+	return data, err
+}
+
+// createWrappedPayload is CreateWrappedPayloadWithJSONConfig also returning the payloadPipeline applied.
+func createWrappedPayload(
+	input interface{},
+	letterID uint64,
+	metadata string,
+	jsonConfig *JSONConfig,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, payloadPipeline, error) {
+
 	wrappedBody := &WrappedBody{
+		Version:        WrappedBodyVersion,
 		LetterID:       letterID,
 		LetterMetadata: metadata,
 		Body:           &ModdedBody{},
 	}
 
-	var json = jsoniter.ConfigFastest
 	var err error
 	var innerData []byte
-	innerData, err = json.Marshal(&input)
+	innerData, err = marshalJSON(input, jsonConfig)
 	if err != nil {
-		return nil, err
+		return nil, payloadPipeline{}, err
 	}
 
 	buffer := &bytes.Buffer{}
-	if compression.Enabled {
+	if compressPayload(compression, innerData) {
 		err := handleCompression(compression, innerData, buffer)
 		if err != nil {
-			return nil, err
+			return nil, payloadPipeline{}, err
 		}
 
 		// Data is now compressed
 		wrappedBody.Body.Compressed = true
-		wrappedBody.Body.CType = compression.Type
+		wrappedBody.Body.CType = compressionType(compression)
 		innerData = buffer.Bytes()
 	}
 
 	if encryption.Enabled {
 		err := handleEncryption(encryption, innerData, buffer)
 		if err != nil {
-			return nil, err
+			return nil, payloadPipeline{}, err
 		}
 
 		// Data is now encrypted
 		wrappedBody.Body.Encrypted = true
 		wrappedBody.Body.EType = encryption.Type
+		wrappedBody.Body.EKeyID = encryption.KeyID
 		innerData = buffer.Bytes()
 	}
 
 	wrappedBody.Body.UTCDateTime = time.Now().UTC().Format(time.RFC3339)
 	wrappedBody.Body.Data = innerData
 
-	data, err := json.Marshal(&wrappedBody)
+	if compression.Checksum != "" {
+		checksum, err := payloadChecksum(compression.Checksum, innerData)
+		if err != nil {
+			return nil, payloadPipeline{}, err
+		}
+
+		wrappedBody.Body.ChecksumType = compression.Checksum
+		wrappedBody.Body.Checksum = checksum
+	}
+
+	data, err := marshalJSON(wrappedBody, jsonConfig)
 	if err != nil {
+		return nil, payloadPipeline{}, err
+	}
+
+	pipeline := payloadPipeline{
+		compressed: wrappedBody.Body.Compressed,
+		encrypted:  wrappedBody.Body.Encrypted,
+		wrapped:    true,
+	}
+
+	return data, pipeline, nil
+}
+
+// ReadWrappedPayload reverses CreateWrappedPayload, decrypting and decompressing the inner data as indicated by the wrapper
+// and then unmarshalling it into out. The WrappedBody is returned for access to the LetterID and LetterMetadata.
+func ReadWrappedPayload(
+	data []byte,
+	out interface{},
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) (*WrappedBody, error) {
+
+	wrappedBody, err := ReadWrappedBodyFromJSONBytes(data)
+	if err != nil || wrappedBody.Body == nil {
+		return nil, errors.New("can't unwrap, data is not a wrapped payload")
+	}
+
+	switch wrappedBody.Version {
+	case 0, WrappedBodyVersion:
+		return readWrappedBodyV1(wrappedBody, out, compression, encryption)
+	default:
+		return nil, fmt.Errorf("can't unwrap, wrapped payload version %d is not supported (latest supported version is %d)", wrappedBody.Version, WrappedBodyVersion)
+	}
+}
+
+// readWrappedBodyV1 reads the version 1 WrappedBody format.
+func readWrappedBodyV1(
+	wrappedBody *WrappedBody,
+	out interface{},
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) (*WrappedBody, error) {
+
+	// Verified whenever the wrapper has a checksum, whatever the supplied config.
+	if wrappedBody.Body.ChecksumType != "" {
+		checksum, err := payloadChecksum(wrappedBody.Body.ChecksumType, wrappedBody.Body.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if checksum != wrappedBody.Body.Checksum {
+			return nil, fmt.Errorf("can't unwrap LetterID %d: %w", wrappedBody.LetterID, ErrChecksumMismatch)
+		}
+	}
+
+	buffer := bytes.NewBuffer(wrappedBody.Body.Data)
+	if wrappedBody.Body.Encrypted {
+		if encryption == nil || len(encryption.Hashkey) == 0 {
+			return nil, errors.New("can't unwrap, payload is encrypted and no encryption hashkey was provided")
+		}
+
+		hashkey, err := decryptionKey(encryption, wrappedBody.Body.EKeyID)
+		if err != nil {
+			return nil, err
+		}
+
+		decryption := *encryption
+		decryption.Hashkey = hashkey
+		if err := handleDecryption(&decryption, buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	if wrappedBody.Body.Compressed {
+		// The wrapper records the compression used, which takes precedence over the supplied config.
+		compressionType := wrappedBody.Body.CType
+		if compressionType == "" && compression != nil {
+			compressionType = compression.Type
+		}
+
+		if err := handleDecompression(&CompressionConfig{Enabled: true, Type: compressionType}, buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	var json = jsoniter.ConfigFastest
+	if err := json.Unmarshal(buffer.Bytes(), out); err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return wrappedBody, nil
+}
+
+// payloadChecksum is the hex encoded checksum of the data with the checksum type.
+func payloadChecksum(checksumType string, data []byte) (string, error) {
+
+	var hasher hash.Hash
+	switch checksumType {
+	case Crc32ChecksumType:
+		hasher = crc32.NewIEEE()
+	case Sha256ChecksumType:
+		hasher = sha256.New()
+	default:
+		return "", fmt.Errorf("checksum type %q is not supported (crc32 or sha256)", checksumType)
+	}
+
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// compressionType is the configured compression type, unset defaults to gzip.
+func compressionType(compression *CompressionConfig) string {
+
+	if compression.Type == "" {
+		return GzipCompressionType
+	}
+
+	return compression.Type
+}
+
+// compressPayload checks compression is enabled and the data is larger than the MinSizeBytes threshold.
+func compressPayload(compression *CompressionConfig, data []byte) bool {
+	return compression.Enabled && len(data) > compression.MinSizeBytes
+}
+
+// payloadCompressed checks for the gzip or zstd magic number when small payloads may have been left uncompressed.
+// JSON never starts with either magic number, so an uncompressed payload is never mistaken for a compressed one.
+func payloadCompressed(compression *CompressionConfig, data []byte) bool {
+
+	if compression.MinSizeBytes <= 0 {
+		return true
+	}
+
+	switch compressionType(compression) {
+	case ZstdCompressionType:
+		return bytes.HasPrefix(data, zstdMagicNumber)
+	default:
+		return bytes.HasPrefix(data, gzipMagicNumber)
+	}
 }
 
 func handleCompression(compression *CompressionConfig, data []byte, buffer *bytes.Buffer) error {
@@ -186,7 +488,7 @@ func handleCompression(compression *CompressionConfig, data []byte, buffer *byte
 func handleEncryption(encryption *EncryptionConfig, data []byte, buffer *bytes.Buffer) error {
 
 	switch encryption.Type {
-	case AesSymmetricType:
+	case AesGcmSymmetricType, AesSymmetricType:
 		fallthrough
 	default:
 		data, err := EncryptWithAes(data, encryption.Hashkey, 12)
@@ -210,7 +512,7 @@ func ReadPayload(buffer *bytes.Buffer, compression *CompressionConfig, encryptio
 		}
 	}
 
-	if compression != nil && compression.Enabled {
+	if compression != nil && compression.Enabled && payloadCompressed(compression, buffer.Bytes()) {
 		if err := handleDecompression(compression, buffer); err != nil {
 			return err
 		}
@@ -219,6 +521,41 @@ func ReadPayload(buffer *bytes.Buffer, compression *CompressionConfig, encryptio
 	return nil
 }
 
+// DecryptPayload decrypts a payload created with encryption enabled, such as a ReceivedMessage.Body.
+func DecryptPayload(body []byte, encryption *EncryptionConfig) ([]byte, error) {
+
+	if encryption == nil || len(encryption.Hashkey) == 0 {
+		return nil, errors.New("can't decrypt payload without an encryption hashkey")
+	}
+
+	buffer := bytes.NewBuffer(body)
+	if err := handleDecryption(encryption, buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// DecompressPayload decompresses a payload created with compression enabled.
+// Encrypted payloads need to be decrypted with DecryptPayload first.
+func DecompressPayload(body []byte, compression *CompressionConfig) ([]byte, error) {
+
+	if compression == nil {
+		return nil, errors.New("can't decompress payload without a compression config")
+	}
+
+	if !payloadCompressed(compression, body) {
+		return body, nil
+	}
+
+	buffer := bytes.NewBuffer(body)
+	if err := handleDecompression(compression, buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
 func handleDecompression(compression *CompressionConfig, buffer *bytes.Buffer) error {
 
 	switch compression.Type {
@@ -234,7 +571,7 @@ func handleDecompression(compression *CompressionConfig, buffer *bytes.Buffer) e
 func handleDecryption(encryption *EncryptionConfig, buffer *bytes.Buffer) error {
 
 	switch encryption.Type {
-	case AesSymmetricType:
+	case AesGcmSymmetricType, AesSymmetricType:
 		fallthrough
 	default:
 		data, err := DecryptWithAes(buffer.Bytes(), encryption.Hashkey, 12)
@@ -248,3 +585,22 @@ func handleDecryption(encryption *EncryptionConfig, buffer *bytes.Buffer) error
 		return nil
 	}
 }
+
+// decryptionKey finds the Hashkey for the KeyID recorded in a wrapped payload.
+// Payloads without a KeyID were written before key ids were used and fall back to the current Hashkey.
+func decryptionKey(encryption *EncryptionConfig, keyID string) ([]byte, error) {
+
+	if keyID == encryption.KeyID {
+		return encryption.Hashkey, nil
+	}
+
+	if hashkey, ok := encryption.DecryptionKeys[keyID]; ok {
+		return hashkey, nil
+	}
+
+	if keyID == "" {
+		return encryption.Hashkey, nil
+	}
+
+	return nil, fmt.Errorf("can't decrypt, no decryption key found for key id %s", keyID)
+}
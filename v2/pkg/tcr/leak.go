@@ -0,0 +1,118 @@
+package tcr
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// channelLease is a cached ChannelHost given out by GetChannelFromPool and not yet returned.
+type channelLease struct {
+	leasedAt time.Time
+	caller   string
+	reported bool
+}
+
+// lease records the ChannelHost being given out, by the caller, for leak detection.
+func (ch *ChannelHost) lease(caller string) {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+
+	ch.currentLease = &channelLease{leasedAt: time.Now(), caller: caller}
+}
+
+// release clears the lease, returns false when the ChannelHost was reclaimed as leaked in the meantime.
+func (ch *ChannelHost) release() bool {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+
+	ch.currentLease = nil
+	return !ch.reclaimed
+}
+
+// leaseCaller gets the file and line of the code leasing a channel, skipping the ConnectionPool frames.
+func leaseCaller(skip int) string {
+
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// monitorChannelLeases reports, and optionally reclaims, cached channels leased for longer than ChannelLeaseTimeout.
+func (cp *ConnectionPool) monitorChannelLeases(leaseTimeout time.Duration) {
+
+	ticker := time.NewTicker(leaseTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cp.shutdownSignal:
+			return
+		case <-ticker.C:
+			cp.checkChannelLeases(leaseTimeout)
+		}
+	}
+}
+
+func (cp *ConnectionPool) checkChannelLeases(leaseTimeout time.Duration) {
+
+	cp.poolRWLock.RLock()
+	channelHosts := make([]*ChannelHost, len(cp.channelHosts))
+	copy(channelHosts, cp.channelHosts)
+	cp.poolRWLock.RUnlock()
+
+	for _, chanHost := range channelHosts {
+		chanHost.chanLock.Lock()
+		lease := chanHost.currentLease
+		leaked := lease != nil && !lease.reported && time.Since(lease.leasedAt) > leaseTimeout
+		if leaked {
+			lease.reported = true
+		}
+		chanHost.chanLock.Unlock()
+
+		if !leaked {
+			continue
+		}
+
+		detail := fmt.Sprintf("channel %d leased by %s at %s was not returned", chanHost.ID, lease.caller, lease.leasedAt.Format(time.RFC3339))
+		cp.logger.Warnf("%s", detail)
+		cp.notifyDetail(PoolChannelLeaked, chanHost.ConnectionID, detail)
+
+		if cp.Config.ReclaimLeakedChannels {
+			cp.reclaimChannel(chanHost)
+		}
+	}
+}
+
+// reclaimChannel replaces a leaked ChannelHost in the cache with a new one. The leaked ChannelHost is closed, and
+// is closed again instead of being cached should it ever be returned.
+func (cp *ConnectionPool) reclaimChannel(leaked *ChannelHost) {
+
+	leaked.chanLock.Lock()
+	leaked.reclaimed = true
+	leaked.chanLock.Unlock()
+
+	go func(*ChannelHost) {
+		defer func() { _ = recover() }()
+
+		leaked.Close()
+	}(leaked)
+
+	chanHost := cp.createCacheChannel(leaked.ID)
+
+	cp.poolRWLock.Lock()
+	for i := range cp.channelHosts {
+		if cp.channelHosts[i] == leaked {
+			cp.channelHosts[i] = chanHost
+			break
+		}
+	}
+	cp.channelCounts[leaked.ConnectionID]--
+	cp.channelCounts[chanHost.ConnectionID]++
+	cp.poolRWLock.Unlock()
+
+	cp.channels <- chanHost
+}
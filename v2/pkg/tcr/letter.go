@@ -1,13 +1,77 @@
 package tcr
 
-import "github.com/streadway/amqp"
+import (
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
 
 // Letter contains the message body and address of where things are going.
 type Letter struct {
 	LetterID   uint64
+	MessageID  string // optional, stable id published as the MessageId for consumers to deduplicate with
 	RetryCount uint32
 	Body       []byte
 	Envelope   *Envelope
+	QueuedAt   time.Time // set when QueueLetter accepts the letter for AutoPublish
+
+	// ConfirmationTimeout is how long AutoPublish (and PublishWithConfirmation without a timeout) waits for the
+	// letter's confirmation before its PublishReceipt fails, 0 uses the PublishTimeOutInterval.
+	ConfirmationTimeout time.Duration
+
+	queueTime time.Duration         // how long the letter waited before AutoPublish picked it up
+	pending   bool                  // LetterID is tracked by a Publisher deduplicating letters
+	onReceipt func(*PublishReceipt) // receives the letter's PublishReceipt instead of the PublishReceipts
+}
+
+// DelayHeader is the header an x-delayed-message exchange holds a message for, in milliseconds, before routing it.
+const DelayHeader = "x-delay"
+
+// WithDelay sets the letter's DelayHeader so an x-delayed-message exchange (see Exchange.DelayedType) routes it after
+// the delay. Requires the rabbitmq_delayed_message_exchange plugin, other exchanges ignore the header and route the
+// letter right away.
+func (letter *Letter) WithDelay(delay time.Duration) *Letter {
+
+	if letter.Envelope.Headers == nil {
+		letter.Envelope.Headers = amqp.Table{}
+	}
+
+	letter.Envelope.Headers[DelayHeader] = int64(delay / time.Millisecond)
+	return letter
+}
+
+// newPublishing creates the amqp.Publishing for the letter's body and envelope, timestamped now (UTC).
+// The Envelope's AppID takes precedence over the default appID.
+func newPublishing(letter *Letter, appID string) amqp.Publishing {
+
+	if letter.Envelope.AppID != "" {
+		appID = letter.Envelope.AppID
+	}
+
+	return amqp.Publishing{
+		ContentType:   letter.Envelope.ContentType,
+		Body:          letter.Body,
+		Headers:       letter.Envelope.Headers,
+		DeliveryMode:  letter.Envelope.DeliveryMode,
+		CorrelationId: letter.Envelope.CorrelationId,
+		MessageId:     letter.MessageID,
+		Expiration:    expiration(letter.Envelope.Expiration),
+		Priority:      letter.Envelope.Priority,
+		Timestamp:     time.Now().UTC(),
+		AppId:         appID,
+		Type:          letter.Envelope.Type,
+	}
+}
+
+// expiration converts the per message TTL to the AMQP expiration, milliseconds as a string, or empty for no TTL.
+func expiration(ttl time.Duration) string {
+
+	if ttl <= 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(int64(ttl/time.Millisecond), 10)
 }
 
 // Envelope contains all the address details of where a letter is going.
@@ -20,10 +84,15 @@ type Envelope struct {
 	Headers       amqp.Table
 	DeliveryMode  uint8
 	CorrelationId string
+	Expiration    time.Duration // per message TTL, the broker drops the message once expired (millisecond precision)
+	Priority      uint8         // 0-9, only honored by queues declared with a MaxPriority
+	AppID         string        // if empty, the RabbitSeasoning AppID is used
+	Type          string        // the message type (ex. OrderCreated), see Consumer.DispatchByType
 }
 
 // WrappedBody is to go inside a Letter struct with indications of the body of data being modified (ex., compressed).
 type WrappedBody struct {
+	Version        int         `json:"Version"`
 	LetterID       uint64      `json:"LetterID"`
 	Body           *ModdedBody `json:"Body"`
 	LetterMetadata string      `json:"LetterMetadata"`
@@ -31,10 +100,13 @@ type WrappedBody struct {
 
 // ModdedBody is a payload with modifications and indicators of what was modified.
 type ModdedBody struct {
-	Encrypted   bool   `json:"Encrypted"`
-	EType       string `json:"EncryptionType,omitempty"`
-	Compressed  bool   `json:"Compressed"`
-	CType       string `json:"CompressionType,omitempty"`
-	UTCDateTime string `json:"UTCDateTime"`
-	Data        []byte `json:"Data"`
+	Encrypted    bool   `json:"Encrypted"`
+	EType        string `json:"EncryptionType,omitempty"`
+	EKeyID       string `json:"EncryptionKeyID,omitempty"`
+	Compressed   bool   `json:"Compressed"`
+	CType        string `json:"CompressionType,omitempty"`
+	UTCDateTime  string `json:"UTCDateTime"`
+	Data         []byte `json:"Data"`
+	ChecksumType string `json:"ChecksumType,omitempty"`
+	Checksum     string `json:"Checksum,omitempty"` // hex encoded checksum of Data
 }
@@ -0,0 +1,66 @@
+package tcr
+
+import (
+	"log"
+	"os"
+)
+
+// Logger receives the internal events of the RabbitService and ConnectionPool, such as reconnects, publish retries,
+// and shutdown steps. Adapt your app's logger (ex. zap, zerolog) to it with a thin wrapper.
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NoopLogger is the default Logger and discards all events.
+type NoopLogger struct{}
+
+// Debugf does nothing.
+func (NoopLogger) Debugf(format string, args ...interface{}) {}
+
+// Infof does nothing.
+func (NoopLogger) Infof(format string, args ...interface{}) {}
+
+// Warnf does nothing.
+func (NoopLogger) Warnf(format string, args ...interface{}) {}
+
+// Errorf does nothing.
+func (NoopLogger) Errorf(format string, args ...interface{}) {}
+
+// StdLogger adapts a standard library log.Logger to Logger, prefixing every event with its level.
+type StdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger creates a StdLogger writing to the logger, or to stderr when the logger is nil.
+func NewStdLogger(logger *log.Logger) *StdLogger {
+
+	if logger == nil {
+		logger = log.New(os.Stderr, "tcr ", log.LstdFlags)
+	}
+
+	return &StdLogger{logger: logger}
+}
+
+// Debugf logs a debug event.
+func (sl *StdLogger) Debugf(format string, args ...interface{}) {
+	sl.logger.Printf("DEBUG "+format, args...)
+}
+
+// Infof logs an info event.
+func (sl *StdLogger) Infof(format string, args ...interface{}) {
+	sl.logger.Printf("INFO "+format, args...)
+}
+
+// Warnf logs a warning event.
+func (sl *StdLogger) Warnf(format string, args ...interface{}) {
+	sl.logger.Printf("WARN "+format, args...)
+}
+
+// Errorf logs an error event.
+func (sl *StdLogger) Errorf(format string, args ...interface{}) {
+	sl.logger.Printf("ERROR "+format, args...)
+}
@@ -1,10 +1,14 @@
 package tcr
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/streadway/amqp"
 )
 
@@ -14,6 +18,8 @@ type PublishReceipt struct {
 	FailedLetter *Letter
 	Success      bool
 	Error        error
+	Latency      time.Duration // from the start of the publish to its confirmation (when confirmed) or failure
+	QueueTime    time.Duration // how long the letter waited for AutoPublish, zero when it wasn't queued
 }
 
 // ToString allows you to quickly log the PublishReceipt struct as a string.
@@ -27,14 +33,22 @@ func (not *PublishReceipt) ToString() string {
 
 // ReceivedMessage allow for you to acknowledge, after processing the received payload, by its RabbitMQ tag and Channel pointer.
 type ReceivedMessage struct {
-	IsAckable     bool
-	Body          []byte
-	Headers       amqp.Table
-	deliveryTag   uint64
-	amqpChan      *amqp.Channel
-	CorrelationId string
-	Timestamp     time.Time
-	AMQPDelivery  *amqp.Delivery
+	IsAckable       bool
+	Body            []byte
+	Headers         amqp.Table
+	deliveryTag     uint64
+	amqpChan        *amqp.Channel
+	channelClosed   <-chan *amqp.Error // receives or is closed once amqpChan closes, set by the Consumer
+	ContentType     string
+	ContentEncoding string
+	CorrelationId   string
+	MessageID       string
+	ReplyTo         string
+	Type            string
+	Priority        uint8
+	Timestamp       time.Time
+	AppID           string
+	AMQPDelivery    *amqp.Delivery // the full delivery, for properties not copied above
 }
 
 // NewMessage creates a new Message.
@@ -65,17 +79,40 @@ func NewMessageFromDelivery(
 	}
 
 	return &ReceivedMessage{
-		IsAckable:     isAckable,
-		Body:          delivery.Body,
-		Headers:       delivery.Headers,
-		deliveryTag:   delivery.DeliveryTag,
-		CorrelationId: delivery.CorrelationId,
-		Timestamp:     delivery.Timestamp,
-		amqpChan:      amqpChan,
-		AMQPDelivery:  delivery,
+		IsAckable:       isAckable,
+		Body:            delivery.Body,
+		Headers:         delivery.Headers,
+		deliveryTag:     delivery.DeliveryTag,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		CorrelationId:   delivery.CorrelationId,
+		MessageID:       delivery.MessageId,
+		ReplyTo:         delivery.ReplyTo,
+		Type:            delivery.Type,
+		Priority:        delivery.Priority,
+		Timestamp:       delivery.Timestamp,
+		AppID:           delivery.AppId,
+		amqpChan:        amqpChan,
+		AMQPDelivery:    delivery,
 	}, nil
 }
 
+// channelDropped tells whether the channel the message was received on has closed, its unacknowledged messages are
+// requeued by the server.
+func (msg *ReceivedMessage) channelDropped() bool {
+
+	if msg.channelClosed == nil {
+		return false
+	}
+
+	select {
+	case <-msg.channelClosed:
+		return true
+	default:
+		return false
+	}
+}
+
 // Acknowledge allows for you to acknowledge message on the original channel it was received.
 // Will fail if channel is closed and this is by design per RabbitMQ server.
 // Can't ack from a different channel.
@@ -119,6 +156,194 @@ func (msg *ReceivedMessage) Reject(requeue bool) error {
 	return msg.amqpChan.Reject(msg.deliveryTag, requeue)
 }
 
+// Unwrap reverses the wrapping performed when publishing with wrapPayload, decrypting and decompressing the Body
+// as indicated by the wrapper and then unmarshalling the result into out.
+// Errors if the Body is not a wrapped payload.
+func (msg *ReceivedMessage) Unwrap(out interface{}, compression *CompressionConfig, encryption *EncryptionConfig) error {
+
+	_, err := ReadWrappedPayload(msg.Body, out, compression, encryption)
+	return err
+}
+
+// DecodeJSON unmarshals the JSON Body into out. When the ContentType is set it has to be a JSON content type.
+// Errors include the delivery tag of the message.
+func (msg *ReceivedMessage) DecodeJSON(out interface{}) error {
+
+	if msg.ContentType != "" && !strings.Contains(strings.ToLower(msg.ContentType), "json") {
+		return fmt.Errorf("can't decode delivery %d as json, content type is %s", msg.deliveryTag, msg.ContentType)
+	}
+
+	var json = jsoniter.ConfigFastest
+	if err := json.Unmarshal(msg.Body, out); err != nil {
+		return fmt.Errorf("unable to decode delivery %d as json: %w", msg.deliveryTag, err)
+	}
+
+	return nil
+}
+
+// ExtractTraceContext extracts the trace context propagated in the Headers into a child of ctx.
+// Returns ctx unmodified if propagator is nil.
+func (msg *ReceivedMessage) ExtractTraceContext(ctx context.Context, propagator TracePropagator) context.Context {
+
+	if propagator == nil || msg.Headers == nil {
+		return ctx
+	}
+
+	return propagator.Extract(ctx, msg.Headers)
+}
+
+// DecodedMessage contains a payload decoded from a ReceivedMessage and the original ReceivedMessage for acknowledging.
+type DecodedMessage struct {
+	Payload  interface{}
+	Message  *ReceivedMessage
+	Metadata string
+	Error    error
+}
+
+// HeaderString gets the header value for key when it is a string (or bytes).
+func (msg *ReceivedMessage) HeaderString(key string) (string, bool) {
+
+	switch value := msg.Headers[key].(type) {
+	case string:
+		return value, true
+	case []byte:
+		return string(value), true
+	default:
+		return "", false
+	}
+}
+
+// HeaderInt gets the header value for key when it is any integer type, AMQP encodes integers with varying sizes.
+func (msg *ReceivedMessage) HeaderInt(key string) (int64, bool) {
+	return headerInt(msg.Headers, key)
+}
+
+// HeaderTime gets the header value for key when it is an AMQP timestamp or an RFC3339 string.
+func (msg *ReceivedMessage) HeaderTime(key string) (time.Time, bool) {
+
+	switch value := msg.Headers[key].(type) {
+	case time.Time:
+		return value, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// IsWrapped indicates the Body is a WrappedBody, per the PayloadPipelineHeader published by RabbitService.
+func (msg *ReceivedMessage) IsWrapped() bool {
+	return msg.pipelineApplied(pipelineWrapped)
+}
+
+// IsCompressed indicates the Body (the data inside the wrapper when IsWrapped) is compressed, per the
+// PayloadPipelineHeader published by RabbitService.
+func (msg *ReceivedMessage) IsCompressed() bool {
+	return msg.pipelineApplied(pipelineCompressed)
+}
+
+// IsEncrypted indicates the Body (the data inside the wrapper when IsWrapped) is encrypted, per the
+// PayloadPipelineHeader published by RabbitService.
+func (msg *ReceivedMessage) IsEncrypted() bool {
+	return msg.pipelineApplied(pipelineEncrypted)
+}
+
+// pipelineApplied checks the PayloadPipelineHeader lists the step, false without the header.
+func (msg *ReceivedMessage) pipelineApplied(step string) bool {
+
+	applied, ok := msg.HeaderString(PayloadPipelineHeader)
+	if !ok {
+		return false
+	}
+
+	for _, appliedStep := range strings.Split(applied, ",") {
+		if appliedStep == step {
+			return true
+		}
+	}
+
+	return false
+}
+
+func headerInt(headers amqp.Table, key string) (int64, bool) {
+
+	switch value := headers[key].(type) {
+	case int64:
+		return value, true
+	case int32:
+		return int64(value), true
+	case int16:
+		return int64(value), true
+	case int8:
+		return int64(value), true
+	case int:
+		return int64(value), true
+	case uint8:
+		return int64(value), true
+	case uint16:
+		return int64(value), true
+	case uint32:
+		return int64(value), true
+	case uint64:
+		if value > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(value), true
+	case uint:
+		if uint64(value) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(value), true
+	default:
+		return 0, false
+	}
+}
+
+// PoisonMessageError is sent to the Consumer errors when a message exceeds MaxRedeliveries and is dead lettered.
+type PoisonMessageError struct {
+	QueueName     string
+	ConsumerName  string
+	MessageID     string
+	Redeliveries  int
+	DeadLetterErr error // error encountered while dead lettering, if any
+}
+
+// Error allows you to quickly log the PoisonMessageError struct as a string.
+func (pme *PoisonMessageError) Error() string {
+	if pme.DeadLetterErr != nil {
+		return fmt.Sprintf("poison message [MessageID: %s] on queue %s exceeded redeliveries (%d) and failed to dead letter: %s", pme.MessageID, pme.QueueName, pme.Redeliveries, pme.DeadLetterErr)
+	}
+
+	return fmt.Sprintf("poison message [MessageID: %s] on queue %s exceeded redeliveries (%d) and was dead lettered", pme.MessageID, pme.QueueName, pme.Redeliveries)
+}
+
+// ConsumerError is sent to the Consumer errors, attributing the error to the Consumer, its queue and, when the error
+// relates to a message, the message's DeliveryTag.
+type ConsumerError struct {
+	ConsumerName string
+	QueueName    string
+	DeliveryTag  uint64 // 0 when the error doesn't relate to a message
+	Err          error
+}
+
+// Error allows you to quickly log the ConsumerError struct as a string.
+func (ce *ConsumerError) Error() string {
+	if ce.DeliveryTag > 0 {
+		return fmt.Sprintf("consumer %s on queue %s [DeliveryTag: %d]: %s", ce.ConsumerName, ce.QueueName, ce.DeliveryTag, ce.Err)
+	}
+
+	return fmt.Sprintf("consumer %s on queue %s: %s", ce.ConsumerName, ce.QueueName, ce.Err)
+}
+
+// Unwrap gets the underlying error, for errors.Is and errors.As.
+func (ce *ConsumerError) Unwrap() error {
+	return ce.Err
+}
+
 // ErrorMessage allow for you to replay a message that was returned.
 type ErrorMessage struct {
 	Code    int
@@ -189,13 +414,16 @@ func NewReturnMessage(amqpReturn *amqp.Return) *ReturnMessage {
 		Type:            amqpReturn.Type,
 		UserID:          amqpReturn.UserId,
 		AppID:           amqpReturn.AppId,
+		Body:            amqpReturn.Body,
 	}
 }
 
 // PublishConfirmation aids in guaranteed Deliverability.
 type PublishConfirmation struct {
-	DeliveryTag uint64 // Delivery Tag Id
+	LetterID    uint64 // set by PublishBatchWithConfirmation
+	DeliveryTag uint64 // Delivery Tag Id, 0 when the letter wasn't published
 	Acked       bool   // Acked Serverside
+	Error       error  // why a letter from PublishBatchWithConfirmation wasn't acked
 }
 
 // NewPublishConfirmation creates a new PublishConfirmation.
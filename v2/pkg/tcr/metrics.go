@@ -0,0 +1,31 @@
+package tcr
+
+import "time"
+
+// MetricsRecorder allows you to collect metrics from the RabbitService, Publisher, Consumers, and ConnectionPool.
+// Implementations must be safe for concurrent use.
+type MetricsRecorder interface {
+	IncPublishSuccess()
+	IncPublishFailure()
+	ObservePublishLatency(d time.Duration)
+	IncConsumed(consumer string)
+	SetConnectionPoolSize(n int)
+}
+
+// NoopMetricsRecorder is the default MetricsRecorder and discards all metrics.
+type NoopMetricsRecorder struct{}
+
+// IncPublishSuccess does nothing.
+func (NoopMetricsRecorder) IncPublishSuccess() {}
+
+// IncPublishFailure does nothing.
+func (NoopMetricsRecorder) IncPublishFailure() {}
+
+// ObservePublishLatency does nothing.
+func (NoopMetricsRecorder) ObservePublishLatency(d time.Duration) {}
+
+// IncConsumed does nothing.
+func (NoopMetricsRecorder) IncConsumed(consumer string) {}
+
+// SetConnectionPoolSize does nothing.
+func (NoopMetricsRecorder) SetConnectionPoolSize(n int) {}
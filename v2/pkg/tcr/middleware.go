@@ -0,0 +1,79 @@
+package tcr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHandlerPanic indicates a Handler panicked while processing a message.
+var ErrHandlerPanic = errors.New("consumer handler panicked")
+
+// ErrNoTypeHandler indicates DispatchByType had no Handler for the Type of a message.
+var ErrNoTypeHandler = errors.New("no handler for the message type")
+
+// Handler processes a ReceivedMessage, an error nacks (or dead letters) an ackable message.
+type Handler func(*ReceivedMessage) error
+
+// Middleware wraps a Handler with cross-cutting behavior (ex., logging, metrics, tracing, panic recovery).
+type Middleware func(next Handler) Handler
+
+// Use adds middleware around the handler given to ProcessWithHandler. The first middleware added is the outermost.
+// Add middleware before processing.
+func (con *Consumer) Use(middleware ...Middleware) {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	con.middleware = append(con.middleware, middleware...)
+}
+
+// chainHandler wraps the handler with the Consumer's middleware.
+func (con *Consumer) chainHandler(handler Handler) Handler {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	for i := len(con.middleware) - 1; i >= 0; i-- {
+		handler = con.middleware[i](handler)
+	}
+
+	return handler
+}
+
+// DispatchByType creates a Handler, for ProcessWithHandler, that routes every message to the handler for its Type (the
+// AMQP type property, see Envelope.Type). The "" handler, if any, handles the types without a handler. Otherwise the
+// message is nacked (or dead lettered) with an error wrapping ErrNoTypeHandler, which is also sent to Errors.
+func (con *Consumer) DispatchByType(handlers map[string]Handler) Handler {
+
+	return func(msg *ReceivedMessage) error {
+
+		handler, ok := handlers[msg.Type]
+		if !ok {
+			handler, ok = handlers[""]
+		}
+
+		if !ok || handler == nil {
+			err := fmt.Errorf("%w: %q", ErrNoTypeHandler, msg.Type)
+			con.errors <- con.messageError(msg, err)
+			return err
+		}
+
+		return handler(msg)
+	}
+}
+
+// RecoveryMiddleware recovers a panicking Handler, the panic is sent to Errors and returned as an error wrapping
+// ErrHandlerPanic, so the message is nacked (or dead lettered) like any other handler error.
+func (con *Consumer) RecoveryMiddleware() Middleware {
+
+	return func(next Handler) Handler {
+		return func(msg *ReceivedMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+					con.errors <- con.messageError(msg, err)
+				}
+			}()
+
+			return next(msg)
+		}
+	}
+}
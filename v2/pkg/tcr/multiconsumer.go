@@ -0,0 +1,183 @@
+package tcr
+
+import (
+	"errors"
+	"sync"
+)
+
+// MultiQueueConsumer consumes from several queues, one Consumer per ConsumerConfig, fanning their messages into
+// a single Messages channel (ex. for queues sharded by region feeding one processor). Every ReceivedMessage is still
+// acknowledged on the channel of the queue it was received from.
+type MultiQueueConsumer struct {
+	consumers    []*Consumer
+	messages     chan *ReceivedMessage
+	bufferSize   int
+	forwardGroup *sync.WaitGroup
+	started      bool
+	requeue      bool
+	lock         *sync.Mutex
+}
+
+// NewMultiQueueConsumer creates a new MultiQueueConsumer with a Consumer for each of the configs.
+func NewMultiQueueConsumer(configs []*ConsumerConfig, cp *ConnectionPool) (*MultiQueueConsumer, error) {
+
+	if len(configs) == 0 {
+		return nil, errors.New("can't create a multi queue consumer without consumer configs")
+	}
+
+	mqc := &MultiQueueConsumer{
+		consumers:    make([]*Consumer, 0, len(configs)),
+		forwardGroup: &sync.WaitGroup{},
+		lock:         &sync.Mutex{},
+	}
+
+	for _, config := range configs {
+		if config == nil {
+			return nil, errors.New("can't create a multi queue consumer with a nil consumer config")
+		}
+
+		if size := bufferSize(config.BufferSize); size > mqc.bufferSize {
+			mqc.bufferSize = size
+		}
+
+		mqc.consumers = append(mqc.consumers, NewConsumerFromConfig(config, cp))
+	}
+
+	return mqc, nil
+}
+
+// Consumers returns the underlying Consumers, one per queue, ex. to read their Errors.
+func (mqc *MultiQueueConsumer) Consumers() []*Consumer {
+	return mqc.consumers
+}
+
+// StartConsuming starts every enabled Consumer, forwarding their messages to Messages.
+func (mqc *MultiQueueConsumer) StartConsuming() error {
+	mqc.lock.Lock()
+	defer mqc.lock.Unlock()
+
+	if mqc.started {
+		return errors.New("can't start a started multi queue consumer")
+	}
+
+	if mqc.messages == nil {
+		mqc.messages = make(chan *ReceivedMessage, mqc.bufferSize)
+	}
+
+	for _, consumer := range mqc.consumers {
+		if !consumer.Enabled {
+			continue
+		}
+
+		messages := consumer.Messages() // before starting, so no message goes to ReceivedMessages
+		consumer.StartConsuming()
+
+		mqc.forwardGroup.Add(1)
+		go mqc.forward(messages, mqc.messages)
+	}
+
+	mqc.started = true
+	go mqc.closeOnStop(mqc.messages)
+
+	return nil
+}
+
+// forward sends the messages of a Consumer to Messages until the Consumer stops.
+func (mqc *MultiQueueConsumer) forward(messages <-chan *ReceivedMessage, out chan<- *ReceivedMessage) {
+	defer mqc.forwardGroup.Done()
+
+	for msg := range messages {
+		out <- msg
+	}
+}
+
+// closeOnStop closes Messages once every Consumer has stopped and its messages were forwarded.
+func (mqc *MultiQueueConsumer) closeOnStop(messages chan *ReceivedMessage) {
+
+	mqc.forwardGroup.Wait()
+
+	mqc.lock.Lock()
+	defer mqc.lock.Unlock()
+
+	if mqc.requeue {
+		requeueMessages(messages)
+	}
+
+	close(messages)
+	mqc.messages = nil
+	mqc.started = false
+	mqc.requeue = false
+}
+
+// requeueMessages nacks, with requeue, the ackable messages left in the channel. A failed nack is a closed channel,
+// RabbitMQ requeues its unacknowledged messages anyway.
+func requeueMessages(messages chan *ReceivedMessage) {
+
+	for {
+		select {
+		case msg := <-messages:
+			if msg.IsAckable {
+				_ = msg.Nack(true)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Messages yields the messages of every queue, ready for consuming. Like Consumer.Messages, it is only closed once
+// every Consumer has stopped, so it can be ranged over, and after a stop Messages returns the channel of the next
+// StartConsuming.
+func (mqc *MultiQueueConsumer) Messages() <-chan *ReceivedMessage {
+	mqc.lock.Lock()
+	defer mqc.lock.Unlock()
+
+	if mqc.messages == nil {
+		mqc.messages = make(chan *ReceivedMessage, mqc.bufferSize)
+	}
+
+	return mqc.messages
+}
+
+// StopConsuming stops every started Consumer, handling the received messages that weren't processed as set in the
+// StopOptions. With RequeueInFlight, the messages already forwarded to Messages are requeued too.
+func (mqc *MultiQueueConsumer) StopConsuming(options StopOptions) error {
+	mqc.lock.Lock()
+	defer mqc.lock.Unlock()
+
+	if !mqc.started {
+		return errors.New("can't stop a stopped multi queue consumer")
+	}
+
+	mqc.requeue = options.RequeueInFlight
+
+	var stopErr error
+	for _, consumer := range mqc.consumers {
+		if !consumer.Enabled {
+			continue
+		}
+
+		if err := consumer.StopConsumingWithOptions(options); err != nil && stopErr == nil {
+			stopErr = err
+		}
+	}
+
+	if options.FlushMessages {
+		mqc.flushMessages()
+	}
+
+	return stopErr
+}
+
+// flushMessages empties Messages without acknowledging.
+// WARNING: THIS WILL RESULT IN LOST MESSAGES.
+func (mqc *MultiQueueConsumer) flushMessages() {
+
+	for {
+		select {
+		case <-mqc.messages:
+		default:
+			return
+		}
+	}
+}
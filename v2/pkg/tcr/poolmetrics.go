@@ -0,0 +1,123 @@
+package tcr
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentLeaseWaits is how many of the latest lease waits the percentiles are calculated from.
+const recentLeaseWaits = 1024
+
+// PoolMetrics is a snapshot of the ConnectionPool's connections, cached channels, and channel lease waits.
+// Lease waits are how long GetChannelFromPool waited for an idle cached channel.
+type PoolMetrics struct {
+	Connections   int
+	Channels      int
+	ChannelsInUse int
+	ChannelsIdle  int
+	TotalLeases   uint64
+	LeaseWaitMin  time.Duration
+	LeaseWaitAvg  time.Duration
+	LeaseWaitMax  time.Duration
+	LeaseWaitP50  time.Duration // of the latest 1024 leases
+	LeaseWaitP95  time.Duration // of the latest 1024 leases
+	LeaseWaitP99  time.Duration // of the latest 1024 leases
+}
+
+// leaseWaits tracks how long channel leases waited for a channel.
+type leaseWaits struct {
+	count  uint64
+	total  time.Duration
+	min    time.Duration
+	max    time.Duration
+	recent []time.Duration
+	next   int
+	lock   *sync.Mutex
+}
+
+func newLeaseWaits() *leaseWaits {
+
+	return &leaseWaits{
+		recent: make([]time.Duration, 0, recentLeaseWaits),
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (lw *leaseWaits) record(wait time.Duration) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+
+	if lw.count == 0 || wait < lw.min {
+		lw.min = wait
+	}
+
+	if wait > lw.max {
+		lw.max = wait
+	}
+
+	lw.count++
+	lw.total += wait
+
+	if len(lw.recent) < recentLeaseWaits {
+		lw.recent = append(lw.recent, wait)
+		return
+	}
+
+	lw.recent[lw.next] = wait
+	lw.next = (lw.next + 1) % recentLeaseWaits
+}
+
+func (lw *leaseWaits) snapshot(metrics *PoolMetrics) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+
+	if lw.count == 0 {
+		return
+	}
+
+	metrics.TotalLeases = lw.count
+	metrics.LeaseWaitMin = lw.min
+	metrics.LeaseWaitAvg = lw.total / time.Duration(lw.count)
+	metrics.LeaseWaitMax = lw.max
+
+	sorted := make([]time.Duration, len(lw.recent))
+	copy(sorted, lw.recent)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	metrics.LeaseWaitP50 = percentile(sorted, 0.50)
+	metrics.LeaseWaitP95 = percentile(sorted, 0.95)
+	metrics.LeaseWaitP99 = percentile(sorted, 0.99)
+}
+
+// percentile gets the nearest rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index]
+}
+
+// Metrics gets a snapshot of the ConnectionPool's connections, cached channels, and channel lease waits.
+func (cp *ConnectionPool) Metrics() *PoolMetrics {
+
+	cp.poolRWLock.RLock()
+	metrics := &PoolMetrics{
+		Connections: len(cp.connectionHosts),
+		Channels:    len(cp.channelHosts),
+	}
+	cp.poolRWLock.RUnlock()
+
+	metrics.ChannelsIdle = len(cp.channels)
+	metrics.ChannelsInUse = metrics.Channels - metrics.ChannelsIdle
+	if metrics.ChannelsInUse < 0 {
+		metrics.ChannelsInUse = 0
+	}
+
+	cp.leaseWaits.snapshot(metrics)
+
+	return metrics
+}
@@ -2,13 +2,65 @@ package tcr
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/streadway/amqp"
 )
 
+const defaultMaxQueueSize = 1000
+
+var (
+	// ErrPublishQueueFull is returned when a letter can't be queued because the Publisher's queue is full.
+	ErrPublishQueueFull = errors.New("publisher queue is full")
+
+	// ErrPublisherNotAccepting is returned when a letter can't be queued because the Publisher is flushed or stopped.
+	ErrPublisherNotAccepting = errors.New("publisher is not accepting letters")
+
+	// ErrPublishUnconfirmed is a letter published but not confirmed before its context was done, its fate is unknown.
+	ErrPublishUnconfirmed = errors.New("letter was published but not confirmed")
+
+	// ErrPublishNotSent is a letter that wasn't published before its context was done.
+	ErrPublishNotSent = errors.New("letter was not published")
+
+	// ErrPublishNacked is a letter the broker nacked (negatively acknowledged), it wasn't enqueued.
+	ErrPublishNacked = errors.New("letter was nacked by the broker")
+
+	// ErrConfirmationLost is a letter published on a channel that closed (to be reconnected) before its confirmation
+	// arrived, the confirmation can't arrive anymore and the letter's fate is unknown.
+	ErrConfirmationLost = errors.New("channel reconnected, confirmation lost")
+)
+
+// PublishContextError is a publish with confirmation stopped by its context. It unwraps to the context error and
+// matches ErrPublishUnconfirmed when the letter was Sent, otherwise ErrPublishNotSent, with errors.Is.
+type PublishContextError struct {
+	LetterID uint64
+	Sent     bool
+	Err      error
+}
+
+func (pce *PublishContextError) Error() string {
+
+	if pce.Sent {
+		return fmt.Sprintf("publish confirmation for LetterID: %d wasn't received before context expired - recommend retry/requeue: %s", pce.LetterID, pce.Err)
+	}
+
+	return fmt.Sprintf("LetterID: %d wasn't published before context expired: %s", pce.LetterID, pce.Err)
+}
+
+// Unwrap returns the context error.
+func (pce *PublishContextError) Unwrap() error {
+	return pce.Err
+}
+
+// Is matches ErrPublishUnconfirmed for a Sent letter and ErrPublishNotSent otherwise.
+func (pce *PublishContextError) Is(target error) bool {
+	return (pce.Sent && target == ErrPublishUnconfirmed) || (!pce.Sent && target == ErrPublishNotSent)
+}
+
 // Publisher contains everything you need to publish a message.
 type Publisher struct {
 	Config                 *RabbitSeasoning
@@ -17,10 +69,22 @@ type Publisher struct {
 	autoStop               chan bool
 	publishReceipts        chan *PublishReceipt
 	autoStarted            bool
+	flushing               bool
+	pendingLetters         int64
+	outstandingConfirms    int64         // letters published and waiting for their confirmation
+	confirmsIdle           chan struct{} // signaled when the OutstandingConfirms reach zero
+	queueTime              int64         // nanoseconds the last letter waited for AutoPublish
 	autoPublishGroup       *sync.WaitGroup
 	sleepOnIdleInterval    time.Duration
 	sleepOnErrorInterval   time.Duration
 	publishTimeOutDuration time.Duration
+	metrics                MetricsRecorder
+	tracePropagator        TracePropagator
+	appID                  string
+	breaker                *circuitBreaker
+	deduplicate            bool
+	pendingIDs             map[uint64]struct{} // LetterIDs queued or publishing, when deduplicating
+	pendingLock            *sync.Mutex
 	pubLock                *sync.Mutex
 	pubRWLock              *sync.RWMutex
 }
@@ -30,16 +94,28 @@ func NewPublisherFromConfig(
 	config *RabbitSeasoning,
 	cp *ConnectionPool) *Publisher {
 
+	maxQueueSize := config.PublisherConfig.MaxQueueSize
+	if maxQueueSize <= 0 {
+		maxQueueSize = defaultMaxQueueSize
+	}
+
 	return &Publisher{
 		Config:                 config,
 		ConnectionPool:         cp,
-		letters:                make(chan *Letter, 1000),
+		letters:                make(chan *Letter, maxQueueSize),
 		autoStop:               make(chan bool, 1),
 		autoPublishGroup:       &sync.WaitGroup{},
 		publishReceipts:        make(chan *PublishReceipt, 1000),
+		confirmsIdle:           make(chan struct{}, 1),
 		sleepOnIdleInterval:    time.Duration(config.PublisherConfig.SleepOnIdleInterval) * time.Millisecond,
 		sleepOnErrorInterval:   time.Duration(config.PublisherConfig.SleepOnErrorInterval) * time.Millisecond,
 		publishTimeOutDuration: time.Duration(config.PublisherConfig.PublishTimeOutInterval) * time.Millisecond,
+		metrics:                NoopMetricsRecorder{},
+		appID:                  config.AppID,
+		breaker:                newCircuitBreaker(config.PublisherConfig),
+		deduplicate:            config.PublisherConfig.DeduplicateLetters,
+		pendingIDs:             make(map[uint64]struct{}),
+		pendingLock:            &sync.Mutex{},
 		pubLock:                &sync.Mutex{},
 		pubRWLock:              &sync.RWMutex{},
 		autoStarted:            false,
@@ -55,13 +131,17 @@ func NewPublisher(
 
 	return &Publisher{
 		ConnectionPool:         cp,
-		letters:                make(chan *Letter, 1000),
+		letters:                make(chan *Letter, defaultMaxQueueSize),
 		autoStop:               make(chan bool, 1),
 		autoPublishGroup:       &sync.WaitGroup{},
 		publishReceipts:        make(chan *PublishReceipt, 1000),
+		confirmsIdle:           make(chan struct{}, 1),
 		sleepOnIdleInterval:    sleepOnIdleInterval,
 		sleepOnErrorInterval:   sleepOnErrorInterval,
 		publishTimeOutDuration: publishTimeOutDuration,
+		metrics:                NoopMetricsRecorder{},
+		pendingIDs:             make(map[uint64]struct{}),
+		pendingLock:            &sync.Mutex{},
 		pubLock:                &sync.Mutex{},
 		pubRWLock:              &sync.RWMutex{},
 		autoStarted:            false,
@@ -73,27 +153,401 @@ func NewPublisher(
 // For proper resilience (at least once delivery guarantee over shaky network) use PublishWithConfirmation
 func (pub *Publisher) Publish(letter *Letter, skipReceipt bool) {
 
+	publishStart := time.Now()
+	err := pub.breaker.allow(letter.Envelope.Exchange, letter.Envelope.RoutingKey)
+	if err == nil {
+		err = pub.publish(letter)
+	}
+
+	if !skipReceipt {
+		pub.publishReceipt(letter, err, publishStart)
+	} else {
+		pub.recordPublish(err, publishStart)
+		pub.breaker.record(letter.Envelope.Exchange, letter.Envelope.RoutingKey, err)
+	}
+}
+
+// PublishSync sends a single message to the address on the letter using a cached ChannelHost, bypassing the
+// auto-publish queue, and returns the publish error instead of sending a receipt to PublishReceipts.
+// A nil error means the message was written to the channel, not that the broker received it (use
+// PublishWithConfirmationContext for that).
+func (pub *Publisher) PublishSync(letter *Letter) error {
+
+	publishStart := time.Now()
+	err := pub.breaker.allow(letter.Envelope.Exchange, letter.Envelope.RoutingKey)
+	if err == nil {
+		err = pub.publish(letter)
+	}
+
+	pub.recordPublish(err, publishStart)
+	pub.breaker.record(letter.Envelope.Exchange, letter.Envelope.RoutingKey, err)
+
+	return err
+}
+
+// publish sends the letter on a cached ChannelHost and returns the ChannelHost to the pool.
+func (pub *Publisher) publish(letter *Letter) error {
+
 	chanHost := pub.ConnectionPool.GetChannelFromPool()
 
-	err := chanHost.Channel.Publish(
+	_, err := chanHost.Publish(
 		letter.Envelope.Exchange,
 		letter.Envelope.RoutingKey,
 		letter.Envelope.Mandatory,
 		letter.Envelope.Immediate,
-		amqp.Publishing{
-			ContentType:   letter.Envelope.ContentType,
-			Body:          letter.Body,
-			Headers:       letter.Envelope.Headers,
-			DeliveryMode:  letter.Envelope.DeliveryMode,
-			CorrelationId: letter.Envelope.CorrelationId,
-		},
+		newPublishing(letter, pub.appID),
 	)
 
-	if !skipReceipt {
-		pub.publishReceipt(letter, err)
+	// The channel closed underneath us (ex. a channel error from an earlier operation), recycle it and retry once.
+	if isChannelClosedError(err) {
+		pub.ConnectionPool.ReturnChannel(chanHost, true)
+		chanHost = pub.ConnectionPool.GetChannelFromPool()
+
+		_, err = chanHost.Publish(
+			letter.Envelope.Exchange,
+			letter.Envelope.RoutingKey,
+			letter.Envelope.Mandatory,
+			letter.Envelope.Immediate,
+			newPublishing(letter, pub.appID),
+		)
 	}
 
 	pub.ConnectionPool.ReturnChannel(chanHost, err != nil)
+
+	return err
+}
+
+// PublishWithContext injects the trace context from ctx into the letter's headers, when a TracePropagator is set,
+// and then sends a single message to the address on the letter using a cached ChannelHost.
+// Subscribe to PublishReceipts to see success and errors.
+func (pub *Publisher) PublishWithContext(ctx context.Context, letter *Letter, skipReceipt bool) {
+
+	pub.injectTraceContext(ctx, letter)
+	pub.Publish(letter, skipReceipt)
+}
+
+// PublishBatch sends every letter to its address using a single cached ChannelHost and returns a receipt per letter.
+// On a channel error the batch stops early, the receipt at the failed index has the error, and every letter after it
+// receives a failed receipt without being published. A letter whose circuit is open isn't published, its receipt has
+// ErrCircuitOpen, and the batch goes on. Receipts are not sent to PublishReceipts.
+// The cached channels are in confirm mode, so the batch's publish confirmations are read (but not reported, use
+// PublishBatchWithConfirmation for that) before the channel is returned, otherwise they would pile up unread on it.
+func (pub *Publisher) PublishBatch(letters []*Letter) []PublishReceipt {
+
+	receipts := make([]PublishReceipt, len(letters))
+	if len(letters) == 0 {
+		return receipts
+	}
+
+	chanHost := pub.ConnectionPool.GetChannelFromPool()
+	confirms := chanHost.confirmations()
+	returned := chanHost.returned()
+
+	var published int64
+	defer func() {
+		pub.addOutstandingConfirms(-published)
+	}()
+
+	var batchErr error
+	var lastTag, confirmedTag uint64
+	failedIndex := -1
+	for i, letter := range letters {
+		if batchErr != nil {
+			receipts[i] = *newPublishReceipt(letter, fmt.Errorf("letter not published, batch stopped at index %d: %w", failedIndex, batchErr), 0)
+			continue
+		}
+
+		publishStart := time.Now()
+		if err := pub.breaker.allow(letter.Envelope.Exchange, letter.Envelope.RoutingKey); err != nil {
+			receipts[i] = *newPublishReceipt(letter, err, 0)
+			continue
+		}
+
+		deliveryTag, err := chanHost.Publish(
+			letter.Envelope.Exchange,
+			letter.Envelope.RoutingKey,
+			letter.Envelope.Mandatory,
+			letter.Envelope.Immediate,
+			newPublishing(letter, pub.appID),
+		)
+
+		pub.recordPublish(err, publishStart)
+		pub.breaker.record(letter.Envelope.Exchange, letter.Envelope.RoutingKey, err)
+		receipts[i] = *newPublishReceipt(letter, err, time.Since(publishStart))
+
+		if err != nil {
+			batchErr = err
+			failedIndex = i
+			receipts[i].Error = fmt.Errorf("batch publish failed at index %d: %w", i, err)
+			continue
+		}
+
+		lastTag = deliveryTag
+		published++
+		pub.addOutstandingConfirms(1)
+
+		// Keep reading confirmations and returns while publishing so neither buffer fills up, a full returns buffer
+		// blocks the connection's reader.
+	DrainConfirmations:
+		for {
+			select {
+			case confirmation, ok := <-confirms:
+				if !ok {
+					break DrainConfirmations // the channel closed, the next publish errors
+				}
+
+				confirmedTag = confirmation.DeliveryTag
+			case amqpReturn, ok := <-returned:
+				if !ok {
+					returned = nil // the channel closed, the confirmations are closed too
+					continue
+				}
+
+				forwardReturn(&amqpReturn, pub.ConnectionPool.returns)
+			default:
+				break DrainConfirmations
+			}
+		}
+	}
+
+	err := batchErr
+	if err == nil && confirmedTag < lastTag {
+		err = pub.awaitConfirmations(confirms, returned, lastTag, pub.publishTimeout())
+	}
+
+	// A channel whose confirmations didn't all arrive is recycled, so they can't arrive later and fill its buffer.
+	pub.ConnectionPool.ReturnChannel(chanHost, err != nil)
+
+	return receipts
+}
+
+// awaitConfirmations reads confirmations up to the delivery tag, erroring when the channel closes or the timeout
+// passes first. Returns arriving meanwhile are forwarded to the pool's Returns.
+func (pub *Publisher) awaitConfirmations(confirms <-chan amqp.Confirmation, returned <-chan amqp.Return, deliveryTag uint64, timeout time.Duration) error {
+
+	timeoutAfter := time.After(timeout)
+	for {
+		select {
+		case amqpReturn, ok := <-returned:
+			if !ok {
+				returned = nil
+				continue
+			}
+
+			forwardReturn(&amqpReturn, pub.ConnectionPool.returns)
+		case confirmation, ok := <-confirms:
+			if !ok {
+				return ErrConfirmationLost
+			}
+
+			if confirmation.DeliveryTag >= deliveryTag {
+				return nil
+			}
+		case <-timeoutAfter:
+			return fmt.Errorf("confirmation of delivery tag %d wasn't received in a timely manner", deliveryTag)
+		}
+	}
+}
+
+// PublishBatchWithConfirmation publishes every letter on a single cached ChannelHost and then waits for all of their
+// confirmations at once, which is much faster than confirming each letter before publishing the next. Returns a
+// confirmation per letter, nacked letters have Acked false and an Error. When ctx is done before every letter is
+// confirmed, the unconfirmed letters have the ctx error and it is returned as well, their fate is unknown. A publish
+// error stops the batch, the letters after it are not published. A letter whose circuit is open isn't published, it
+// has ErrCircuitOpen, and the batch goes on. Receipts are not sent to PublishReceipts.
+func (pub *Publisher) PublishBatchWithConfirmation(ctx context.Context, letters []*Letter) ([]PublishConfirmation, error) {
+
+	confirmations := make([]PublishConfirmation, len(letters))
+	if len(letters) == 0 {
+		return confirmations, nil
+	}
+
+	chanHost := pub.ConnectionPool.GetChannelFromPool()
+	chanHost.FlushConfirms()
+	chanHost.FlushReturns(pub.ConnectionPool.returns)
+
+	publishStart := time.Now()
+	pending := make(map[uint64]int, len(letters)) // delivery tag to letter index
+
+	confirm := func(confirmation amqp.Confirmation) {
+		index, ok := pending[confirmation.DeliveryTag]
+		if !ok {
+			return // confirmation of a publish before this batch
+		}
+
+		delete(pending, confirmation.DeliveryTag)
+		pub.addOutstandingConfirms(-1)
+		confirmations[index].Acked = confirmation.Ack
+		if !confirmation.Ack {
+			confirmations[index].Error = fmt.Errorf("letter %d was nacked, delivery tag %d", letters[index].LetterID, confirmation.DeliveryTag)
+		}
+		pub.recordPublish(confirmations[index].Error, publishStart)
+		pub.breaker.record(letters[index].Envelope.Exchange, letters[index].Envelope.RoutingKey, confirmations[index].Error)
+	}
+
+	fail := func(err error) ([]PublishConfirmation, error) {
+		pub.addOutstandingConfirms(-int64(len(pending)))
+		for _, index := range pending {
+			pub.breaker.record(letters[index].Envelope.Exchange, letters[index].Envelope.RoutingKey, err)
+		}
+
+		for i := range confirmations {
+			if !confirmations[i].Acked && confirmations[i].Error == nil {
+				confirmations[i].Error = err
+				pub.recordPublish(err, publishStart)
+			}
+		}
+
+		pub.ConnectionPool.ReturnChannel(chanHost, true)
+		return confirmations, err
+	}
+
+	confirms := chanHost.confirmations()
+	returned := chanHost.returned()
+	for i, letter := range letters {
+		confirmations[i].LetterID = letter.LetterID
+
+		if err := pub.breaker.allow(letter.Envelope.Exchange, letter.Envelope.RoutingKey); err != nil {
+			confirmations[i].Error = err
+			pub.recordPublish(err, publishStart)
+			continue
+		}
+
+		deliveryTag, err := chanHost.Publish(
+			letter.Envelope.Exchange,
+			letter.Envelope.RoutingKey,
+			letter.Envelope.Mandatory,
+			letter.Envelope.Immediate,
+			newPublishing(letter, pub.appID),
+		)
+		if err != nil {
+			pub.breaker.record(letter.Envelope.Exchange, letter.Envelope.RoutingKey, err)
+			return fail(fmt.Errorf("batch publish failed at index %d: %w", i, err))
+		}
+
+		confirmations[i].DeliveryTag = deliveryTag
+		pending[deliveryTag] = i
+		pub.addOutstandingConfirms(1)
+
+		// Keep reading confirmations and returns while publishing so neither buffer fills up.
+	DrainConfirmations:
+		for {
+			select {
+			case confirmation, ok := <-confirms:
+				if !ok {
+					return fail(fmt.Errorf("%w before every letter was confirmed", ErrConfirmationLost))
+				}
+
+				confirm(confirmation)
+			case amqpReturn, ok := <-returned:
+				if !ok {
+					returned = nil
+					continue
+				}
+
+				forwardReturn(&amqpReturn, pub.ConnectionPool.returns)
+			default:
+				break DrainConfirmations
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fail(ctx.Err())
+
+		case confirmation, ok := <-confirms:
+			if !ok {
+				return fail(fmt.Errorf("%w before every letter was confirmed", ErrConfirmationLost))
+			}
+
+			confirm(confirmation)
+
+		case amqpReturn, ok := <-returned:
+			if !ok {
+				returned = nil
+				continue
+			}
+
+			forwardReturn(&amqpReturn, pub.ConnectionPool.returns)
+		}
+	}
+
+	pub.ConnectionPool.ReturnChannel(chanHost, false)
+	return confirmations, nil
+}
+
+// PublishTransaction sends every letter inside an AMQP transaction on a dedicated transient channel, either every
+// letter is committed or none are. When a publish or the commit fails the transaction is rolled back and the error
+// returned. Transactions are much slower than publisher confirms and a channel can't use both, so the cached (confirm
+// mode) channels are never used. When the circuit of any letter is open, none are published and ErrCircuitOpen is
+// returned. Receipts are not sent to PublishReceipts.
+func (pub *Publisher) PublishTransaction(letters []*Letter) error {
+
+	if len(letters) == 0 {
+		return nil
+	}
+
+	if err := pub.breaker.allowLetters(letters); err != nil {
+		return err
+	}
+
+	channel := pub.ConnectionPool.GetTransientChannel(false)
+	defer func() {
+		defer func() {
+			_ = recover()
+		}()
+		channel.Close()
+	}()
+
+	publishStart := time.Now()
+
+	if err := channel.Tx(); err != nil {
+		return fmt.Errorf("unable to start transaction: %w", err)
+	}
+
+	// The letters are outstanding until the transaction is committed or rolled back.
+	pub.addOutstandingConfirms(int64(len(letters)))
+	defer pub.addOutstandingConfirms(-int64(len(letters)))
+
+	for i, letter := range letters {
+		err := channel.Publish(
+			letter.Envelope.Exchange,
+			letter.Envelope.RoutingKey,
+			letter.Envelope.Mandatory,
+			letter.Envelope.Immediate,
+			newPublishing(letter, pub.appID),
+		)
+		if err != nil {
+			pub.recordPublish(err, publishStart)
+			pub.breaker.record(letter.Envelope.Exchange, letter.Envelope.RoutingKey, err)
+			return pub.rollbackTransaction(channel, fmt.Errorf("transaction publish failed at index %d: %w", i, err))
+		}
+	}
+
+	if err := channel.TxCommit(); err != nil {
+		pub.recordPublish(err, publishStart)
+		pub.breaker.recordLetters(letters, err)
+		return pub.rollbackTransaction(channel, fmt.Errorf("unable to commit transaction: %w", err))
+	}
+
+	pub.breaker.recordLetters(letters, nil)
+	for range letters {
+		pub.recordPublish(nil, publishStart)
+	}
+
+	return nil
+}
+
+// rollbackTransaction rolls back the channel transaction and returns err, including the rollback error if any.
+func (pub *Publisher) rollbackTransaction(channel *amqp.Channel, err error) error {
+
+	if rollbackErr := channel.TxRollback(); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %s)", err, rollbackErr)
+	}
+
+	return err
 }
 
 // PublishWithTransient sends a single message to the address on the letter using a transient (new) RabbitMQ channel.
@@ -114,67 +568,87 @@ func (pub *Publisher) PublishWithTransient(letter *Letter) error {
 		letter.Envelope.RoutingKey,
 		letter.Envelope.Mandatory,
 		letter.Envelope.Immediate,
-		amqp.Publishing{
-			ContentType:   letter.Envelope.ContentType,
-			Body:          letter.Body,
-			Headers:       letter.Envelope.Headers,
-			DeliveryMode:  letter.Envelope.DeliveryMode,
-			CorrelationId: letter.Envelope.CorrelationId,
-		},
+		newPublishing(letter, pub.appID),
 	)
 }
 
+// PublishWithCallback publishes the letter with confirmation in the background, like PublishWithConfirmation with the
+// PublishTimeOutInterval, and calls callback with its PublishReceipt (on its own goroutine) once it is confirmed or
+// fails. The receipt isn't sent to the PublishReceipts, so a RabbitService doesn't retry it, the FailedLetter of a
+// failed receipt can be queued or published again and calls callback again.
+func (pub *Publisher) PublishWithCallback(letter *Letter, callback func(*PublishReceipt)) {
+
+	letter.onReceipt = callback
+	go pub.PublishWithConfirmation(letter, 0)
+}
+
 // PublishWithConfirmation sends a single message to the address on the letter with confirmation capabilities.
 // This is an expensive and slow call - use this when delivery confirmation on publish is your highest priority.
 // A timeout failure drops the letter back in the PublishReceipts.
 // A confirmation failure keeps trying to publish (at least until timeout failure occurs.)
 func (pub *Publisher) PublishWithConfirmation(letter *Letter, timeout time.Duration) {
 
+	publishStart := time.Now()
+	if pub.circuitOpen(letter, publishStart) {
+		return
+	}
+
 	if timeout == 0 {
-		timeout = pub.publishTimeOutDuration
+		timeout = pub.confirmationTimeout(letter)
 	}
 
+	sent := false
+	defer pub.confirmDone(&sent)
+
 	for {
 		// Has to use an Ackable channel for Publish Confirmations.
 		chanHost := pub.ConnectionPool.GetChannelFromPool()
 		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost.FlushReturns(pub.ConnectionPool.returns)
 
 	Publish:
 		timeoutAfter := time.After(timeout) // timeoutAfter resets everytime we try to publish.
-		err := chanHost.Channel.Publish(
+		deliveryTag, confirms, err := chanHost.publish(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				CorrelationId: letter.Envelope.CorrelationId,
-			},
+			newPublishing(letter, pub.appID),
 		)
 		if err != nil {
 			pub.ConnectionPool.ReturnChannel(chanHost, true)
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
+		pub.confirmSent(&sent)
+
+		// Wait for the confirmation with our delivery tag, earlier ones are from publishes that timed out.
 		for {
 			select {
 			case <-timeoutAfter:
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d wasn't received in a timely manner - recommend retry/requeue", letter.LetterID))
+				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d wasn't received in a timely manner - recommend retry/requeue", letter.LetterID), publishStart)
 				pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
 				return
 
-			case confirmation := <-chanHost.Confirmations:
+			case confirmation, ok := <-confirms:
+
+				if !ok { // the channel closed, the pool reconnects it when returned
+					pub.publishReceipt(letter, fmt.Errorf("%w for LetterId: %d - recommend retry/requeue", ErrConfirmationLost, letter.LetterID), publishStart)
+					pub.ConnectionPool.ReturnChannel(chanHost, true)
+					return
+				}
+
+				if confirmation.DeliveryTag < deliveryTag {
+					continue // confirmation of an earlier publish on this channel
+				}
 
 				if !confirmation.Ack {
 					goto Publish //nack has occurred, republish
 				}
 
 				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
+				// Unroutable mandatory publishes are still acked, so check if the letter was returned.
+				pub.publishReceipt(letter, pub.returnedLetterError(letter, chanHost.Returns, true), publishStart)
 				pub.ConnectionPool.ReturnChannel(chanHost, false)
 				return
 
@@ -192,16 +666,24 @@ func (pub *Publisher) PublishWithConfirmation(letter *Letter, timeout time.Durat
 // A confirmation failure keeps trying to publish (at least until timeout failure occurs.)
 func (pub *Publisher) PublishWithConfirmationV2(letter *Letter, timeout time.Duration, errorHandler func(error)) {
 
+	publishStart := time.Now()
+	if pub.circuitOpen(letter, publishStart) {
+		return
+	}
+
 	if timeout == 0 {
 		timeout = pub.publishTimeOutDuration
 	}
 
+	sent := false
+	defer pub.confirmDone(&sent)
+
 	timeoutAfter := time.After(timeout)
 
 	for {
 		select {
 		case <-timeoutAfter:
-			pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d not able get channel in in a timely manner - recommend retry/requeue", letter.LetterID))
+			pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d not able get channel in in a timely manner - recommend retry/requeue", letter.LetterID), publishStart)
 			return
 		default:
 		}
@@ -209,19 +691,14 @@ func (pub *Publisher) PublishWithConfirmationV2(letter *Letter, timeout time.Dur
 		// Has to use an Ackable channel for Publish Confirmations.
 		chanHost := pub.ConnectionPool.GetChannelFromPool()
 		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost.FlushReturns(pub.ConnectionPool.returns)
 
-		err := chanHost.Channel.Publish(
+		deliveryTag, confirms, err := chanHost.publish(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				CorrelationId: letter.Envelope.CorrelationId,
-			},
+			newPublishing(letter, pub.appID),
 		)
 
 		if err != nil {
@@ -231,26 +708,39 @@ func (pub *Publisher) PublishWithConfirmationV2(letter *Letter, timeout time.Dur
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
+		pub.confirmSent(&sent)
+
+		// Wait for the confirmation with our delivery tag, earlier ones are from publishes that timed out.
 		for {
 			select {
 			case <-timeoutAfter:
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d wasn't received in a timely manner - recommend retry/requeue", letter.LetterID))
+				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d wasn't received in a timely manner - recommend retry/requeue", letter.LetterID), publishStart)
 
 				pub.ConnectionPool.ReturnChannel(chanHost, true) // Timed out, worth to treat it as error
 				return
 
-			case confirmation := <-chanHost.Confirmations:
+			case confirmation, ok := <-confirms:
+
+				if !ok { // the channel closed, the pool reconnects it when returned
+					pub.publishReceipt(letter, fmt.Errorf("%w for LetterId: %d - recommend retry/requeue", ErrConfirmationLost, letter.LetterID), publishStart)
+					pub.ConnectionPool.ReturnChannel(chanHost, true)
+					return
+				}
+
+				if confirmation.DeliveryTag < deliveryTag {
+					continue // confirmation of an earlier publish on this channel
+				}
 
 				if !confirmation.Ack {
-					pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d was nack. - recommend retry/requeu", letter.LetterID))
+					pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d was nack. - recommend retry/requeu", letter.LetterID), publishStart)
 
 					pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
 					return
 				}
 
 				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
+				// Unroutable mandatory publishes are still acked, so check if the letter was returned.
+				pub.publishReceipt(letter, pub.returnedLetterError(letter, chanHost.Returns, true), publishStart)
 
 				pub.ConnectionPool.ReturnChannel(chanHost, false)
 				return
@@ -265,52 +755,111 @@ func (pub *Publisher) PublishWithConfirmationV2(letter *Letter, timeout time.Dur
 
 // PublishWithConfirmationContext sends a single message to the address on the letter with confirmation capabilities.
 // This is an expensive and slow call - use this when delivery confirmation on publish is your highest priority.
-// A timeout failure drops the letter back in the PublishReceipts.
+// A timeout failure drops the letter back in the PublishReceipts, with a *PublishContextError telling a letter that
+// was published but not confirmed apart from one that was never published.
 // A confirmation failure keeps trying to publish (at least until timeout failure occurs.)
 func (pub *Publisher) PublishWithConfirmationContext(ctx context.Context, letter *Letter) {
+	_ = pub.publishWithConfirmationContext(ctx, letter)
+}
+
+// publishWithConfirmationContext is PublishWithConfirmationContext returning the error of the PublishReceipt.
+func (pub *Publisher) publishWithConfirmationContext(ctx context.Context, letter *Letter) error {
+
+	_, err := pub.publishWithConfirmationResult(ctx, letter, true)
+	return err
+}
+
+// PublishWithConfirmationResult is PublishWithConfirmationContext returning the letter's PublishConfirmation, with its
+// delivery tag, and the error of its PublishReceipt. A nacked letter isn't republished, its confirmation has Acked
+// false and the error matches ErrPublishNacked with errors.Is. The confirmation's DeliveryTag is 0 when the letter
+// wasn't published.
+func (pub *Publisher) PublishWithConfirmationResult(ctx context.Context, letter *Letter) (*PublishConfirmation, error) {
+
+	return pub.publishWithConfirmationResult(ctx, letter, false)
+}
+
+// publishWithConfirmationResult publishes the letter and waits for its confirmation until ctx is done, republishing
+// nacked letters when republishNacks is set.
+func (pub *Publisher) publishWithConfirmationResult(ctx context.Context, letter *Letter, republishNacks bool) (*PublishConfirmation, error) {
+
+	publishStart := time.Now()
+	pub.injectTraceContext(ctx, letter)
+
+	result := &PublishConfirmation{LetterID: letter.LetterID}
+	fail := func(err error) (*PublishConfirmation, error) {
+		result.Error = err
+		pub.publishReceipt(letter, err, publishStart)
+		return result, err
+	}
+
+	if err := pub.breaker.allow(letter.Envelope.Exchange, letter.Envelope.RoutingKey); err != nil {
+		return fail(err)
+	}
+
+	sent := false
+	defer pub.confirmDone(&sent)
 
 	for {
+		if ctx.Err() != nil {
+			return fail(&PublishContextError{LetterID: letter.LetterID, Sent: sent, Err: ctx.Err()})
+		}
+
 		// Has to use an Ackable channel for Publish Confirmations.
 		chanHost := pub.ConnectionPool.GetChannelFromPool()
 		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost.FlushReturns(pub.ConnectionPool.returns)
 
 	Publish:
-		err := chanHost.Channel.Publish(
+		deliveryTag, confirms, err := chanHost.publish(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				CorrelationId: letter.Envelope.CorrelationId,
-			},
+			newPublishing(letter, pub.appID),
 		)
 		if err != nil {
 			pub.ConnectionPool.ReturnChannel(chanHost, true)
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
+		pub.confirmSent(&sent)
+		result.DeliveryTag = deliveryTag
+
+		// Wait for the confirmation with our delivery tag, earlier ones are from publishes that timed out.
 		for {
 			select {
 			case <-ctx.Done():
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterID: %d wasn't received before context expired - recommend retry/requeue", letter.LetterID))
 				pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
-				return
+				return fail(&PublishContextError{LetterID: letter.LetterID, Sent: true, Err: ctx.Err()})
 
-			case confirmation := <-chanHost.Confirmations:
+			case confirmation, ok := <-confirms:
+
+				if !ok { // the channel closed, the pool reconnects it when returned
+					pub.ConnectionPool.ReturnChannel(chanHost, true)
+					return fail(fmt.Errorf("%w for LetterId: %d - recommend retry/requeue", ErrConfirmationLost, letter.LetterID))
+				}
+
+				if confirmation.DeliveryTag < deliveryTag {
+					continue // confirmation of an earlier publish on this channel
+				}
 
 				if !confirmation.Ack {
-					goto Publish //nack has occurred, republish
+					if republishNacks {
+						goto Publish //nack has occurred, republish
+					}
+
+					pub.ConnectionPool.ReturnChannel(chanHost, false)
+					return fail(fmt.Errorf("%w: LetterId: %d, delivery tag %d", ErrPublishNacked, letter.LetterID, deliveryTag))
 				}
 
 				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
+				// Unroutable mandatory publishes are still acked, so check if the letter was returned.
+				result.Acked = true
+				err := pub.returnedLetterError(letter, chanHost.Returns, true)
+				result.Error = err
+				pub.publishReceipt(letter, err, publishStart)
 				pub.ConnectionPool.ReturnChannel(chanHost, false)
-				return
+				return result, err
 
 			default:
 
@@ -326,6 +875,11 @@ func (pub *Publisher) PublishWithConfirmationContext(ctx context.Context, letter
 //   gets requeued for re-publish.
 // A confirmation failure keeps trying to publish (at least until timeout failure occurs.)
 func (pub *Publisher) PublishWithConfirmationTransient(letter *Letter, timeout time.Duration) {
+	publishStart := time.Now()
+	if pub.circuitOpen(letter, publishStart) {
+		return
+	}
+
 	maxRetryOnError := 3
 	retryOnError := 0
 
@@ -333,11 +887,16 @@ func (pub *Publisher) PublishWithConfirmationTransient(letter *Letter, timeout t
 		timeout = pub.publishTimeOutDuration
 	}
 
+	sent := false
+	defer pub.confirmDone(&sent)
+
 	for {
 		// Has to use an Ackable channel for Publish Confirmations.
 		channel := pub.ConnectionPool.GetTransientChannel(true)
 		confirms := make(chan amqp.Confirmation, 1)
 		channel.NotifyPublish(confirms)
+		returns := make(chan amqp.Return, 10)
+		channel.NotifyReturn(returns) // the pool also forwards returns from transient channels
 
 	Publish:
 		timeoutAfter := time.After(timeout)
@@ -346,13 +905,7 @@ func (pub *Publisher) PublishWithConfirmationTransient(letter *Letter, timeout t
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				CorrelationId: letter.Envelope.CorrelationId,
-			},
+			newPublishing(letter, pub.appID),
 		)
 
 		if err != nil {
@@ -365,27 +918,36 @@ func (pub *Publisher) PublishWithConfirmationTransient(letter *Letter, timeout t
 				retryOnError++
 				continue // Take it again! From the top!
 			} else {
-				pub.publishReceipt(letter, fmt.Errorf("publish for LetterId: %d failed to be published %v. No more retry can be performed.", letter.LetterID, err))
+				pub.publishReceipt(letter, fmt.Errorf("publish for LetterId: %d failed to be published %v. No more retry can be performed.", letter.LetterID, err), publishStart)
 				return
 			}
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
+		pub.confirmSent(&sent)
+
+		// Wait for very next confirmation on this transient channel, which is our confirmation.
 		for {
 			select {
 			case <-timeoutAfter:
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d wasn't received in a timely manner (%dms) - recommend retry/requeue", letter.LetterID, timeout))
+				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterId: %d wasn't received in a timely manner (%dms) - recommend retry/requeue", letter.LetterID, timeout), publishStart)
 				channel.Close()
 				return
 
-			case confirmation := <-confirms:
+			case confirmation, ok := <-confirms:
+
+				if !ok { // the transient channel closed
+					pub.publishReceipt(letter, fmt.Errorf("%w for LetterId: %d - recommend retry/requeue", ErrConfirmationLost, letter.LetterID), publishStart)
+					channel.Close()
+					return
+				}
 
 				if !confirmation.Ack {
 					goto Publish //nack has occurred, republish
 				}
 
 				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
+				// Unroutable mandatory publishes are still acked, so check if the letter was returned.
+				pub.publishReceipt(letter, pub.returnedLetterError(letter, returns, false), publishStart)
 				channel.Close()
 				return
 
@@ -397,6 +959,38 @@ func (pub *Publisher) PublishWithConfirmationTransient(letter *Letter, timeout t
 	}
 }
 
+// Returns yields all the messages returned (basic.return) by the server, ex. an unroutable mandatory publish.
+func (pub *Publisher) Returns() <-chan *ReturnMessage {
+	return pub.ConnectionPool.Returns()
+}
+
+// returnedLetterError drains the returned messages (basic.return), optionally forwarding them to the pool's returns,
+// and errors when one is the mandatory letter just published. The server sends the return before the confirmation
+// on the same channel, so a returned letter is always pending by the time its confirmation has been received.
+func (pub *Publisher) returnedLetterError(letter *Letter, returns <-chan amqp.Return, forward bool) error {
+
+	var err error
+	for {
+		select {
+		case amqpReturn := <-returns:
+			if letter.Envelope.Mandatory &&
+				amqpReturn.Exchange == letter.Envelope.Exchange &&
+				amqpReturn.RoutingKey == letter.Envelope.RoutingKey &&
+				amqpReturn.CorrelationId == letter.Envelope.CorrelationId &&
+				amqpReturn.MessageId == letter.MessageID {
+
+				err = fmt.Errorf("publish for LetterId: %d was returned unroutable [code: %d] [reason: %s] - recommend verifying exchange and routing key", letter.LetterID, amqpReturn.ReplyCode, amqpReturn.ReplyText)
+			}
+
+			if forward {
+				forwardReturn(&amqpReturn, pub.ConnectionPool.returns)
+			}
+		default:
+			return err
+		}
+	}
+}
+
 // PublishReceipts yields all the success and failures during all publish events. Highly recommend susbscribing to this.
 func (pub *Publisher) PublishReceipts() <-chan *PublishReceipt {
 	return pub.publishReceipts
@@ -413,6 +1007,14 @@ func (pub *Publisher) StartAutoPublishing() {
 	}
 }
 
+// autoPublishing indicates AutoPublish has been started and not stopped.
+func (pub *Publisher) autoPublishing() bool {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	return pub.autoStarted
+}
+
 // StartAutoPublish starts auto-publishing letters queued up - is locking.
 func (pub *Publisher) startAutoPublishingLoop() {
 
@@ -442,7 +1044,12 @@ AutoPublishLoop:
 func (pub *Publisher) deliverLetters() bool {
 
 	// Allow parallel publishing with transient channels.
-	parallelPublishSemaphore := make(chan struct{}, pub.Config.PoolConfig.MaxCacheChannelCount/2+1)
+	maxParallelPublishes := int(pub.Config.PoolConfig.MaxCacheChannelCount/2 + 1)
+	if pub.Config.PublisherConfig != nil && pub.Config.PublisherConfig.MaxParallelPublishes > 0 {
+		maxParallelPublishes = pub.Config.PublisherConfig.MaxParallelPublishes
+	}
+
+	parallelPublishSemaphore := make(chan struct{}, maxParallelPublishes)
 
 	for {
 
@@ -450,11 +1057,18 @@ func (pub *Publisher) deliverLetters() bool {
 	PublishLoop:
 		for {
 			select {
-			case letter := <-pub.letters:
+			case letter, ok := <-pub.letters:
+				if !ok { // letters are closed when the publisher has been stopped
+					return true
+				}
+
+				letter.queueTime = time.Since(letter.QueuedAt)
+				atomic.StoreInt64(&pub.queueTime, int64(letter.queueTime))
 
 				parallelPublishSemaphore <- struct{}{}
 				go func(letter *Letter) {
-					pub.PublishWithConfirmation(letter, pub.publishTimeOutDuration)
+					pub.PublishWithConfirmation(letter, pub.confirmationTimeout(letter))
+					atomic.AddInt64(&pub.pendingLetters, -1)
 					<-parallelPublishSemaphore
 				}(letter)
 
@@ -481,6 +1095,27 @@ func (pub *Publisher) deliverLetters() bool {
 	}
 }
 
+// confirmationTimeout is the letter's ConfirmationTimeout, otherwise the PublishTimeOutInterval or the default when
+// neither is set.
+func (pub *Publisher) confirmationTimeout(letter *Letter) time.Duration {
+
+	if letter.ConfirmationTimeout > 0 {
+		return letter.ConfirmationTimeout
+	}
+
+	return pub.publishTimeout()
+}
+
+// publishTimeout is the PublishTimeOutInterval or the default when not set.
+func (pub *Publisher) publishTimeout() time.Duration {
+
+	if pub.publishTimeOutDuration > 0 {
+		return pub.publishTimeOutDuration
+	}
+
+	return defaultPublishConfirmationTimeout
+}
+
 // stopAutoPublish stops publishing letters queued up.
 func (pub *Publisher) stopAutoPublish() {
 	pub.pubLock.Lock()
@@ -512,43 +1147,338 @@ func (pub *Publisher) QueueLetter(letter *Letter) bool {
 	return pub.safeSend(letter)
 }
 
+// QueueLetterWithTimeout queues up a letter that will be consumed by AutoPublish, waiting up to timeout when the queue
+// is full. Returns ErrPublishQueueFull if the letter couldn't be queued in time (immediately with a zero timeout).
+func (pub *Publisher) QueueLetterWithTimeout(letter *Letter, timeout time.Duration) error {
+
+	return pub.queueLetter(letter, timeout)
+}
+
+// QueueDepth is the number of letters queued and waiting for AutoPublish.
+func (pub *Publisher) QueueDepth() int {
+	return len(pub.letters)
+}
+
+// QueueTime is how long the last letter picked up by AutoPublish waited in the queue. A growing QueueTime is an early
+// sign of broker backpressure.
+func (pub *Publisher) QueueTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&pub.queueTime))
+}
+
+// OutstandingConfirms is the number of letters published with confirmation that are waiting for their confirmation,
+// including the letters of a PublishBatch or PublishTransaction in progress. Flushing and Shutdown wait for it to
+// reach zero.
+func (pub *Publisher) OutstandingConfirms() int {
+	return int(atomic.LoadInt64(&pub.outstandingConfirms))
+}
+
+// addOutstandingConfirms adds delta to the OutstandingConfirms, signaling confirmsIdle when they reach zero.
+func (pub *Publisher) addOutstandingConfirms(delta int64) {
+
+	if atomic.AddInt64(&pub.outstandingConfirms, delta) <= 0 {
+		select {
+		case pub.confirmsIdle <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// awaitOutstandingConfirms waits for the OutstandingConfirms to reach zero, erroring when ctx is done first.
+func (pub *Publisher) awaitOutstandingConfirms(ctx context.Context) error {
+
+	for pub.OutstandingConfirms() > 0 {
+		select {
+		case <-pub.confirmsIdle:
+		case <-ctx.Done():
+			return fmt.Errorf("%d outstanding confirms: %w", pub.OutstandingConfirms(), ctx.Err())
+		}
+	}
+
+	return nil
+}
+
+// confirmSent counts the letter in the OutstandingConfirms on its first publish.
+func (pub *Publisher) confirmSent(sent *bool) {
+
+	if !*sent {
+		*sent = true
+		pub.addOutstandingConfirms(1)
+	}
+}
+
+// confirmDone removes a sent letter from the OutstandingConfirms, deferred until it's confirmed or failed.
+func (pub *Publisher) confirmDone(sent *bool) {
+
+	if *sent {
+		pub.addOutstandingConfirms(-1)
+	}
+}
+
 // safeSend should handle a scenario on publishing to a closed channel.
 func (pub *Publisher) safeSend(letter *Letter) (closed bool) {
+
+	return pub.queueLetter(letter, -1) == nil
+}
+
+// queueLetter queues the letter waiting up to timeout when full (indefinitely when negative) and handles a scenario
+// on publishing to a closed channel.
+func (pub *Publisher) queueLetter(letter *Letter, timeout time.Duration) error {
+	pub.pubLock.Lock()
+	flushing := pub.flushing
+	pub.pubLock.Unlock()
+
+	if flushing {
+		return ErrPublisherNotAccepting
+	}
+
+	return pub.enqueueLetter(letter, timeout)
+}
+
+// requeueLetter queues a failed letter again for retry, even while flushing since the flush waits for the letters
+// it had accepted.
+func (pub *Publisher) requeueLetter(letter *Letter) bool {
+
+	return pub.enqueueLetter(letter, -1) == nil
+}
+
+// enqueueLetter sends the letter to the queue waiting up to timeout when full (indefinitely when negative).
+func (pub *Publisher) enqueueLetter(letter *Letter, timeout time.Duration) (err error) {
+
+	if !pub.trackPending(letter) {
+		return nil // already queued or publishing
+	}
+
+	letter.QueuedAt = time.Now()
+	atomic.AddInt64(&pub.pendingLetters, 1)
 	defer func() {
 		if recover() != nil {
-			closed = false
+			atomic.AddInt64(&pub.pendingLetters, -1)
+			pub.releasePending(letter)
+			err = ErrPublisherNotAccepting
 		}
 	}()
 
-	pub.letters <- letter
-	return true // success
+	if timeout < 0 {
+		pub.letters <- letter
+		return nil // success
+	}
+
+	select {
+	case pub.letters <- letter:
+		return nil // success
+	default:
+	}
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case pub.letters <- letter:
+			return nil // success
+		case <-timer.C:
+		}
+	}
+
+	atomic.AddInt64(&pub.pendingLetters, -1)
+	pub.releasePending(letter)
+	return ErrPublishQueueFull
 }
 
-// publishReceipt sends the status to the receipt channel.
-func (pub *Publisher) publishReceipt(letter *Letter, err error) {
+// trackPending adds the letter's LetterID to the pending LetterIDs when the Publisher deduplicates letters, returning
+// false when a letter with that LetterID is already queued or publishing.
+func (pub *Publisher) trackPending(letter *Letter) bool {
+
+	if !pub.deduplicate {
+		return true
+	}
+
+	pub.pendingLock.Lock()
+	defer pub.pendingLock.Unlock()
+
+	if _, ok := pub.pendingIDs[letter.LetterID]; ok {
+		return false
+	}
+
+	pub.pendingIDs[letter.LetterID] = struct{}{}
+	letter.pending = true
+	return true
+}
+
+// releasePending removes the LetterID of a letter tracked by trackPending from the pending LetterIDs.
+func (pub *Publisher) releasePending(letter *Letter) {
+	pub.pendingLock.Lock()
+	defer pub.pendingLock.Unlock()
+
+	if letter.pending {
+		delete(pub.pendingIDs, letter.LetterID)
+		letter.pending = false
+	}
+}
 
-	go func(*Letter, error) {
-		publishReceipt := &PublishReceipt{
-			LetterID: letter.LetterID,
-			Error:    err,
+// FlushWithContext stops the Publisher from accepting new letters while it waits for every queued letter to be
+// published with confirmation (or fail with a receipt), and for the OutstandingConfirms, returning the count of
+// letters flushed. Failed letters retried by the RabbitService are still queued during the flush.
+// Errors if ctx is done before the queue is drained. Starts AutoPublishing if letters are queued and it isn't running.
+func (pub *Publisher) FlushWithContext(ctx context.Context) (int, error) {
+	pub.pubLock.Lock()
+	pub.flushing = true
+	autoStarted := pub.autoStarted
+	pub.pubLock.Unlock()
+
+	defer func() {
+		pub.pubLock.Lock()
+		pub.flushing = false
+		pub.pubLock.Unlock()
+	}()
+
+	pendingAtStart := atomic.LoadInt64(&pub.pendingLetters)
+	if pendingAtStart > 0 && !autoStarted {
+		pub.StartAutoPublishing()
+	}
+
+	for {
+		pending := atomic.LoadInt64(&pub.pendingLetters)
+		if pending <= 0 && pub.OutstandingConfirms() == 0 {
+			return int(pendingAtStart), nil
 		}
 
-		if err == nil {
-			publishReceipt.Success = true
-		} else {
-			publishReceipt.FailedLetter = letter
+		select {
+		case <-ctx.Done():
+			return int(pendingAtStart - pending), ctx.Err()
+		default:
+			time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
 		}
+	}
+}
+
+// publishReceipt sends the status to the receipt channel.
+func (pub *Publisher) publishReceipt(letter *Letter, err error, publishStart time.Time) {
+
+	// Released before the receipt is sent so a failed letter can be queued again for retry.
+	pub.releasePending(letter)
+	pub.recordPublish(err, publishStart)
+	pub.breaker.record(letter.Envelope.Exchange, letter.Envelope.RoutingKey, err)
+
+	receipt := newPublishReceipt(letter, err, time.Since(publishStart))
+	if letter.onReceipt != nil {
+		go letter.onReceipt(receipt) // a slow callback can't hold up the publish
+		return
+	}
+
+	go func(*PublishReceipt) {
+		pub.publishReceipts <- receipt
+	}(receipt)
+}
+
+// newPublishReceipt creates the PublishReceipt for a letter, a failed receipt includes the letter for retry.
+func newPublishReceipt(letter *Letter, err error, latency time.Duration) *PublishReceipt {
+
+	publishReceipt := &PublishReceipt{
+		LetterID:  letter.LetterID,
+		Error:     err,
+		Latency:   latency,
+		QueueTime: letter.queueTime,
+	}
+
+	if err == nil {
+		publishReceipt.Success = true
+	} else {
+		publishReceipt.FailedLetter = letter
+	}
+
+	return publishReceipt
+}
+
+// recordPublish records the outcome of a publish with the MetricsRecorder.
+func (pub *Publisher) recordPublish(err error, publishStart time.Time) {
+
+	if err != nil {
+		pub.metrics.IncPublishFailure()
+		return
+	}
 
-		pub.publishReceipts <- publishReceipt
-	}(letter, err)
+	pub.metrics.IncPublishSuccess()
+	pub.metrics.ObservePublishLatency(time.Since(publishStart))
 }
 
-// Shutdown cleanly shutdown the publisher and resets it's internal state.
+// encryptionConfig returns the EncryptionConfig of the Config, read under the lock since the RabbitService swaps it
+// on RotateEncryptionKey.
+func (pub *Publisher) encryptionConfig() *EncryptionConfig {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	return pub.Config.EncryptionConfig
+}
+
+// setEncryptionConfig swaps the EncryptionConfig of the Config.
+func (pub *Publisher) setEncryptionConfig(config *EncryptionConfig) {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	pub.Config.EncryptionConfig = config
+}
+
+// SetMetricsRecorder sets the MetricsRecorder used to record publish metrics. Set before publishing.
+func (pub *Publisher) SetMetricsRecorder(metrics MetricsRecorder) {
+	if metrics == nil {
+		metrics = NoopMetricsRecorder{}
+	}
+
+	pub.metrics = metrics
+}
+
+// SetTracePropagator sets the TracePropagator used to inject trace context into letter headers when publishing with a context.
+// Only PublishWithContext, PublishWithConfirmationContext and PublishWithConfirmationResult (and the RabbitService's
+// PublishWithConfirmationContext) have a context to inject. Publish, PublishWithConfirmation, QueueLetter, the batch,
+// transaction and stream publishes, and the other RabbitService publishes don't, so inject the trace context into
+// the letter's headers before calling them (ex. propagator.Inject(ctx, letter.Envelope.Headers)).
+func (pub *Publisher) SetTracePropagator(propagator TracePropagator) {
+	pub.tracePropagator = propagator
+}
+
+// injectTraceContext gives the letter a copy of its Envelope with the trace context from ctx injected into a copy of
+// its headers, so an Envelope shared by concurrent publishes is never written to and keeps no stale trace context.
+func (pub *Publisher) injectTraceContext(ctx context.Context, letter *Letter) {
+
+	if pub.tracePropagator == nil || letter.Envelope == nil {
+		return
+	}
+
+	headers := make(amqp.Table, len(letter.Envelope.Headers)+1)
+	for key, value := range letter.Envelope.Headers {
+		headers[key] = value
+	}
+
+	pub.tracePropagator.Inject(ctx, headers)
+
+	envelope := *letter.Envelope
+	envelope.Headers = headers
+	letter.Envelope = &envelope
+}
+
+// Shutdown cleanly shutdown the publisher and resets it's internal state, after the OutstandingConfirms are confirmed
+// (or fail), waiting for them up to 10 seconds. Use ShutdownWithContext to choose how long to wait.
 func (pub *Publisher) Shutdown(shutdownPools bool) {
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownConfirmTimeout)
+	defer cancel()
+
+	_ = pub.ShutdownWithContext(ctx, shutdownPools)
+}
+
+// ShutdownWithContext is Shutdown waiting for the OutstandingConfirms until ctx is done. The publisher is shutdown
+// either way, the error reports the confirmations that were still outstanding (their letters' fate is unknown).
+func (pub *Publisher) ShutdownWithContext(ctx context.Context, shutdownPools bool) error {
+
 	pub.stopAutoPublish()
 
+	// Confirmations are lost with their channels, so wait for the outstanding ones.
+	err := pub.awaitOutstandingConfirms(ctx)
+
 	if shutdownPools { // in case the ChannelPool is shared between structs, you can prevent it from shutting down
 		pub.ConnectionPool.Shutdown()
 	}
+
+	return err
 }
@@ -1,6 +1,8 @@
 package tcr
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -8,22 +10,40 @@ import (
 	"sync/atomic"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/streadway/amqp"
 )
 
+const (
+	defaultShutdownFlushTimeout       = time.Second * 10
+	defaultShutdownConfirmTimeout     = time.Second * 10
+	defaultPublishConfirmationTimeout = time.Millisecond * 300
+	defaultMonitorInterval            = time.Millisecond * 200
+)
+
+// ErrServiceNotInitialized is returned by a RabbitService without a Config, ConnectionPool, or Publisher, ex. one not
+// created with NewRabbitService.
+var ErrServiceNotInitialized = errors.New("rabbitservice is not initialized")
+
 // RabbitService is the struct for containing all you need for RabbitMQ access.
 type RabbitService struct {
 	Config               *RabbitSeasoning
 	ConnectionPool       *ConnectionPool
 	Topologer            *Topologer
 	Publisher            *Publisher
+	publishers           map[string]*Publisher
+	processReceipts      func(*PublishReceipt)
 	encryptionConfigured bool
 	centralErr           chan error
 	consumers            map[string]*Consumer
 	shutdownSignal       chan bool
 	shutdown             bool
+	cooldownRetries      map[*time.Timer]struct{} // pending retries of short-circuited letters
 	letterCount          uint64
+	messageIDPrefix      string
+	headers              amqp.Table
 	monitorSleepInterval time.Duration
+	logger               Logger
 	serviceLock          *sync.Mutex
 }
 
@@ -35,6 +55,20 @@ func NewRabbitService(
 	processPublishReceipts func(*PublishReceipt),
 	processError func(error)) (*RabbitService, error) {
 
+	var keyLength uint32
+	if config.EncryptionConfig != nil && config.EncryptionConfig.Enabled {
+		var err error
+		if keyLength, err = config.EncryptionConfig.hashKeyLength(); err != nil {
+			return nil, err
+		}
+
+		if len(passphrase) > 0 && len(salt) > 0 {
+			if err := config.EncryptionConfig.validateArgon2(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	connectionPool, err := NewConnectionPool(config.PoolConfig)
 	if err != nil {
 		return nil, err
@@ -51,10 +85,24 @@ func NewRabbitService(
 		centralErr:           make(chan error, 1000),
 		shutdownSignal:       make(chan bool, 1),
 		consumers:            make(map[string]*Consumer),
-		monitorSleepInterval: time.Duration(200) * time.Millisecond,
+		publishers:           make(map[string]*Publisher),
+		cooldownRetries:      make(map[*time.Timer]struct{}),
+		processReceipts:      processPublishReceipts,
+		messageIDPrefix:      RandomString(12),
+		monitorSleepInterval: defaultMonitorInterval,
+		logger:               NoopLogger{},
 		serviceLock:          &sync.Mutex{},
 	}
 
+	if config.MonitorInterval > 0 {
+		rs.monitorSleepInterval = time.Duration(config.MonitorInterval) * time.Millisecond
+	}
+
+	if config.Logger != nil {
+		rs.logger = config.Logger
+		connectionPool.SetLogger(config.Logger)
+	}
+
 	// Build a Map for Consumer retrieval.
 	err = rs.createConsumers(config.ConsumerConfigs)
 	if err != nil {
@@ -69,7 +117,8 @@ func NewRabbitService(
 			rs.Config.EncryptionConfig.TimeConsideration,
 			rs.Config.EncryptionConfig.MemoryMultiplier,
 			rs.Config.EncryptionConfig.Threads,
-			32)
+			keyLength)
+		rs.Config.EncryptionConfig.KeyID = GetKeyID(rs.Config.EncryptionConfig.Hashkey)
 
 		rs.encryptionConfigured = true
 	}
@@ -79,11 +128,7 @@ func NewRabbitService(
 	go rs.monitorForShutdown()
 
 	// Monitors all publish events
-	if processPublishReceipts != nil {
-		go rs.invokeProcessPublishReceipts(processPublishReceipts)
-	} else { // Default action is to retry publishing all failures.
-		go rs.processPublishReceipts()
-	}
+	rs.monitorPublishReceipts(rs.Publisher)
 
 	// Monitors all errors
 	if processError != nil {
@@ -102,27 +147,49 @@ func NewRabbitService(
 func (rs *RabbitService) createConsumers(consumerConfigs map[string]*ConsumerConfig) error {
 
 	for consumerName, consumerConfig := range consumerConfigs {
+		rs.consumers[consumerName] = rs.newConsumer(consumerConfig)
+	}
 
-		consumer := NewConsumerFromConfig(consumerConfig, rs.ConnectionPool)
-		hostName, err := os.Hostname()
+	return nil
+}
 
-		if err == nil {
-			consumer.ConsumerName = hostName + "-" + consumer.ConsumerName
-		}
+// newConsumer creates a Consumer from the config, its ConsumerName prefixed with the host name.
+func (rs *RabbitService) newConsumer(consumerConfig *ConsumerConfig) *Consumer {
+
+	consumer := NewConsumerFromConfig(consumerConfig, rs.ConnectionPool)
+	hostName, err := os.Hostname()
 
-		rs.consumers[consumerName] = consumer
+	if err == nil {
+		consumer.ConsumerName = hostName + "-" + consumer.ConsumerName
 	}
 
-	return nil
+	return consumer
+}
+
+// consumerList is a snapshot of the RabbitService's Consumers.
+func (rs *RabbitService) consumerList() []*Consumer {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	consumers := make([]*Consumer, 0, len(rs.consumers))
+	for _, consumer := range rs.consumers {
+		consumers = append(consumers, consumer)
+	}
+
+	return consumers
 }
 
-// PublishWithConfirmation tries to publish and wait for a confirmation.
+// PublishWithConfirmation tries to publish and wait for a confirmation up to the PublishConfirmationTimeout.
 func (rs *RabbitService) PublishWithConfirmation(
 	input interface{},
 	exchangeName, routingKey, metadata string,
 	wrapPayload bool,
 	headers amqp.Table) error {
 
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
 	if rs.shutdown {
 		return errors.New("unable to publish as service shutdown triggered")
 	}
@@ -134,26 +201,17 @@ func (rs *RabbitService) PublishWithConfirmation(
 	currentCount := atomic.LoadUint64(&rs.letterCount)
 	atomic.AddUint64(&rs.letterCount, 1)
 
-	var data []byte
-	var err error
-	if wrapPayload {
-		data, err = CreateWrappedPayload(input, currentCount, metadata, rs.Config.CompressionConfig, rs.Config.EncryptionConfig)
-		if err != nil {
-			return err
-		}
-	} else {
-		data, err = CreatePayload(input, rs.Config.CompressionConfig, rs.Config.EncryptionConfig)
-		if err != nil {
-			return err
-		}
+	data, pipeline, err := rs.createPayload(input, currentCount, metadata, wrapPayload)
+	if err != nil {
+		return err
 	}
 
-	// Non-Transient Has A Bug For Now
-	// https://github.com/streadway/amqp/issues/459
-	rs.Publisher.PublishWithConfirmationTransient(
+	// Cached channels are put in confirm mode once and confirmations are matched by delivery tag.
+	rs.Publisher.PublishWithConfirmation(
 		&Letter{
-			LetterID: currentCount,
-			Body:     data,
+			LetterID:  currentCount,
+			MessageID: rs.newMessageID(currentCount),
+			Body:      data,
 			Envelope: &Envelope{
 				Exchange:     exchangeName,
 				RoutingKey:   routingKey,
@@ -161,14 +219,140 @@ func (rs *RabbitService) PublishWithConfirmation(
 				Mandatory:    false,
 				Immediate:    false,
 				DeliveryMode: 2,
-				Headers:      headers,
+				Headers:      rs.payloadHeaders(headers, pipeline),
 			},
 		},
-		time.Duration(time.Millisecond*300))
+		rs.publishConfirmationTimeout())
+
+	return nil
+}
+
+// PublishWithConfirmationContext tries to publish and waits for a confirmation until ctx is done.
+// When ctx is done first a *PublishContextError is returned, it unwraps to ctx.Err() and matches ErrPublishUnconfirmed
+// (sent, fate unknown) or ErrPublishNotSent (never sent) with errors.Is. Other failures go to the PublishReceipts.
+func (rs *RabbitService) PublishWithConfirmationContext(
+	ctx context.Context,
+	input interface{},
+	exchangeName, routingKey, metadata string,
+	wrapPayload bool,
+	headers amqp.Table) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	if rs.shutdown {
+		return errors.New("unable to publish as service shutdown triggered")
+	}
+
+	if input == nil || (exchangeName == "" && routingKey == "") {
+		return errors.New("can't have a nil body or an empty exchangename with empty routing key")
+	}
+
+	currentCount := atomic.LoadUint64(&rs.letterCount)
+	atomic.AddUint64(&rs.letterCount, 1)
+
+	data, pipeline, err := rs.createPayload(input, currentCount, metadata, wrapPayload)
+	if err != nil {
+		return err
+	}
+
+	err = rs.Publisher.publishWithConfirmationContext(
+		ctx,
+		&Letter{
+			LetterID:  currentCount,
+			MessageID: rs.newMessageID(currentCount),
+			Body:      data,
+			Envelope: &Envelope{
+				Exchange:     exchangeName,
+				RoutingKey:   routingKey,
+				ContentType:  "application/json",
+				Mandatory:    false,
+				Immediate:    false,
+				DeliveryMode: 2,
+				Headers:      rs.payloadHeaders(headers, pipeline),
+			},
+		})
+
+	var contextErr *PublishContextError
+	if errors.As(err, &contextErr) {
+		return err
+	}
+
+	return nil
+}
+
+// PublishWithConfirmationResult tries to publish and wait for a confirmation up to the PublishConfirmationTimeout,
+// returning the PublishConfirmation with the letter's delivery tag. A nacked letter isn't republished, it returns a
+// confirmation with Acked false and an error matching ErrPublishNacked with errors.Is.
+func (rs *RabbitService) PublishWithConfirmationResult(
+	input interface{},
+	exchangeName, routingKey, metadata string,
+	wrapPayload bool,
+	headers amqp.Table) (*PublishConfirmation, error) {
+
+	if err := rs.initialized(); err != nil {
+		return nil, err
+	}
+
+	if rs.shutdown {
+		return nil, errors.New("unable to publish as service shutdown triggered")
+	}
+
+	if input == nil || (exchangeName == "" && routingKey == "") {
+		return nil, errors.New("can't have a nil body or an empty exchangename with empty routing key")
+	}
+
+	currentCount := atomic.LoadUint64(&rs.letterCount)
+	atomic.AddUint64(&rs.letterCount, 1)
+
+	data, pipeline, err := rs.createPayload(input, currentCount, metadata, wrapPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rs.publishConfirmationTimeout())
+	defer cancel()
+
+	return rs.Publisher.PublishWithConfirmationResult(
+		ctx,
+		&Letter{
+			LetterID:  currentCount,
+			MessageID: rs.newMessageID(currentCount),
+			Body:      data,
+			Envelope: &Envelope{
+				Exchange:     exchangeName,
+				RoutingKey:   routingKey,
+				ContentType:  "application/json",
+				Mandatory:    false,
+				Immediate:    false,
+				DeliveryMode: 2,
+				Headers:      rs.payloadHeaders(headers, pipeline),
+			},
+		})
+}
+
+// initialized errors with ErrServiceNotInitialized when the RabbitService is missing its Config, ConnectionPool, or
+// Publisher, instead of panicking on them further down.
+func (rs *RabbitService) initialized() error {
+
+	if rs == nil || rs.Config == nil || rs.ConnectionPool == nil || rs.Publisher == nil || rs.Publisher.ConnectionPool == nil {
+		return ErrServiceNotInitialized
+	}
 
 	return nil
 }
 
+// publishConfirmationTimeout is the configured PublishConfirmationTimeout or the default when not set.
+func (rs *RabbitService) publishConfirmationTimeout() time.Duration {
+
+	if rs.Config.PublishConfirmationTimeout == 0 {
+		return defaultPublishConfirmationTimeout
+	}
+
+	return time.Duration(rs.Config.PublishConfirmationTimeout) * time.Millisecond
+}
+
 // Publish tries to publish directly without retry and data optionally wrapped in a ModdedLetter.
 func (rs *RabbitService) Publish(
 	input interface{},
@@ -176,6 +360,61 @@ func (rs *RabbitService) Publish(
 	wrapPayload bool,
 	headers amqp.Table) error {
 
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	return rs.publish(rs.Publisher, input, exchangeName, routingKey, metadata, wrapPayload, headers)
+}
+
+// PublishDefault is Publish with the RabbitSeasoning's DefaultMetadata and DefaultWrapPayload.
+func (rs *RabbitService) PublishDefault(input interface{}, exchangeName, routingKey string, headers amqp.Table) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	return rs.publish(rs.Publisher, input, exchangeName, routingKey, rs.Config.DefaultMetadata, rs.Config.DefaultWrapPayload, headers)
+}
+
+// PublishWithConfirmationDefault is PublishWithConfirmation with the RabbitSeasoning's DefaultMetadata and
+// DefaultWrapPayload.
+func (rs *RabbitService) PublishWithConfirmationDefault(input interface{}, exchangeName, routingKey string, headers amqp.Table) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	return rs.PublishWithConfirmation(input, exchangeName, routingKey, rs.Config.DefaultMetadata, rs.Config.DefaultWrapPayload, headers)
+}
+
+// PublishWith is Publish with the named Publisher added with AddPublisher.
+func (rs *RabbitService) PublishWith(
+	publisherName string,
+	input interface{},
+	exchangeName, routingKey, metadata string,
+	wrapPayload bool,
+	headers amqp.Table) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	publisher, err := rs.PublisherByName(publisherName)
+	if err != nil {
+		return err
+	}
+
+	return rs.publish(publisher, input, exchangeName, routingKey, metadata, wrapPayload, headers)
+}
+
+func (rs *RabbitService) publish(
+	publisher *Publisher,
+	input interface{},
+	exchangeName, routingKey, metadata string,
+	wrapPayload bool,
+	headers amqp.Table) error {
+
 	if rs.shutdown {
 		return errors.New("unable to publish as service shutdown triggered")
 	}
@@ -187,24 +426,16 @@ func (rs *RabbitService) Publish(
 	currentCount := atomic.LoadUint64(&rs.letterCount)
 	atomic.AddUint64(&rs.letterCount, 1)
 
-	var data []byte
-	var err error
-	if wrapPayload {
-		data, err = CreateWrappedPayload(input, currentCount, metadata, rs.Config.CompressionConfig, rs.Config.EncryptionConfig)
-		if err != nil {
-			return err
-		}
-	} else {
-		data, err = CreatePayload(input, rs.Config.CompressionConfig, rs.Config.EncryptionConfig)
-		if err != nil {
-			return err
-		}
+	data, pipeline, err := rs.createPayload(input, currentCount, metadata, wrapPayload)
+	if err != nil {
+		return err
 	}
 
-	rs.Publisher.Publish(
+	publisher.Publish(
 		&Letter{
-			LetterID: currentCount,
-			Body:     data,
+			LetterID:  currentCount,
+			MessageID: rs.newMessageID(currentCount),
+			Body:      data,
 			Envelope: &Envelope{
 				Exchange:     exchangeName,
 				RoutingKey:   routingKey,
@@ -212,6 +443,7 @@ func (rs *RabbitService) Publish(
 				Mandatory:    false,
 				Immediate:    false,
 				DeliveryMode: 2,
+				Headers:      rs.payloadHeaders(headers, pipeline),
 			},
 		},
 		false)
@@ -219,12 +451,31 @@ func (rs *RabbitService) Publish(
 	return nil
 }
 
+// PublishToQueue tries to publish directly to the queue, through the default exchange with the queue name as the
+// routing key, without retry and data optionally wrapped in a ModdedLetter.
+func (rs *RabbitService) PublishToQueue(
+	input interface{},
+	queueName, metadata string,
+	wrapPayload bool,
+	headers amqp.Table) error {
+
+	if queueName == "" {
+		return errors.New("can't publish to a queue with an empty queue name")
+	}
+
+	return rs.Publish(input, "", queueName, metadata, wrapPayload, headers)
+}
+
 // PublishData tries to publish.
 func (rs *RabbitService) PublishData(
 	data []byte,
 	exchangeName, routingKey string,
 	headers amqp.Table) error {
 
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
 	if rs.shutdown {
 		return errors.New("unable to publish as service shutdown triggered")
 	}
@@ -238,8 +489,9 @@ func (rs *RabbitService) PublishData(
 
 	rs.Publisher.Publish(
 		&Letter{
-			LetterID: currentCount,
-			Body:     data,
+			LetterID:  currentCount,
+			MessageID: rs.newMessageID(currentCount),
+			Body:      data,
 			Envelope: &Envelope{
 				Exchange:     exchangeName,
 				RoutingKey:   routingKey,
@@ -247,7 +499,7 @@ func (rs *RabbitService) PublishData(
 				Mandatory:    false,
 				Immediate:    false,
 				DeliveryMode: 2,
-				Headers:      headers,
+				Headers:      rs.publishHeaders(headers),
 			},
 		},
 		false)
@@ -256,8 +508,13 @@ func (rs *RabbitService) PublishData(
 }
 
 // PublishLetter wraps around Publisher to simply Publish.
+// A MessageID already set on the letter is kept, otherwise one is created from the LetterID.
 func (rs *RabbitService) PublishLetter(letter *Letter) error {
 
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
 	if rs.shutdown {
 		return errors.New("unable to publish as service shutdown triggered")
 	}
@@ -266,6 +523,11 @@ func (rs *RabbitService) PublishLetter(letter *Letter) error {
 	atomic.AddUint64(&rs.letterCount, 1)
 
 	letter.LetterID = currentCount
+	if letter.MessageID == "" {
+		letter.MessageID = rs.newMessageID(currentCount)
+	}
+
+	rs.addPublishHeaders(letter)
 
 	rs.Publisher.Publish(letter, false)
 
@@ -273,9 +535,35 @@ func (rs *RabbitService) PublishLetter(letter *Letter) error {
 }
 
 // QueueLetter wraps around AutoPublisher to simply QueueLetter.
+// A MessageID already set on the letter is kept, otherwise one is created from the LetterID.
 // Error indicates message was not queued.
 func (rs *RabbitService) QueueLetter(letter *Letter) error {
 
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	return rs.queueLetter(rs.Publisher, letter)
+}
+
+// QueueLetterWith is QueueLetter with the named Publisher added with AddPublisher, the letter waits in that
+// Publisher's queue only.
+func (rs *RabbitService) QueueLetterWith(publisherName string, letter *Letter) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	publisher, err := rs.PublisherByName(publisherName)
+	if err != nil {
+		return err
+	}
+
+	return rs.queueLetter(publisher, letter)
+}
+
+func (rs *RabbitService) queueLetter(publisher *Publisher, letter *Letter) error {
+
 	if rs.shutdown {
 		return errors.New("unable to queue letter as service shutdown triggered")
 	}
@@ -284,16 +572,70 @@ func (rs *RabbitService) QueueLetter(letter *Letter) error {
 	atomic.AddUint64(&rs.letterCount, 1)
 
 	letter.LetterID = currentCount
+	if letter.MessageID == "" {
+		letter.MessageID = rs.newMessageID(currentCount)
+	}
+
+	rs.addPublishHeaders(letter)
 
-	if ok := rs.Publisher.QueueLetter(letter); !ok {
+	if ok := publisher.QueueLetter(letter); !ok {
 		return errors.New("unable to queue letter... most likely cause is autopublisher chan was shut")
 	}
 
 	return nil
 }
 
+// AddPublisher creates a Publisher named name, with its own AutoPublish queue and parallel publishes from the config,
+// on the RabbitService's ConnectionPool. Isolates a workload (ex. a bulk event stream) so its backlog doesn't delay
+// the letters of other Publishers. Publish with it with PublishWith and QueueLetterWith, its receipts are processed
+// like the default Publisher's and it is shut down with the RabbitService.
+func (rs *RabbitService) AddPublisher(name string, config *PublisherConfig) (*Publisher, error) {
+
+	if err := rs.initialized(); err != nil {
+		return nil, err
+	}
+
+	if name == "" || config == nil {
+		return nil, errors.New("can't add a publisher with an empty name or a nil config")
+	}
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if _, ok := rs.publishers[name]; ok {
+		return nil, fmt.Errorf("publisher %q already exists", name)
+	}
+
+	seasoning := *rs.Config
+	seasoning.PublisherConfig = config
+
+	publisher := NewPublisherFromConfig(&seasoning, rs.ConnectionPool)
+	publisher.SetMetricsRecorder(rs.Publisher.metrics)
+	rs.publishers[name] = publisher
+
+	rs.monitorPublishReceipts(publisher)
+	publisher.StartAutoPublishing()
+
+	return publisher, nil
+}
+
+// PublisherByName gets a Publisher added with AddPublisher.
+func (rs *RabbitService) PublisherByName(name string) (*Publisher, error) {
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if publisher, ok := rs.publishers[name]; ok {
+		return publisher, nil
+	}
+
+	return nil, fmt.Errorf("publisher %q was not found", name)
+}
+
 // GetConsumer allows you to get the individual consumers stored in memory.
 func (rs *RabbitService) GetConsumer(consumerName string) (*Consumer, error) {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
 
 	if consumer, ok := rs.consumers[consumerName]; ok {
 		return consumer, nil
@@ -304,6 +646,8 @@ func (rs *RabbitService) GetConsumer(consumerName string) (*Consumer, error) {
 
 // GetConsumerConfig allows you to get the individual consumers' config stored in memory.
 func (rs *RabbitService) GetConsumerConfig(consumerName string) (*ConsumerConfig, error) {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
 
 	if consumer, ok := rs.consumers[consumerName]; ok {
 		return consumer.Config, nil
@@ -312,22 +656,347 @@ func (rs *RabbitService) GetConsumerConfig(consumerName string) (*ConsumerConfig
 	return nil, fmt.Errorf("consumer %q was not found", consumerName)
 }
 
+// RestartConsumer bounces the named Consumer without restarting the RabbitService, ex. when its channel is in a bad
+// state. The Consumer is replaced by a new Consumer from its config that keeps its metrics, middleware and filter,
+// whose errors are collected with the others. A started Consumer is stopped and the new Consumer started the same
+// way, with StartConsuming or with the action of StartConsumingWithAction, a stopped Consumer is replaced but not
+// started. A Consumer in ProcessWithHandler or ConsumeBatch can't be restarted, their loop is the caller's: cancel
+// its context and call it again on the new Consumer. Get the new Consumer with GetConsumer, its ReceivedMessages
+// aren't the stopped Consumer's.
+func (rs *RabbitService) RestartConsumer(consumerName string) error {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	stopped, ok := rs.consumers[consumerName]
+	if !ok {
+		return fmt.Errorf("consumer %q was not found", consumerName)
+	}
+
+	stopped.conLock.Lock()
+	started := stopped.started
+	processing := stopped.processing
+	action := stopped.action
+	stopped.conLock.Unlock()
+
+	if processing {
+		return fmt.Errorf("consumer %q is processing with a handler and can't be restarted", consumerName)
+	}
+
+	if started {
+		if err := stopped.StopConsuming(true, true); err != nil {
+			return err
+		}
+	}
+
+	consumer := rs.newConsumer(stopped.Config)
+
+	stopped.conLock.Lock()
+	consumer.metrics = stopped.metrics
+	consumer.middleware = stopped.middleware
+	consumer.filter = stopped.filter
+	stopped.conLock.Unlock()
+
+	rs.consumers[consumerName] = consumer
+
+	if started && action != nil {
+		consumer.StartConsumingWithAction(action)
+	} else if started {
+		consumer.StartConsuming()
+	}
+
+	return nil
+}
+
+// Consume starts the named consumer and decodes every ReceivedMessage into a new payload from newPayload, applying
+// the configured decryption and decompression. Set wrappedPayload when messages were published with wrapPayload.
+// Decode failures are reported on the DecodedMessage so the original message can still be acked or nacked.
+// The DecodedMessage channel is closed once the consumer stops, so it can be ranged over.
+func (rs *RabbitService) Consume(
+	consumerName string,
+	newPayload func() interface{},
+	wrappedPayload bool) (<-chan *DecodedMessage, error) {
+
+	if newPayload == nil {
+		return nil, errors.New("can't consume without a payload factory")
+	}
+
+	consumer, err := rs.GetConsumer(consumerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !consumer.Enabled {
+		return nil, fmt.Errorf("can't consume with disabled consumer %q", consumerName)
+	}
+
+	messages := consumer.Messages() // before starting, so no message goes to ReceivedMessages
+	consumer.StartConsuming()
+
+	decodedMessages := make(chan *DecodedMessage, 1000)
+	go rs.decodeMessages(messages, decodedMessages, newPayload, wrappedPayload)
+
+	return decodedMessages, nil
+}
+
+// decodeMessages decodes the messages until the consumer stops and closes its Messages, then closes decodedMessages.
+func (rs *RabbitService) decodeMessages(
+	messages <-chan *ReceivedMessage,
+	decodedMessages chan<- *DecodedMessage,
+	newPayload func() interface{},
+	wrappedPayload bool) {
+
+	defer close(decodedMessages)
+
+	for msg := range messages {
+		decodedMessages <- rs.decodeMessage(msg, newPayload(), wrappedPayload)
+	}
+}
+
+func (rs *RabbitService) decodeMessage(msg *ReceivedMessage, payload interface{}, wrappedPayload bool) *DecodedMessage {
+
+	decodedMessage := &DecodedMessage{
+		Payload: payload,
+		Message: msg,
+	}
+
+	if wrappedPayload {
+		wrappedBody, err := ReadWrappedPayload(msg.Body, payload, rs.Config.CompressionConfig, rs.encryptionConfig())
+		if err != nil {
+			decodedMessage.Error = err
+			return decodedMessage
+		}
+
+		decodedMessage.Metadata = wrappedBody.LetterMetadata
+		return decodedMessage
+	}
+
+	buffer := bytes.NewBuffer(msg.Body)
+	if err := ReadPayload(buffer, rs.Config.CompressionConfig, rs.encryptionConfig()); err != nil {
+		decodedMessage.Error = err
+		return decodedMessage
+	}
+
+	var json = jsoniter.ConfigFastest
+	decodedMessage.Error = json.Unmarshal(buffer.Bytes(), payload)
+
+	return decodedMessage
+}
+
+// SetMetricsRecorder sets the MetricsRecorder on the ConnectionPool, Publisher, and all Consumers.
+// Set before publishing or consuming.
+func (rs *RabbitService) SetMetricsRecorder(metrics MetricsRecorder) {
+
+	rs.ConnectionPool.SetMetricsRecorder(metrics)
+	rs.Publisher.SetMetricsRecorder(metrics)
+
+	rs.serviceLock.Lock()
+	for _, publisher := range rs.publishers {
+		publisher.SetMetricsRecorder(metrics)
+	}
+
+	for _, consumer := range rs.consumers {
+		consumer.SetMetricsRecorder(metrics)
+	}
+	rs.serviceLock.Unlock()
+}
+
+// Healthy indicates the ConnectionPool is currently connected to the broker.
+func (rs *RabbitService) Healthy() bool {
+	return rs.ConnectionPool.Healthy()
+}
+
+// HealthReport reports the health of the ConnectionPool.
+func (rs *RabbitService) HealthReport() *HealthReport {
+	return rs.ConnectionPool.HealthReport()
+}
+
+// Ping verifies the broker is responsive with a channel open and close round trip on a live connection, returning the
+// ctx error when ctx is done first. Prefer it to Healthy for health checks, a connection can look open while the broker
+// doesn't respond.
+func (rs *RabbitService) Ping(ctx context.Context) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
+
+	pinged := make(chan error, 1)
+	go func() { pinged <- rs.ConnectionPool.Ping() }()
+
+	select {
+	case err := <-pinged:
+		if err != nil {
+			return fmt.Errorf("ping failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ping failed: %w", ctx.Err())
+	}
+}
+
+// WaitForReady blocks until the ConnectionPool has a live connection and AutoPublish is running, or until ctx is done
+// and returns the ctx error. A lazy ConnectionPool is connected instead of waiting for the first use, the connecting
+// carries on after ctx is done.
+func (rs *RabbitService) WaitForReady(ctx context.Context) error {
+
+	if err := rs.initialized(); err != nil {
+		return err
+	}
+
+	go rs.ConnectionPool.connectLazy()
+
+	ticker := time.NewTicker(time.Duration(time.Millisecond * 10))
+	defer ticker.Stop()
+
+	for {
+		if rs.ConnectionPool.HealthReport().LiveConnections > 0 && rs.Publisher.autoPublishing() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("rabbitservice isn't ready: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServiceStats is a snapshot of the RabbitService's ConnectionPool, Publisher, and Consumers.
+type ServiceStats struct {
+	Pool              *PoolMetrics
+	PublishQueueDepth int           // letters waiting for AutoPublish
+	PublishQueueTime  time.Duration // how long the last letter picked up by AutoPublish waited
+	Consumers         int
+	Circuits          []CircuitStats // circuit breakers of exchanges and routing keys with failing publishes
+}
+
+// Stats gets a snapshot of the RabbitService's ConnectionPool, Publisher, and Consumers.
+func (rs *RabbitService) Stats() *ServiceStats {
+
+	rs.serviceLock.Lock()
+	consumers := len(rs.consumers)
+	rs.serviceLock.Unlock()
+
+	return &ServiceStats{
+		Pool:              rs.ConnectionPool.Metrics(),
+		PublishQueueDepth: rs.Publisher.QueueDepth(),
+		PublishQueueTime:  rs.Publisher.QueueTime(),
+		Consumers:         consumers,
+		Circuits:          rs.Publisher.CircuitStats(),
+	}
+}
+
+// RotateEncryptionKey makes the key created from passphrase and salt the primary encryption key.
+// Previous keys are kept for decrypting payloads that were encrypted before the rotation.
+func (rs *RabbitService) RotateEncryptionKey(passphrase, salt string) error {
+
+	if passphrase == "" || salt == "" {
+		return errors.New("can't rotate encryption key with an empty passphrase or salt")
+	}
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	current := rs.Config.EncryptionConfig
+	keyLength, err := current.hashKeyLength()
+	if err != nil {
+		return err
+	}
+
+	rotated := *current
+	if err := rotated.validateArgon2(); err != nil {
+		return err
+	}
+
+	rotated.Hashkey = GetHashWithArgon(
+		passphrase,
+		salt,
+		rotated.TimeConsideration,
+		rotated.MemoryMultiplier,
+		rotated.Threads,
+		keyLength)
+	rotated.KeyID = GetKeyID(rotated.Hashkey)
+
+	rotated.DecryptionKeys = make(map[string][]byte, len(current.DecryptionKeys)+1)
+	for keyID, hashkey := range current.DecryptionKeys {
+		rotated.DecryptionKeys[keyID] = hashkey
+	}
+
+	if len(current.Hashkey) > 0 {
+		rotated.DecryptionKeys[current.KeyID] = current.Hashkey
+	}
+
+	// Swap in a new config so payloads in flight keep a consistent view of the keys. The default Publisher shares the
+	// service's Config, added Publishers have a copy.
+	rs.Publisher.setEncryptionConfig(&rotated)
+	for _, publisher := range rs.publishers {
+		publisher.setEncryptionConfig(&rotated)
+	}
+	rs.encryptionConfigured = true
+
+	return nil
+}
+
+// encryptionConfig returns the current EncryptionConfig, read under the lock since RotateEncryptionKey swaps it.
+func (rs *RabbitService) encryptionConfig() *EncryptionConfig {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	return rs.Config.EncryptionConfig
+}
+
+// Events yields the ConnectionPool state transitions, see ConnectionPool.Notify.
+func (rs *RabbitService) Events() <-chan PoolEvent {
+	return rs.ConnectionPool.Notify()
+}
+
 // CentralErr yields all the internal errs for sub-processes.
 func (rs *RabbitService) CentralErr() <-chan error {
 	return rs.centralErr
 }
 
-// Shutdown stops the service and shuts down the ChannelPool.
+// Shutdown flushes the Publisher, stops the service, and shuts down the ChannelPool.
 func (rs *RabbitService) Shutdown(stopConsumers bool) {
 
+	rs.logger.Infof("rabbitservice shutting down, flushing publisher")
+	rs.stopCooldownRetries()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownFlushTimeout)
+	if _, err := rs.Publisher.FlushWithContext(ctx); err != nil {
+		rs.logger.Warnf("publisher failed to flush before shutdown: %v", err)
+		rs.centralErr <- fmt.Errorf("publisher failed to flush before shutdown: %w", err)
+	}
+
+	rs.serviceLock.Lock()
+	publishers := make(map[string]*Publisher, len(rs.publishers))
+	for name, publisher := range rs.publishers {
+		publishers[name] = publisher
+	}
+	rs.serviceLock.Unlock()
+
+	for name, publisher := range publishers {
+		if _, err := publisher.FlushWithContext(ctx); err != nil {
+			rs.logger.Warnf("publisher %q failed to flush before shutdown: %v", name, err)
+			rs.centralErr <- fmt.Errorf("publisher %q failed to flush before shutdown: %w", name, err)
+		}
+	}
+	cancel()
+
 	rs.Publisher.Shutdown(false)
+	for _, publisher := range publishers {
+		publisher.Shutdown(false)
+	}
 
-	time.Sleep(time.Second)
 	rs.shutdownSignal <- true
 	time.Sleep(time.Second)
 
 	if stopConsumers {
-		for _, consumer := range rs.consumers {
+		consumers := rs.consumerList()
+		rs.logger.Infof("rabbitservice stopping %d consumers", len(consumers))
+		for _, consumer := range consumers {
 			err := consumer.StopConsuming(true, true)
 			if err != nil {
 				rs.centralErr <- err
@@ -336,6 +1005,7 @@ func (rs *RabbitService) Shutdown(stopConsumers bool) {
 	}
 
 	rs.ConnectionPool.Shutdown()
+	rs.logger.Infof("rabbitservice shut down")
 }
 
 func (rs *RabbitService) monitorForShutdown() {
@@ -358,7 +1028,8 @@ func (rs *RabbitService) collectConsumerErrors() {
 MonitorLoop:
 	for {
 
-		for _, consumer := range rs.consumers {
+		// A restarted Consumer is collected from on the next pass.
+		for _, consumer := range rs.consumerList() {
 		IndividualConsumerLoop:
 			for {
 				if rs.shutdown {
@@ -378,7 +1049,18 @@ MonitorLoop:
 	}
 }
 
-func (rs *RabbitService) invokeProcessPublishReceipts(processReceipts func(*PublishReceipt)) {
+// monitorPublishReceipts processes the publisher's receipts with the processPublishReceipts of NewRabbitService, or
+// retries publishing all failures by default.
+func (rs *RabbitService) monitorPublishReceipts(publisher *Publisher) {
+
+	if rs.processReceipts != nil {
+		go rs.invokeProcessPublishReceipts(publisher, rs.processReceipts)
+	} else {
+		go rs.processPublishReceipts(publisher)
+	}
+}
+
+func (rs *RabbitService) invokeProcessPublishReceipts(publisher *Publisher, processReceipts func(*PublishReceipt)) {
 
 ProcessLoop:
 	for {
@@ -387,7 +1069,7 @@ ProcessLoop:
 		}
 
 		select {
-		case receipt := <-rs.Publisher.PublishReceipts():
+		case receipt := <-publisher.PublishReceipts():
 			processReceipts(receipt)
 		default:
 			time.Sleep(rs.monitorSleepInterval)
@@ -396,7 +1078,7 @@ ProcessLoop:
 	}
 }
 
-func (rs *RabbitService) processPublishReceipts() {
+func (rs *RabbitService) processPublishReceipts(publisher *Publisher) {
 
 ProcessLoop:
 	for {
@@ -405,11 +1087,14 @@ ProcessLoop:
 		}
 
 		select {
-		case receipt := <-rs.Publisher.PublishReceipts():
+		case receipt := <-publisher.PublishReceipts():
 			if !receipt.Success {
-				if receipt.FailedLetter != nil {
+				if receipt.FailedLetter != nil && errors.Is(receipt.Error, ErrCircuitOpen) {
+					rs.retryAfterCircuitCooldown(publisher, receipt)
+				} else if receipt.FailedLetter != nil {
+					rs.logger.Warnf("failed to publish letter %d, retrying: %v", receipt.LetterID, receipt.Error)
 					rs.centralErr <- fmt.Errorf("failed to publish letter %d... retrying", receipt.LetterID)
-					if ok := rs.Publisher.QueueLetter(receipt.FailedLetter); !ok {
+					if ok := publisher.requeueLetter(receipt.FailedLetter); !ok {
 						rs.centralErr <- fmt.Errorf("failed to publish a letter %d and autopublisher has been shutdown", receipt.LetterID)
 					}
 				} else {
@@ -424,6 +1109,73 @@ ProcessLoop:
 	}
 }
 
+// retryAfterCircuitCooldown requeues a letter short-circuited by the Publisher's circuit breaker once the circuit may
+// be half open, instead of spinning on the open circuit.
+func (rs *RabbitService) retryAfterCircuitCooldown(publisher *Publisher, receipt *PublishReceipt) {
+
+	rs.logger.Debugf("letter %d short-circuited, retrying in %s: %v", receipt.LetterID, publisher.breaker.cooldown, receipt.Error)
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	var retry *time.Timer
+	retry = time.AfterFunc(publisher.breaker.cooldown, func() {
+		rs.serviceLock.Lock()
+		_, pending := rs.cooldownRetries[retry]
+		delete(rs.cooldownRetries, retry)
+		rs.serviceLock.Unlock()
+
+		// Stopped by Shutdown as it fired.
+		if !pending {
+			return
+		}
+
+		if ok := publisher.requeueLetter(receipt.FailedLetter); !ok {
+			rs.centralErr <- fmt.Errorf("failed to publish a letter %d and autopublisher has been shutdown", receipt.LetterID)
+		}
+	})
+	rs.cooldownRetries[retry] = struct{}{}
+}
+
+// stopCooldownRetries stops the pending retries of short-circuited letters, their letters are dropped.
+func (rs *RabbitService) stopCooldownRetries() {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if len(rs.cooldownRetries) > 0 {
+		rs.logger.Warnf("rabbitservice dropping %d short-circuited letters awaiting a retry", len(rs.cooldownRetries))
+	}
+
+	for retry := range rs.cooldownRetries {
+		retry.Stop()
+		delete(rs.cooldownRetries, retry)
+	}
+}
+
+// ProcessReturns starts invoking processReturn on every message returned (basic.return) by the server until shutdown.
+func (rs *RabbitService) ProcessReturns(processReturn func(*ReturnMessage)) {
+
+	go rs.invokeProcessReturns(processReturn)
+}
+
+func (rs *RabbitService) invokeProcessReturns(processReturn func(*ReturnMessage)) {
+
+ProcessLoop:
+	for {
+		if rs.shutdown {
+			break ProcessLoop // Prevent leaking goroutine
+		}
+
+		select {
+		case returnMessage := <-rs.Publisher.Returns():
+			processReturn(returnMessage)
+		default:
+			time.Sleep(rs.monitorSleepInterval)
+			break
+		}
+	}
+}
+
 func (rs *RabbitService) invokeProcessError(processError func(error)) {
 
 ProcessLoop:
@@ -451,7 +1203,11 @@ ProcessLoop:
 		}
 		select {
 		case err := <-rs.centralErr:
-			fmt.Printf("TCR Central Err: %s\r\n", err)
+			if rs.Config.Logger != nil {
+				rs.logger.Errorf("TCR Central Err: %s", err)
+			} else {
+				fmt.Printf("TCR Central Err: %s\r\n", err)
+			}
 		default:
 			time.Sleep(rs.monitorSleepInterval)
 			break
@@ -459,6 +1215,95 @@ ProcessLoop:
 	}
 }
 
+// WithHeader adds a header to every message published by the RabbitService, such as a tenant-id.
+// Headers passed to a publish, or set on a Letter's Envelope, take precedence over these.
+func (rs *RabbitService) WithHeader(key string, value interface{}) *RabbitService {
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	// copy on write, published Envelopes may still reference the previous headers
+	headers := make(amqp.Table, len(rs.headers)+1)
+	for k, v := range rs.headers {
+		headers[k] = v
+	}
+	headers[key] = value
+	rs.headers = headers
+
+	return rs
+}
+
+// publishHeaders combines the RabbitService headers with the headers for a single publish.
+func (rs *RabbitService) publishHeaders(headers amqp.Table) amqp.Table {
+
+	rs.serviceLock.Lock()
+	serviceHeaders := rs.headers
+	rs.serviceLock.Unlock()
+
+	if len(serviceHeaders) == 0 {
+		return headers
+	}
+
+	combined := make(amqp.Table, len(serviceHeaders)+len(headers))
+	for k, v := range serviceHeaders {
+		combined[k] = v
+	}
+	for k, v := range headers {
+		combined[k] = v
+	}
+
+	return combined
+}
+
+// createPayload creates the JSON payload, optionally wrapped, with the configured compression and encryption.
+func (rs *RabbitService) createPayload(input interface{}, letterID uint64, metadata string, wrapPayload bool) ([]byte, payloadPipeline, error) {
+
+	encryptionConfig := rs.encryptionConfig()
+	if wrapPayload {
+		return createWrappedPayload(input, letterID, metadata, rs.Config.JSONConfig, rs.Config.CompressionConfig, encryptionConfig)
+	}
+
+	return createPayload(input, rs.Config.JSONConfig, rs.Config.CompressionConfig, encryptionConfig)
+}
+
+// addPublishHeaders gives the letter a copy of its Envelope with the RabbitService headers combined into its
+// headers, the caller's Envelope and headers are left untouched.
+func (rs *RabbitService) addPublishHeaders(letter *Letter) {
+
+	if letter.Envelope == nil {
+		return
+	}
+
+	envelope := *letter.Envelope
+	envelope.Headers = rs.publishHeaders(envelope.Headers)
+	letter.Envelope = &envelope
+}
+
+// payloadHeaders combines the publish headers with the PayloadPipelineHeader of the payload, when anything was applied.
+func (rs *RabbitService) payloadHeaders(headers amqp.Table, pipeline payloadPipeline) amqp.Table {
+
+	headers = rs.publishHeaders(headers)
+
+	applied := pipeline.String()
+	if applied == "" {
+		return headers
+	}
+
+	combined := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		combined[k] = v
+	}
+	combined[PayloadPipelineHeader] = applied
+
+	return combined
+}
+
+// newMessageID creates a MessageID for the LetterID that stays the same when the letter is retried
+// and is unique across RabbitService instances.
+func (rs *RabbitService) newMessageID(letterID uint64) string {
+	return fmt.Sprintf("%s-%d", rs.messageIDPrefix, letterID)
+}
+
 // Generate new letter id based on letterCount
 func (rs *RabbitService) GetNewLetterID() uint64 {
 	currentCount := atomic.LoadUint64(&rs.letterCount)
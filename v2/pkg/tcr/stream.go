@@ -0,0 +1,162 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// StreamIDHeader is the header identifying the stream a PublishStream chunk belongs to.
+	StreamIDHeader = "x-tcr-stream-id"
+
+	// StreamChunkHeader is the header with the index (starting at 0) of a PublishStream chunk.
+	StreamChunkHeader = "x-tcr-stream-chunk"
+
+	// StreamLastHeader is the header marking the last chunk of a PublishStream.
+	StreamLastHeader = "x-tcr-stream-last"
+
+	// defaultStreamChunkSize keeps chunks far below RabbitMQ's max_message_size (128 MiB by default).
+	defaultStreamChunkSize = 1024 * 1024
+)
+
+// PublishStream publishes the reader as a sequence of chunk messages to the envelope's address, so a large payload
+// is never held in memory at once. AMQP messages are published whole and RabbitMQ refuses messages larger than
+// its max_message_size (128 MiB by default), so the stream is split into chunks of StreamChunkSize bytes (1 MiB
+// by default). Each chunk is compressed and encrypted on its own, as configured in the RabbitSeasoning, and carries
+// the StreamIDHeader, StreamChunkHeader and StreamLastHeader headers for the consumer to reassemble the stream in
+// order (use DecryptPayload and DecompressPayload per chunk). Every chunk is confirmed before the next is read, on a
+// dedicated transient channel. Stops between chunks when ctx is done and returns the ctx error.
+func (pub *Publisher) PublishStream(ctx context.Context, envelope *Envelope, r io.Reader) error {
+
+	if envelope == nil || r == nil {
+		return errors.New("can't publish a stream with a nil envelope or reader")
+	}
+
+	if err := pub.breaker.allow(envelope.Exchange, envelope.RoutingKey); err != nil {
+		return err
+	}
+
+	var compression *CompressionConfig
+	var encryption *EncryptionConfig
+	chunkSize := defaultStreamChunkSize
+	if pub.Config != nil {
+		compression = pub.Config.CompressionConfig
+		encryption = pub.encryptionConfig()
+
+		if pub.Config.PublisherConfig != nil && pub.Config.PublisherConfig.StreamChunkSize > 0 {
+			chunkSize = pub.Config.PublisherConfig.StreamChunkSize
+		}
+	}
+
+	channel := pub.ConnectionPool.GetTransientChannel(true)
+	defer func() {
+		defer func() {
+			_ = recover()
+		}()
+		channel.Close()
+	}()
+
+	confirmations := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	streamID := RandomString(16)
+
+	// Read one chunk ahead to know which chunk is the last.
+	chunk := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+
+	n, err := io.ReadFull(r, chunk)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	for index := 0; ; index++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var nextN int
+		last := n < chunkSize // a short read is the end of the stream
+		if !last {
+			nextN, err = io.ReadFull(r, next)
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return err
+			}
+
+			last = nextN == 0
+		}
+
+		err := pub.publishStreamChunk(channel, confirmations, envelope, streamID, index, last, chunk[:n], compression, encryption)
+		pub.breaker.record(envelope.Exchange, envelope.RoutingKey, err)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+
+		chunk, next = next, chunk
+		n = nextN
+	}
+}
+
+// publishStreamChunk publishes a single stream chunk and waits for its confirmation.
+func (pub *Publisher) publishStreamChunk(
+	channel *amqp.Channel,
+	confirmations <-chan amqp.Confirmation,
+	envelope *Envelope,
+	streamID string,
+	index int,
+	last bool,
+	data []byte,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) error {
+
+	body, err := modifyPayload(data, compression, encryption)
+	if err != nil {
+		return err
+	}
+
+	headers := make(amqp.Table, len(envelope.Headers)+3)
+	for key, value := range envelope.Headers {
+		headers[key] = value
+	}
+
+	headers[StreamIDHeader] = streamID
+	headers[StreamChunkHeader] = int32(index)
+	headers[StreamLastHeader] = last
+
+	chunkEnvelope := *envelope
+	chunkEnvelope.Headers = headers
+
+	letter := &Letter{
+		MessageID: fmt.Sprintf("%s-%d", streamID, index),
+		Body:      body,
+		Envelope:  &chunkEnvelope,
+	}
+
+	err = channel.Publish(envelope.Exchange, envelope.RoutingKey, envelope.Mandatory, envelope.Immediate, newPublishing(letter, pub.appID))
+	if err != nil {
+		return fmt.Errorf("stream %s chunk %d publish failed: %w", streamID, index, err)
+	}
+
+	timeout := pub.publishTimeOutDuration
+	if timeout == 0 {
+		timeout = defaultPublishConfirmationTimeout
+	}
+
+	select {
+	case confirmation, ok := <-confirmations:
+		if !ok || !confirmation.Ack {
+			return fmt.Errorf("stream %s chunk %d was not confirmed", streamID, index)
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("stream %s chunk %d confirmation wasn't received in a timely manner", streamID, index)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package tcr
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 )
 
@@ -28,3 +29,45 @@ func CreateTLSConfig(pemLocation string, localLocation string) (*tls.Config, err
 	cfg.Certificates = append(cfg.Certificates, cert)
 	return cfg, nil
 }
+
+// NewTLSConfig creates the tls.Config for connections from the TLSConfig. The ClientConfig is returned as is when set.
+// Otherwise the PEMCertLocation CA bundle is trusted (the system roots when empty) and the client certificate for mutual
+// TLS is loaded from CertLocation and KeyLocation, or the combined LocalCertLocation, when set.
+func NewTLSConfig(config *TLSConfig) (*tls.Config, error) {
+
+	if config.ClientConfig != nil {
+		return config.ClientConfig, nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if config.PEMCertLocation != "" {
+		ca, err := ioutil.ReadFile(config.PEMCertLocation)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %q", config.PEMCertLocation)
+		}
+	}
+
+	certLocation, keyLocation := config.CertLocation, config.KeyLocation
+	if certLocation == "" {
+		certLocation, keyLocation = config.LocalCertLocation, config.LocalCertLocation
+	} else if keyLocation == "" {
+		keyLocation = certLocation
+	}
+
+	if certLocation != "" {
+		cert, err := tls.LoadX509KeyPair(certLocation, keyLocation)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	return cfg, nil
+}
@@ -2,6 +2,8 @@ package tcr
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/streadway/amqp"
 )
@@ -12,8 +14,55 @@ const (
 
 	// QueueTypeClassic indicates a queue of type classic.
 	QueueTypeClassic = "classic"
+
+	// QueueMaxPriorityArg is the queue argument that makes a priority queue.
+	QueueMaxPriorityArg = "x-max-priority"
+
+	// QueueTypeArg is the queue argument that sets the queue type.
+	QueueTypeArg = "x-queue-type"
+
+	// QueueSingleActiveConsumerArg is the queue argument that delivers to only one of the queue's consumers at a time.
+	QueueSingleActiveConsumerArg = "x-single-active-consumer"
+
+	// ExchangeAlternateExchangeArg is the exchange argument that routes its unroutable messages to another exchange.
+	ExchangeAlternateExchangeArg = "alternate-exchange"
+
+	// ExchangeTypeDelayed is the exchange type of the rabbitmq_delayed_message_exchange plugin, it holds each message
+	// for its DelayHeader milliseconds before routing it.
+	ExchangeTypeDelayed = "x-delayed-message"
+
+	// ExchangeDelayedTypeArg is the argument of an x-delayed-message exchange with the exchange type it routes like.
+	ExchangeDelayedTypeArg = "x-delayed-type"
 )
 
+// ErrTopologyMismatch indicates an existing Queue or Exchange was declared with different properties or arguments.
+var ErrTopologyMismatch = errors.New("topology does not match the existing declaration")
+
+// ErrInvalidQuorumQueue indicates a quorum Queue was declared with properties quorum queues don't support.
+var ErrInvalidQuorumQueue = errors.New("quorum queues must be durable and can't be exclusive, auto delete or priority queues")
+
+// BindingError is the failed routing keys, and their errors, of a BindQueueMany call.
+type BindingError struct {
+	QueueName    string
+	ExchangeName string
+	RoutingKeys  []string
+	Errors       []error
+}
+
+func (be *BindingError) Error() string {
+
+	failures := make([]string, len(be.RoutingKeys))
+	for i, routingKey := range be.RoutingKeys {
+		failures[i] = fmt.Sprintf("%q: %s", routingKey, be.Errors[i])
+	}
+
+	return fmt.Sprintf(
+		"unable to bind queue %s to exchange %s with routing keys [%s]",
+		be.QueueName,
+		be.ExchangeName,
+		strings.Join(failures, "; "))
+}
+
 // Topologer allows you to build RabbitMQ topology backed by a ConnectionPool.
 type Topologer struct {
 	ConnectionPool *ConnectionPool
@@ -141,6 +190,8 @@ func (top *Topologer) CreateExchange(
 // CreateExchangeFromConfig builds an Exchange toplogy from a config Exchange element.
 func (top *Topologer) CreateExchangeFromConfig(exchange *Exchange) error {
 
+	exchange = applyExchangeOptions(exchange)
+
 	channel := top.ConnectionPool.GetTransientChannel(false)
 	defer channel.Close()
 
@@ -204,7 +255,27 @@ func (top *Topologer) ExchangeUnbind(exchangeName, routingKey, parentExchangeNam
 		amqp.Table(args))
 }
 
+// BindExchange binds the destination Exchange to the source Exchange, messages published to the source exchange that
+// match the routing key are routed through the destination exchange as well.
+func (top *Topologer) BindExchange(destination, source, routingKey string, args amqp.Table) error {
+
+	return top.ExchangeBind(&ExchangeBinding{
+		ExchangeName:       destination,
+		ParentExchangeName: source,
+		RoutingKey:         routingKey,
+		Args:               args,
+	})
+}
+
+// UnbindExchange removes the binding of the destination Exchange to the source Exchange.
+func (top *Topologer) UnbindExchange(destination, source, routingKey string, args amqp.Table) error {
+
+	return top.ExchangeUnbind(destination, routingKey, source, false, args)
+}
+
 // CreateQueue builds a Queue topology.
+// Quorum queues (x-queue-type of quorum in args) must be durable and can't be exclusive, auto delete or use
+// x-max-priority, those declarations return an error wrapping ErrInvalidQuorumQueue without reaching the server.
 func (top *Topologer) CreateQueue(
 	queueName string,
 	passiveDeclare bool,
@@ -214,6 +285,10 @@ func (top *Topologer) CreateQueue(
 	noWait bool,
 	args map[string]interface{}) error {
 
+	if err := validateQueue(queueName, durable, autoDelete, exclusive, amqp.Table(args)); err != nil {
+		return err
+	}
+
 	channel := top.ConnectionPool.GetTransientChannel(false)
 	defer channel.Close()
 
@@ -227,11 +302,85 @@ func (top *Topologer) CreateQueue(
 }
 
 // CreateQueueFromConfig builds a Queue topology from a config Exchange element.
+// A quorum Queue is made durable and non exclusive, a quorum Queue with a MaxPriority returns an error wrapping ErrInvalidQuorumQueue.
 func (top *Topologer) CreateQueueFromConfig(queue *Queue) error {
 
+	applyQueueOptions(queue)
+	if err := validateQueue(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.Args); err != nil {
+		return err
+	}
+
 	channel := top.ConnectionPool.GetTransientChannel(false)
 	defer channel.Close()
 
+	if queue.PassiveDeclare {
+		_, err := channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
+		return err
+	}
+
+	_, err := channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
+	return err
+}
+
+// EnsureQueue declares the Queue when it doesn't exist (created is true) or checks the existing Queue matches it.
+// RabbitMQ doesn't report the arguments of an existing queue, so the check redeclares it on a transient channel and
+// the server refuses when properties or arguments differ. A refusal returns an error wrapping ErrTopologyMismatch.
+func (top *Topologer) EnsureQueue(queue *Queue) (created bool, err error) {
+
+	applyQueueOptions(queue)
+	if err := validateQueue(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.Args); err != nil {
+		return false, err
+	}
+
+	// A failed passive declare closes the channel, each step gets its own.
+	channel := top.ConnectionPool.GetTransientChannel(false)
+	_, err = channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, false, queue.Args)
+	channel.Close()
+
+	exists := err == nil
+	if !exists && !isAmqpErrorCode(err, amqp.NotFound) {
+		return false, err
+	}
+
+	channel = top.ConnectionPool.GetTransientChannel(false)
+	defer channel.Close()
+
+	_, err = channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, false, queue.Args)
+	if isAmqpErrorCode(err, amqp.PreconditionFailed) {
+		return false, fmt.Errorf("queue %s: %w: %s", queue.Name, ErrTopologyMismatch, err.(*amqp.Error).Reason)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !exists, nil
+}
+
+// applyExchangeOptions returns a copy of the Exchange with the type and arguments for the Exchange options, the
+// Exchange and its Args are left unchanged.
+func applyExchangeOptions(exchange *Exchange) *Exchange {
+
+	applied := *exchange
+	applied.Args = make(amqp.Table, len(exchange.Args)+2)
+	for key, value := range exchange.Args {
+		applied.Args[key] = value
+	}
+
+	if exchange.AlternateExchange != "" {
+		applied.Args[ExchangeAlternateExchangeArg] = exchange.AlternateExchange
+	}
+
+	if exchange.DelayedType != "" {
+		applied.Type = ExchangeTypeDelayed
+		applied.Args[ExchangeDelayedTypeArg] = exchange.DelayedType
+	}
+
+	return &applied
+}
+
+// applyQueueOptions sets the properties required by the Queue type and the arguments for the Queue options.
+func applyQueueOptions(queue *Queue) {
+
 	// classic is automatic and supports all classic properties, quorum type does not so this helps keep things functional
 	if queue.Type == QueueTypeQuorum {
 		queue.Exclusive = false
@@ -240,19 +389,48 @@ func (top *Topologer) CreateQueueFromConfig(queue *Queue) error {
 		queue.AutoDelete = false
 
 		if queue.Args == nil {
-			queue.Args = amqp.Table{
-				"x-queue-type": queue.Type,
-			}
+			queue.Args = amqp.Table{}
 		}
+
+		queue.Args[QueueTypeArg] = queue.Type
 	}
 
-	if queue.PassiveDeclare {
-		_, err := channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
-		return err
+	if queue.MaxPriority > 0 {
+		if queue.Args == nil {
+			queue.Args = amqp.Table{}
+		}
+
+		queue.Args[QueueMaxPriorityArg] = int32(queue.MaxPriority)
 	}
 
-	_, err := channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
-	return err
+	if queue.SingleActiveConsumer {
+		if queue.Args == nil {
+			queue.Args = amqp.Table{}
+		}
+
+		queue.Args[QueueSingleActiveConsumerArg] = true
+	}
+}
+
+func isAmqpErrorCode(err error, code int) bool {
+
+	amqpErr, ok := err.(*amqp.Error)
+	return ok && amqpErr.Code == code
+}
+
+// QueueStats gets the ready message count and consumer count of a Queue with a passive declare.
+// The counts are a point-in-time snapshot and change as messages are published and consumed.
+func (top *Topologer) QueueStats(queueName string) (messages int, consumers int, err error) {
+
+	channel := top.ConnectionPool.GetTransientChannel(false)
+	defer channel.Close()
+
+	queue, err := channel.QueueDeclarePassive(queueName, false, false, false, false, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return queue.Messages, queue.Consumers, nil
 }
 
 // QueueDelete removes the queue from the server (and all bindings) and returns messages purged (count).
@@ -278,6 +456,33 @@ func (top *Topologer) QueueBind(queueBinding *QueueBinding) error {
 		queueBinding.Args)
 }
 
+// BindQueueMany binds the Queue to the Exchange once per routing key. Every routing key is attempted, a failed
+// binding closes the channel so each failure moves on to a new transient channel.
+// Failures are returned together as a *BindingError identifying the routing keys.
+func (top *Topologer) BindQueueMany(queueName, exchangeName string, routingKeys []string, args amqp.Table) error {
+
+	bindErr := &BindingError{QueueName: queueName, ExchangeName: exchangeName}
+
+	channel := top.ConnectionPool.GetTransientChannel(false)
+	for _, routingKey := range routingKeys {
+		err := channel.QueueBind(queueName, routingKey, exchangeName, false, args)
+		if err != nil {
+			bindErr.RoutingKeys = append(bindErr.RoutingKeys, routingKey)
+			bindErr.Errors = append(bindErr.Errors, err)
+
+			channel.Close()
+			channel = top.ConnectionPool.GetTransientChannel(false)
+		}
+	}
+	channel.Close()
+
+	if len(bindErr.Errors) > 0 {
+		return bindErr
+	}
+
+	return nil
+}
+
 // PurgeQueues purges each Queue provided.
 func (top *Topologer) PurgeQueues(queueNames []string, noWait bool) (int, error) {
 
@@ -321,3 +526,30 @@ func (top *Topologer) UnbindQueue(queueName, routingKey, exchangeName string, ar
 		exchangeName,
 		amqp.Table(args))
 }
+
+// validateQueue returns an error wrapping ErrInvalidQuorumQueue when a quorum queue is declared with properties
+// the server refuses for quorum queues.
+func validateQueue(queueName string, durable, autoDelete, exclusive bool, args amqp.Table) error {
+
+	if queueType, ok := args[QueueTypeArg].(string); !ok || queueType != QueueTypeQuorum {
+		return nil
+	}
+
+	if !durable {
+		return fmt.Errorf("queue %s: %w: not durable", queueName, ErrInvalidQuorumQueue)
+	}
+
+	if exclusive {
+		return fmt.Errorf("queue %s: %w: exclusive", queueName, ErrInvalidQuorumQueue)
+	}
+
+	if autoDelete {
+		return fmt.Errorf("queue %s: %w: auto delete", queueName, ErrInvalidQuorumQueue)
+	}
+
+	if _, ok := args[QueueMaxPriorityArg]; ok {
+		return fmt.Errorf("queue %s: %w: %s", queueName, ErrInvalidQuorumQueue, QueueMaxPriorityArg)
+	}
+
+	return nil
+}
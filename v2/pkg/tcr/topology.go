@@ -4,26 +4,30 @@ import "github.com/streadway/amqp"
 
 // Exchange allows for you to create Exchange topology.
 type Exchange struct {
-	Name           string     `json:"Name"`
-	Type           string     `json:"Type"` // "direct", "fanout", "topic", "headers"
-	PassiveDeclare bool       `json:"PassiveDeclare"`
-	Durable        bool       `json:"Durable"`
-	AutoDelete     bool       `json:"AutoDelete"`
-	InternalOnly   bool       `json:"InternalOnly"`
-	NoWait         bool       `json:"NoWait"`
-	Args           amqp.Table `json:"Args,omitempty"` // map[string]interface()
+	Name              string     `json:"Name"`
+	Type              string     `json:"Type"` // "direct", "fanout", "topic", "headers"
+	PassiveDeclare    bool       `json:"PassiveDeclare"`
+	Durable           bool       `json:"Durable"`
+	AutoDelete        bool       `json:"AutoDelete"`
+	InternalOnly      bool       `json:"InternalOnly"`
+	NoWait            bool       `json:"NoWait"`
+	AlternateExchange string     `json:"AlternateExchange,omitempty"` // declares alternate-exchange, messages this exchange can't route are published to it instead
+	DelayedType       string     `json:"DelayedType,omitempty"`       // declares an x-delayed-message exchange routing like this type (ex. direct), requires the rabbitmq_delayed_message_exchange plugin
+	Args              amqp.Table `json:"Args,omitempty"`              // map[string]interface()
 }
 
 // Queue allows for you to create Queue topology.
 type Queue struct {
-	Name           string     `json:"Name"`
-	PassiveDeclare bool       `json:"PassiveDeclare"`
-	Durable        bool       `json:"Durable"`
-	AutoDelete     bool       `json:"AutoDelete"`
-	Exclusive      bool       `json:"Exclusive"`
-	NoWait         bool       `json:"NoWait"`
-	Type           string     `json:"Type"`           // classic or quorum, type of quorum disregards exclusive and enables durable properties when building from config
-	Args           amqp.Table `json:"Args,omitempty"` // map[string]interface()
+	Name                 string     `json:"Name"`
+	PassiveDeclare       bool       `json:"PassiveDeclare"`
+	Durable              bool       `json:"Durable"`
+	AutoDelete           bool       `json:"AutoDelete"`
+	Exclusive            bool       `json:"Exclusive"`
+	NoWait               bool       `json:"NoWait"`
+	Type                 string     `json:"Type"`                           // classic or quorum, type of quorum disregards exclusive and auto delete and enables durable properties, MaxPriority is not supported
+	MaxPriority          uint8      `json:"MaxPriority,omitempty"`          // declares a priority queue (x-max-priority) when building from config, 1-255 (1-10 recommended)
+	SingleActiveConsumer bool       `json:"SingleActiveConsumer,omitempty"` // declares x-single-active-consumer, only one consumer receives messages and the next takes over when it disconnects
+	Args                 amqp.Table `json:"Args,omitempty"`                 // map[string]interface()
 }
 
 // QueueBinding allows for you to create Bindings between a Queue and Exchange.
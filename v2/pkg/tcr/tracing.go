@@ -0,0 +1,44 @@
+package tcr
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// TracePropagator allows you to propagate trace context (ex., traceparent/tracestate) through AMQP headers.
+// An OpenTelemetry propagation.TextMapPropagator can be adapted by passing HeadersCarrier(headers) as its carrier.
+// The Publisher injects it only when publishing with a context, see Publisher.SetTracePropagator.
+type TracePropagator interface {
+	Inject(ctx context.Context, headers amqp.Table)
+	Extract(ctx context.Context, headers amqp.Table) context.Context
+}
+
+// HeadersCarrier adapts an amqp.Table to the Get/Set/Keys carrier used by OpenTelemetry propagators.
+type HeadersCarrier amqp.Table
+
+// Get returns the string value of the header key or an empty string.
+func (hc HeadersCarrier) Get(key string) string {
+
+	if value, ok := hc[key].(string); ok {
+		return value
+	}
+
+	return ""
+}
+
+// Set stores the header key and value.
+func (hc HeadersCarrier) Set(key string, value string) {
+	hc[key] = value
+}
+
+// Keys lists the header keys.
+func (hc HeadersCarrier) Keys() []string {
+
+	keys := make([]string, 0, len(hc))
+	for key := range hc {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
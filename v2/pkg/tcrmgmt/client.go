@@ -0,0 +1,154 @@
+package tcrmgmt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	defaultVhost   = "/"
+	defaultTimeout = 10 * time.Second
+)
+
+// Queue is a queue as listed by the management API.
+type Queue struct {
+	Name       string                 `json:"name"`
+	Vhost      string                 `json:"vhost"`
+	Type       string                 `json:"type"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Exclusive  bool                   `json:"exclusive"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	Messages   int                    `json:"messages"`
+	Consumers  int                    `json:"consumers"`
+}
+
+// Exchange is an exchange as listed by the management API.
+type Exchange struct {
+	Name       string                 `json:"name"`
+	Vhost      string                 `json:"vhost"`
+	Type       string                 `json:"type"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Internal   bool                   `json:"internal"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+// Binding is a binding as listed by the management API, the Source is an exchange ("" is the default exchange) and
+// the Destination is a queue or an exchange depending on the DestinationType.
+type Binding struct {
+	Source          string                 `json:"source"`
+	Vhost           string                 `json:"vhost"`
+	Destination     string                 `json:"destination"`
+	DestinationType string                 `json:"destination_type"` // queue or exchange
+	RoutingKey      string                 `json:"routing_key"`
+	Arguments       map[string]interface{} `json:"arguments"`
+}
+
+// Client lists the existing topology of a vhost with the RabbitMQ management HTTP API, AMQP itself can't list
+// queues, exchanges or bindings. Useful to detect drift from a TopologyConfig.
+type Client struct {
+	config     tcr.ManagementConfig
+	httpClient *http.Client
+}
+
+// NewClient creates a Client for the management API in the config.
+func NewClient(config *tcr.ManagementConfig) (*Client, error) {
+
+	if config == nil || config.URL == "" {
+		return nil, errors.New("management api url can't be empty")
+	}
+
+	client := &Client{
+		config:     *config,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+
+	if client.config.Vhost == "" {
+		client.config.Vhost = defaultVhost
+	}
+
+	if client.config.Timeout > 0 {
+		client.httpClient.Timeout = time.Duration(client.config.Timeout) * time.Second
+	}
+
+	return client, nil
+}
+
+// NewClientFromSeasoning creates a Client for the ManagementConfig of the RabbitSeasoning.
+func NewClientFromSeasoning(seasoning *tcr.RabbitSeasoning) (*Client, error) {
+
+	if seasoning == nil {
+		return nil, errors.New("can't create a management client from a nil seasoning")
+	}
+
+	return NewClient(seasoning.ManagementConfig)
+}
+
+// ListQueues lists the queues of the vhost.
+func (c *Client) ListQueues(ctx context.Context) ([]*Queue, error) {
+
+	queues := make([]*Queue, 0)
+	if err := c.get(ctx, "queues", &queues); err != nil {
+		return nil, err
+	}
+
+	return queues, nil
+}
+
+// ListExchanges lists the exchanges of the vhost, including the default ("") and amq.* exchanges.
+func (c *Client) ListExchanges(ctx context.Context) ([]*Exchange, error) {
+
+	exchanges := make([]*Exchange, 0)
+	if err := c.get(ctx, "exchanges", &exchanges); err != nil {
+		return nil, err
+	}
+
+	return exchanges, nil
+}
+
+// ListBindings lists the bindings of the vhost, including the implicit bindings of queues to the default exchange.
+func (c *Client) ListBindings(ctx context.Context) ([]*Binding, error) {
+
+	bindings := make([]*Binding, 0)
+	if err := c.get(ctx, "bindings", &bindings); err != nil {
+		return nil, err
+	}
+
+	return bindings, nil
+}
+
+// get requests /api/{resource}/{vhost} and decodes the JSON response into out.
+func (c *Client) get(ctx context.Context, resource string, out interface{}) error {
+
+	endpoint := fmt.Sprintf("%s/api/%s/%s", strings.TrimSuffix(c.config.URL, "/"), resource, url.PathEscape(c.config.Vhost))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(c.config.Username, c.config.Password)
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("management api list %s failed: %s", resource, response.Status)
+	}
+
+	var json = jsoniter.ConfigFastest
+	return json.NewDecoder(response.Body).Decode(out)
+}
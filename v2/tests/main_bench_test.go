@@ -273,3 +273,38 @@ ConsumeLoop:
 
 	done <- true
 }
+
+// BenchmarkPublishWithConfirmationCached publishes with confirmations on cached channels, which are put in confirm mode once.
+func BenchmarkPublishWithConfirmationCached(b *testing.B) {
+
+	b.ReportAllocs()
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		publisher.PublishWithConfirmation(letter, time.Second)
+		<-publisher.PublishReceipts()
+	}
+
+	BenchCleanup(b)
+}
+
+// BenchmarkPublishWithConfirmationTransient publishes with confirmations on transient channels, which open a channel
+// and select confirm mode for every publish.
+func BenchmarkPublishWithConfirmationTransient(b *testing.B) {
+
+	b.ReportAllocs()
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		publisher.PublishWithConfirmationTransient(letter, time.Second)
+		<-publisher.PublishReceipts()
+	}
+
+	BenchCleanup(b)
+}
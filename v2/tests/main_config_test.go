@@ -1,7 +1,19 @@
 package main_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/stretchr/testify/assert"
@@ -17,3 +29,73 @@ func TestReadConfig(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", config.PoolConfig.URI, "RabbitMQ URI should not be blank.")
 }
+
+func TestNewTLSConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tcrtls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certLocation, keyLocation := writeSelfSignedCert(t, dir)
+
+	// mutual TLS, the self-signed cert is both the CA bundle and the client certificate
+	tlsConfig, err := tcr.NewTLSConfig(&tcr.TLSConfig{
+		PEMCertLocation: certLocation,
+		CertLocation:    certLocation,
+		KeyLocation:     keyLocation,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	// server verification only, against the system roots
+	tlsConfig, err = tcr.NewTLSConfig(&tcr.TLSConfig{InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 0)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+
+	// the CA bundle must contain certificates
+	_, err = tcr.NewTLSConfig(&tcr.TLSConfig{PEMCertLocation: keyLocation})
+	assert.Error(t, err)
+
+	_, err = tcr.NewTLSConfig(&tcr.TLSConfig{CertLocation: certLocation, KeyLocation: filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+
+	clientConfig := &tls.Config{ServerName: "rabbitmq"}
+	tlsConfig, err = tcr.NewTLSConfig(&tcr.TLSConfig{ClientConfig: clientConfig, CertLocation: certLocation})
+	assert.NoError(t, err)
+	assert.Equal(t, clientConfig, tlsConfig)
+}
+
+// writeSelfSignedCert writes a self-signed certificate and its private key to PEM files in dir.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certLocation := filepath.Join(dir, "cert.pem")
+	keyLocation := filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(certLocation, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyLocation, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certLocation, keyLocation
+}
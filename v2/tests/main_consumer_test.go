@@ -1,11 +1,17 @@
 package main_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/fortytw2/leaktest"
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -64,3 +70,1405 @@ func TestConsumerGet(t *testing.T) {
 
 	TestCleanup(t)
 }
+
+func TestConsumerPrefetchOfOne(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	config := *AckableConsumerConfig
+	config.PrefetchCount = 1
+
+	consumer := tcr.NewConsumerFromConfig(&config, ConnectionPool)
+	assert.NotNil(t, consumer)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.Publish(tcr.CreateMockRandomLetter("TcrTestQueue"), true)
+	publisher.Publish(tcr.CreateMockRandomLetter("TcrTestQueue"), true)
+
+	consumer.StartConsuming()
+
+	var firstMessage *tcr.ReceivedMessage
+	select {
+	case firstMessage = <-consumer.ReceivedMessages():
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for first message")
+	}
+
+	// Second message is held by the server until the first is acknowledged.
+	select {
+	case <-consumer.ReceivedMessages():
+		t.Fatal("received second message before acknowledging the first")
+	case <-time.After(time.Second):
+	}
+
+	assert.NoError(t, firstMessage.Acknowledge())
+
+	select {
+	case secondMessage := <-consumer.ReceivedMessages():
+		assert.NoError(t, secondMessage.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for second message")
+	}
+
+	err := consumer.StopConsuming(false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerDeadLettersPoisonMessage(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	// Quorum queues provide the x-delivery-count header for counting redeliveries.
+	err := RabbitService.Topologer.CreateQueueFromConfig(&tcr.Queue{Name: "TcrTestPoisonQueue", Type: tcr.QueueTypeQuorum})
+	assert.NoError(t, err)
+
+	config := *AckableConsumerConfig
+	config.QueueName = "TcrTestPoisonQueue"
+	config.MaxRedeliveries = 2
+
+	consumer := tcr.NewConsumerFromConfig(&config, ConnectionPool)
+	assert.NotNil(t, consumer)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.Publish(tcr.CreateMockRandomLetter("TcrTestPoisonQueue"), true)
+
+	consumer.StartConsumingWithAction(
+		func(msg *tcr.ReceivedMessage) {
+			assert.NoError(t, msg.Nack(true))
+		})
+
+	select {
+	case err := <-consumer.Errors():
+		poisonErr, ok := err.(*tcr.PoisonMessageError)
+		assert.True(t, ok)
+		if ok {
+			assert.NoError(t, poisonErr.DeadLetterErr)
+			assert.Equal(t, 3, poisonErr.Redeliveries)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("test timeout waiting for poison message")
+	}
+
+	err = consumer.StopConsuming(false, false)
+	assert.NoError(t, err)
+
+	_, err = RabbitService.Topologer.QueueDelete("TcrTestPoisonQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerServerNamedQueue(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = ""
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+
+	queueName := ""
+	for i := 0; i < 100 && queueName == ""; i++ {
+		time.Sleep(time.Millisecond * 10)
+		queueName = consumer.GetQueueName()
+	}
+	assert.NotEmpty(t, queueName)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	letter := tcr.CreateMockRandomLetter(queueName)
+	publisher.Publish(letter, true)
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.Equal(t, letter.Body, msg.Body)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for message on the server named queue")
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	TestCleanup(t)
+}
+
+func TestConsumerPauseAndResume(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = ""
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+
+	queueName := ""
+	for i := 0; i < 100 && queueName == ""; i++ {
+		time.Sleep(time.Millisecond * 10)
+		queueName = consumer.GetQueueName()
+	}
+	assert.NotEmpty(t, queueName)
+
+	consumer.Pause()
+	assert.True(t, consumer.IsPaused())
+	time.Sleep(time.Millisecond * 200) // the consume loop cancels the basic.consume
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	letter := tcr.CreateMockRandomLetter(queueName)
+	publisher.Publish(letter, true)
+
+	select {
+	case <-consumer.ReceivedMessages():
+		t.Fatal("paused consumer received a message")
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	consumer.Resume()
+	assert.False(t, consumer.IsPaused())
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.Equal(t, letter.Body, msg.Body)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for message after resume")
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	TestCleanup(t)
+}
+
+func TestConsumerSurfacesDeletedQueue(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestDeletedQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestDeletedQueue"
+	consumerConfig.SleepOnErrorInterval = 100
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+	time.Sleep(time.Millisecond * 200) // the consume loop issues the basic.consume
+
+	_, err = topologer.QueueDelete("TcrTestDeletedQueue", false, false, false)
+	assert.NoError(t, err)
+
+	// The server cancels the deliveries and consuming again fails on the missing queue.
+	var errorMessage *tcr.ErrorMessage
+	for errorMessage == nil {
+		select {
+		case err := <-consumer.Errors():
+			var consumerErr *tcr.ConsumerError
+			assert.True(t, errors.As(err, &consumerErr))
+			errors.As(err, &errorMessage)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the deleted queue error")
+		}
+	}
+
+	assert.Equal(t, 404, errorMessage.Code)
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	TestCleanup(t)
+}
+
+func TestConsumerSingleActiveConsumer(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueueFromConfig(&tcr.Queue{Name: "TcrTestSingleActiveQueue", SingleActiveConsumer: true})
+	assert.NoError(t, err)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestSingleActiveQueue"
+	consumerConfig.ConsumerName = ""
+
+	first := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	second := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	first.StartConsuming()
+	second.StartConsuming()
+	time.Sleep(time.Millisecond * 200) // both consume loops issue the basic.consume
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for i := 0; i < 10; i++ {
+		publisher.Publish(tcr.CreateMockRandomLetter("TcrTestSingleActiveQueue"), true)
+	}
+
+	firstCount, secondCount := 0, 0
+	timeout := time.After(time.Second * 3)
+CountLoop:
+	for firstCount+secondCount < 10 {
+		select {
+		case <-first.ReceivedMessages():
+			firstCount++
+		case <-second.ReceivedMessages():
+			secondCount++
+		case <-timeout:
+			break CountLoop
+		}
+	}
+
+	assert.Equal(t, 10, firstCount+secondCount)
+	assert.True(t, firstCount == 0 || secondCount == 0, "both consumers received messages")
+
+	assert.NoError(t, first.StopConsuming(false, true))
+	assert.NoError(t, second.StopConsuming(false, true))
+
+	_, err = topologer.QueueDelete("TcrTestSingleActiveQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerFilter(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestFilterQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestFilterQueue"
+	consumerConfig.DropFiltered = true // a requeued filtered message would be redelivered to this consumer in a loop
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.SetFilter(func(msg *tcr.ReceivedMessage) bool {
+		tenant, _ := msg.HeaderString("tenant")
+		return tenant == "a"
+	})
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for _, tenant := range []string{"b", "a"} {
+		letter := tcr.CreateLetter(0, "", "TcrTestFilterQueue", []byte(tenant))
+		letter.Envelope.Headers = amqp.Table{"tenant": tenant}
+		publisher.PublishWithConfirmation(letter, time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	consumer.StartConsuming()
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.Equal(t, "a", string(msg.Body))
+		assert.NoError(t, msg.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the matching message")
+	}
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		t.Fatalf("filtered message %s was received", msg.Body)
+	case <-time.After(time.Millisecond * 300):
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	// the filtered message was dropped, not requeued
+	messages, _, err := topologer.QueueStats("TcrTestFilterQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	_, err = topologer.QueueDelete("TcrTestFilterQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerMessagesSurviveChannelClose(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestMessagesQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestMessagesQueue"
+	consumerConfig.SleepOnErrorInterval = 100
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	messages := consumer.Messages()
+	consumer.StartConsuming()
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publish := func(body string) {
+		publisher.PublishWithConfirmation(tcr.CreateLetter(0, "", "TcrTestMessagesQueue", []byte(body)), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	publish("before")
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "before", string(msg.Body))
+		assert.NoError(t, msg.Acknowledge())
+
+		// Acknowledging the delivery tag again is a channel error, the server closes the channel mid-stream.
+		assert.NoError(t, msg.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the first message")
+	}
+
+	select {
+	case err := <-consumer.Errors():
+		var errorMessage *tcr.ErrorMessage
+		assert.True(t, errors.As(err, &errorMessage))
+		assert.Equal(t, 406, errorMessage.Code)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the channel close")
+	}
+
+	publish("after")
+
+	// Delivery resumes on the same channel once the Consumer consumes again on a new channel.
+	select {
+	case msg, ok := <-messages:
+		assert.True(t, ok)
+		assert.Equal(t, "after", string(msg.Body))
+		assert.NoError(t, msg.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the message after the channel closed")
+	}
+
+	// Only stopping the Consumer closes it.
+	assert.NoError(t, consumer.StopConsuming(false, false))
+	select {
+	case _, ok := <-messages:
+		assert.False(t, ok)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for messages to close")
+	}
+
+	_, err = topologer.QueueDelete("TcrTestMessagesQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerAutoAckMessagesAreNotAckable(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = ""
+	consumerConfig.AutoAck = true
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+
+	queueName := ""
+	for i := 0; i < 100 && queueName == ""; i++ {
+		time.Sleep(time.Millisecond * 10)
+		queueName = consumer.GetQueueName()
+	}
+	assert.NotEmpty(t, queueName)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.Publish(tcr.CreateMockRandomLetter(queueName), true)
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.False(t, msg.IsAckable)
+		assert.Error(t, msg.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for auto ack message")
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	TestCleanup(t)
+}
+
+func TestConsumerMiddlewareRecovery(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestMiddlewareQueue", false, false, true, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestMiddlewareQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	calls := make(chan string, 10)
+	tracing := func(next tcr.Handler) tcr.Handler {
+		return func(msg *tcr.ReceivedMessage) error {
+			calls <- "tracing"
+			return next(msg)
+		}
+	}
+	consumer.Use(consumer.RecoveryMiddleware(), tracing)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestMiddlewareQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	handled := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ProcessWithHandler(ctx, func(msg *tcr.ReceivedMessage) error {
+			handled++
+			if handled == 1 {
+				panic("first delivery")
+			}
+
+			calls <- "handler"
+			return nil
+		}, 1)
+	}()
+
+	select {
+	case err := <-consumer.Errors():
+		assert.True(t, errors.Is(err, tcr.ErrHandlerPanic))
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the handler panic")
+	}
+
+	// the panicking delivery was nacked with requeue and is handled again
+	expected := []string{"tracing", "tracing", "handler"}
+	for _, call := range expected {
+		select {
+		case actual := <-calls:
+			assert.Equal(t, call, actual)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the redelivered message")
+		}
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	TestCleanup(t)
+}
+
+func TestConsumerRecoversHandlerPanic(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestPanicQueue", false, false, true, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestPanicQueue"
+	consumerConfig.RequeueOnPanic = false
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for _, body := range []string{"first", "poison", "third"} {
+		letter := tcr.CreateLetter(0, "", "TcrTestPanicQueue", []byte(body))
+		publisher.PublishWithConfirmation(letter, time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	handled := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ProcessWithHandler(ctx, func(msg *tcr.ReceivedMessage) error {
+			if string(msg.Body) == "poison" {
+				panic("poison message")
+			}
+
+			handled <- string(msg.Body)
+			return nil
+		}, 1)
+	}()
+
+	select {
+	case err := <-consumer.Errors():
+		assert.True(t, errors.Is(err, tcr.ErrHandlerPanic))
+		assert.Contains(t, err.Error(), "poison message")
+
+		var consumerErr *tcr.ConsumerError
+		assert.True(t, errors.As(err, &consumerErr))
+		if consumerErr != nil {
+			assert.Equal(t, "TcrTestPanicQueue", consumerErr.QueueName)
+			assert.Equal(t, consumerConfig.ConsumerName, consumerErr.ConsumerName)
+			assert.Equal(t, uint64(2), consumerErr.DeliveryTag)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the handler panic")
+	}
+
+	for _, expected := range []string{"first", "third"} {
+		select {
+		case body := <-handled:
+			assert.Equal(t, expected, body)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for messages after the panic")
+		}
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	TestCleanup(t)
+}
+
+func TestConsumerDrain(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestDrainQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestDrainQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for i := 0; i < 5; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestDrainQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	handled := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ProcessWithHandler(ctx, func(msg *tcr.ReceivedMessage) error {
+			time.Sleep(time.Millisecond * 50) // in-flight work
+			handled <- struct{}{}
+			return nil
+		}, 1)
+	}()
+
+	<-handled // every message is prefetched by now
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Second*5)
+	assert.NoError(t, consumer.Drain(drainCtx))
+	drainCancel()
+
+	assert.Len(t, handled, 4) // the rest were handled before Drain returned
+
+	messages, _, err := topologer.QueueStats("TcrTestDrainQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	_, err = topologer.QueueDelete("TcrTestDrainQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerConsumeBatch(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestBatchQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestBatchQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for i := 0; i < 5; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestBatchQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	batchSizes := make(chan int, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ConsumeBatch(ctx, 3, time.Millisecond*200, func(batch []*tcr.ReceivedMessage) error {
+			batchSizes <- len(batch)
+			return nil
+		})
+	}()
+
+	// A full batch and then the rest once the max wait has elapsed.
+	for _, expected := range []int{3, 2} {
+		select {
+		case size := <-batchSizes:
+			assert.Equal(t, expected, size)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for a batch")
+		}
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	messages, _, err := topologer.QueueStats("TcrTestBatchQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	_, err = topologer.QueueDelete("TcrTestBatchQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerStopRequeueInFlight(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	cp, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(cp)
+	err = topologer.CreateQueue("TcrTestStopQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+	for i := 0; i < 3; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestStopQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestStopQueue"
+
+	for _, requeueInFlight := range []bool{true, false} {
+		consumer := tcr.NewConsumerFromConfig(&consumerConfig, cp)
+		consumer.StartConsuming()
+		time.Sleep(time.Millisecond * 300) // every message is received to the internal buffer, unacknowledged
+
+		assert.NoError(t, consumer.StopConsumingWithOptions(tcr.StopOptions{RequeueInFlight: requeueInFlight}))
+		time.Sleep(time.Millisecond * 300) // the consume loop stops
+
+		// Requeued messages are ready again, otherwise they stay unacknowledged on the open channel.
+		messages, _, err := topologer.QueueStats("TcrTestStopQueue")
+		assert.NoError(t, err)
+		if requeueInFlight {
+			assert.Equal(t, 3, messages)
+		} else {
+			assert.Equal(t, 0, messages)
+		}
+	}
+
+	_, err = topologer.QueueDelete("TcrTestStopQueue", false, false, false)
+	assert.NoError(t, err)
+
+	cp.Shutdown()
+}
+
+func TestConsumerDispatchByType(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestDispatchQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestDispatchQueue"
+	consumerConfig.DeadLetterOnError = true // unhandled types are nacked without requeue
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for _, messageType := range []string{"OrderCreated", "OrderUnknown"} {
+		letter := tcr.CreateLetter(0, "", "TcrTestDispatchQueue", []byte(messageType))
+		letter.Envelope.Type = messageType
+		publisher.PublishWithConfirmation(letter, time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	created := make(chan string, 2)
+	handler := consumer.DispatchByType(map[string]tcr.Handler{
+		"OrderCreated": func(msg *tcr.ReceivedMessage) error {
+			created <- msg.Type
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.ProcessWithHandler(ctx, handler, 1) }()
+
+	select {
+	case messageType := <-created:
+		assert.Equal(t, "OrderCreated", messageType)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the typed message")
+	}
+
+	select {
+	case err := <-consumer.Errors():
+		assert.True(t, errors.Is(err, tcr.ErrNoTypeHandler))
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the unhandled type error")
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Len(t, created, 0)
+
+	_, err = topologer.QueueDelete("TcrTestDispatchQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerMaxDeliveriesPerSecond(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestRateQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for i := 0; i < 10; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestRateQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestRateQueue"
+	consumerConfig.MaxDeliveriesPerSecond = 20
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	handled := make(chan time.Time, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ProcessWithHandler(ctx, func(msg *tcr.ReceivedMessage) error {
+			handled <- time.Now()
+			return nil
+		}, 4)
+	}()
+
+	var first, last time.Time
+	for i := 0; i < 10; i++ {
+		select {
+		case last = <-handled:
+			if i == 0 {
+				first = last
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for paced messages")
+		}
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	// 9 intervals after the first delivery at no more than 20 per second.
+	rate := 9 / last.Sub(first).Seconds()
+	assert.True(t, rate <= 20*1.1, "observed %.1f deliveries per second", rate)
+
+	_, err = topologer.QueueDelete("TcrTestRateQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerGlobalQos(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	proxy := NewAMQPProxy(t)
+	defer proxy.Close()
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.URI = proxy.URI
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(cp)
+	err = topologer.CreateQueue("TcrTestQosQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+	for i := 0; i < 3; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQosQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestQosQueue"
+	consumerConfig.PrefetchCount = 2
+
+	for _, globalQos := range []bool{false, true} {
+		consumerConfig.GlobalQos = globalQos
+
+		consumer := tcr.NewConsumerFromConfig(&consumerConfig, cp)
+		consumer.StartConsuming()
+		time.Sleep(time.Millisecond * 300) // prefetched messages are received to the internal buffer, unacknowledged
+
+		// The basic.qos sent carries the prefetch count and the global bit as configured.
+		qos := proxy.Qos()
+		if assert.NotEmpty(t, qos) {
+			assert.Equal(t, AMQPQos{PrefetchCount: 2, Global: globalQos}, qos[len(qos)-1])
+		}
+
+		// The Consumer is alone on its channel, so both limit it to the prefetch.
+		messages, _, err := topologer.QueueStats("TcrTestQosQueue")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, messages, "GlobalQos: %t", globalQos)
+
+		assert.NoError(t, consumer.StopConsumingWithOptions(tcr.StopOptions{RequeueInFlight: true}))
+		time.Sleep(time.Millisecond * 300) // the consume loop stops and requeues the prefetched messages
+	}
+
+	_, err = topologer.QueueDelete("TcrTestQosQueue", false, false, false)
+	assert.NoError(t, err)
+
+	cp.Shutdown()
+}
+
+func TestConsumerQuorumQueue(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestQuorumConsumerQueue", false, true, false, false, false, amqp.Table{
+		"x-queue-type": tcr.QueueTypeQuorum,
+	})
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestQuorumConsumerQueue"
+	consumerConfig.QuorumQueue = true
+
+	// Global qos and server named queues are refused before reaching the server.
+	globalQosConfig := consumerConfig
+	globalQosConfig.GlobalQos = true
+
+	serverNamedConfig := consumerConfig
+	serverNamedConfig.QueueName = ""
+
+	for _, config := range []*tcr.ConsumerConfig{&globalQosConfig, &serverNamedConfig} {
+		consumer := tcr.NewConsumerFromConfig(config, ConnectionPool)
+
+		err := consumer.ProcessWithHandler(context.Background(), func(msg *tcr.ReceivedMessage) error { return nil }, 1)
+		assert.True(t, errors.Is(err, tcr.ErrInvalidQuorumConsumer))
+
+		consumer.StartConsuming()
+		select {
+		case err := <-consumer.Errors():
+			assert.True(t, errors.Is(err, tcr.ErrInvalidQuorumConsumer))
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the quorum queue error")
+		}
+		assert.Error(t, consumer.StopConsuming(false, false)) // never started
+	}
+
+	// A valid quorum queue Consumer consumes as usual.
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQuorumConsumerQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.NoError(t, msg.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the quorum queue message")
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	_, err = topologer.QueueDelete("TcrTestQuorumConsumerQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerRetry(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestRetryQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	// Retried messages wait in the delay queue until they expire back to TcrTestRetryQueue.
+	err = topologer.CreateExchange("TcrTestRetryExchange", "direct", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+	err = topologer.CreateQueue("TcrTestRetryDelayQueue", false, false, false, false, false, map[string]interface{}{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "TcrTestRetryQueue",
+	})
+	assert.NoError(t, err)
+	err = topologer.QueueBind(&tcr.QueueBinding{QueueName: "TcrTestRetryDelayQueue", ExchangeName: "TcrTestRetryExchange", RoutingKey: "TcrTestRetryQueue"})
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestRetryQueue"
+	consumerConfig.RetryExchange = "TcrTestRetryExchange"
+	consumerConfig.MaxRetries = 2
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	assert.Error(t, tcr.NewConsumerFromConfig(AckableConsumerConfig, ConnectionPool).Retry(&tcr.ReceivedMessage{}, 0))
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestRetryQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	consumer.StartConsuming()
+
+	for retries := int64(0); retries <= 2; retries++ {
+		select {
+		case msg := <-consumer.ReceivedMessages():
+			count, _ := msg.HeaderInt(tcr.RetryCountHeader)
+			assert.Equal(t, retries, count)
+			assert.NoError(t, consumer.Retry(msg, time.Millisecond*50)) // dead lettered after 2 retries
+		case <-time.After(time.Second * 5):
+			t.Fatalf("test timeout waiting for retry %d", retries)
+		}
+	}
+
+	select {
+	case <-consumer.ReceivedMessages():
+		t.Fatal("message was retried more than MaxRetries")
+	case <-time.After(time.Millisecond * 300):
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	_, err = topologer.QueueDelete("TcrTestRetryDelayQueue", false, false, false)
+	assert.NoError(t, err)
+	_, err = topologer.QueueDelete("TcrTestRetryQueue", false, false, false)
+	assert.NoError(t, err)
+	assert.NoError(t, topologer.ExchangeDelete("TcrTestRetryExchange", false, false))
+
+	TestCleanup(t)
+}
+
+func TestConsumerBufferSize(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestBufferQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publish := func(count int) {
+		for i := 0; i < count; i++ {
+			publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestBufferQueue"), time.Second)
+			assert.True(t, (<-publisher.PublishReceipts()).Success)
+		}
+	}
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestBufferQueue"
+	consumerConfig.BufferSize = 2
+
+	// Waits for room in the buffer, every message is consumed.
+	publish(5)
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+	time.Sleep(time.Millisecond * 300)
+	assert.Equal(t, 2, consumer.Buffered())
+
+	for i := 0; i < 5; i++ {
+		select {
+		case msg := <-consumer.ReceivedMessages():
+			assert.NoError(t, msg.Acknowledge())
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for buffered messages")
+		}
+	}
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	// Drops what doesn't fit in the buffer.
+	consumerConfig.DropWhenBufferFull = true
+
+	publish(5)
+	consumer = tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+	consumer.StartConsuming()
+	time.Sleep(time.Millisecond * 300)
+	assert.Equal(t, 2, consumer.Buffered())
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, (<-consumer.ReceivedMessages()).Acknowledge())
+	}
+
+	select {
+	case <-consumer.ReceivedMessages():
+		t.Fatal("message was received after the buffer was full")
+	case <-time.After(time.Millisecond * 300):
+	}
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	messages, _, err := topologer.QueueStats("TcrTestBufferQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	_, err = topologer.QueueDelete("TcrTestBufferQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestMultiQueueConsumer(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	queues := []string{"TcrTestShardOneQueue", "TcrTestShardTwoQueue"}
+	topologer := tcr.NewTopologer(ConnectionPool)
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	configs := make([]*tcr.ConsumerConfig, 0, len(queues))
+	for _, queue := range queues {
+		err := topologer.CreateQueue(queue, false, false, false, false, false, nil)
+		assert.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter(queue), time.Second)
+			assert.True(t, (<-publisher.PublishReceipts()).Success)
+		}
+
+		consumerConfig := *AckableConsumerConfig
+		consumerConfig.QueueName = queue
+		configs = append(configs, &consumerConfig)
+	}
+
+	consumer, err := tcr.NewMultiQueueConsumer(configs, ConnectionPool)
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.StartConsuming())
+
+	messages := consumer.Messages()
+	for i := 0; i < 10; i++ {
+		select {
+		case msg := <-messages:
+			assert.NoError(t, msg.Acknowledge()) // acked on the channel of its own queue
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for messages from every queue")
+		}
+	}
+
+	// Stopping stops every queue's consume and closes Messages.
+	assert.NoError(t, consumer.StopConsuming(tcr.StopOptions{}))
+	select {
+	case _, ok := <-messages:
+		assert.False(t, ok)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for messages to close")
+	}
+
+	for _, queue := range queues {
+		messageCount, _, err := topologer.QueueStats(queue)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, messageCount)
+
+		_, err = topologer.QueueDelete(queue, false, false, false)
+		assert.NoError(t, err)
+	}
+
+	_, err = tcr.NewMultiQueueConsumer(nil, ConnectionPool)
+	assert.Error(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerProcessWithHandlerConcurrentHandlers(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestHandlerQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestHandlerQueue"
+	consumerConfig.ConcurrentHandlers = 4
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	count := 8
+	for i := 0; i < count; i++ {
+		letter := tcr.CreateLetter(uint64(i), "", "TcrTestHandlerQueue", []byte(fmt.Sprintf("message-%d", i)))
+		publisher.PublishWithConfirmation(letter, time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	var running, maxRunning int32
+	var failed int32
+	handled := make(chan string, count*2)
+	handler := func(msg *tcr.ReceivedMessage) error {
+
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			previous := atomic.LoadInt32(&maxRunning)
+			if current <= previous || atomic.CompareAndSwapInt32(&maxRunning, previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond * 100) // long enough for the workers to overlap
+
+		// The first attempt of one message fails, it is nacked with requeue and handled again.
+		if string(msg.Body) == "message-0" && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return errors.New("transient failure")
+		}
+
+		handled <- string(msg.Body)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.ProcessWithHandler(ctx, handler, 0) }() // 0 uses ConcurrentHandlers
+
+	received := make(map[string]bool, count)
+	for len(received) < count {
+		select {
+		case body := <-handled:
+			received[body] = true
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the handled messages")
+		}
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	assert.Equal(t, int32(4), atomic.LoadInt32(&maxRunning))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failed))
+
+	// Every message was acked on the channel it was received on, including the redelivered one.
+	messages, _, err := topologer.QueueStats("TcrTestHandlerQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	_, err = topologer.QueueDelete("TcrTestHandlerQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerDeadLetterOnError(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateExchange("TcrTestDeadLetterExchange", "direct", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.CreateQueue("TcrTestDeadLetteredQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.QueueBind(&tcr.QueueBinding{
+		QueueName:    "TcrTestDeadLetteredQueue",
+		ExchangeName: "TcrTestDeadLetterExchange",
+		RoutingKey:   "dead",
+	})
+	assert.NoError(t, err)
+
+	// One queue relies on the consumer's DeadLetterExchange, the other on its own dead letter exchange.
+	err = topologer.CreateQueue("TcrTestDeadLetterQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.CreateQueue("TcrTestQueueDeadLetterQueue", false, false, false, false, false, map[string]interface{}{
+		"x-dead-letter-exchange":    "TcrTestDeadLetterExchange",
+		"x-dead-letter-routing-key": "dead",
+	})
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for _, queueName := range []string{"TcrTestDeadLetterQueue", "TcrTestQueueDeadLetterQueue"} {
+		publisher.PublishWithConfirmation(tcr.CreateLetter(0, "", queueName, []byte(queueName)), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	for _, queueName := range []string{"TcrTestDeadLetterQueue", "TcrTestQueueDeadLetterQueue"} {
+		consumerConfig := *AckableConsumerConfig
+		consumerConfig.QueueName = queueName
+		consumerConfig.DeadLetterOnError = true
+		if queueName == "TcrTestDeadLetterQueue" {
+			consumerConfig.DeadLetterExchange = "TcrTestDeadLetterExchange"
+			consumerConfig.DeadLetterRoutingKey = "dead"
+		}
+		consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+		handled := make(chan struct{}, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- consumer.ProcessWithHandler(ctx, func(msg *tcr.ReceivedMessage) error {
+				handled <- struct{}{}
+				return errors.New("unparsable order")
+			}, 1)
+		}()
+
+		select {
+		case <-handled:
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the message")
+		}
+
+		var msg *amqp.Delivery
+		for i := 0; i < 100 && msg == nil && err == nil; i++ {
+			msg, err = consumer.Get("TcrTestDeadLetteredQueue")
+			time.Sleep(time.Millisecond * 10)
+		}
+		assert.NoError(t, err)
+
+		cancel()
+		assert.Equal(t, context.Canceled, <-done)
+
+		if !assert.NotNil(t, msg) {
+			continue
+		}
+
+		assert.Equal(t, queueName, string(msg.Body))
+		if queueName == "TcrTestDeadLetterQueue" {
+			// Republished by the consumer with the reason.
+			assert.Equal(t, "unparsable order", msg.Headers[tcr.DeadLetterReasonHeader])
+			assert.Equal(t, queueName, msg.Headers[tcr.DeadLetterQueueHeader])
+		} else {
+			// Nacked without requeue, the broker dead lettered it.
+			assert.NotNil(t, msg.Headers["x-death"])
+		}
+
+		messages, _, err := topologer.QueueStats(queueName)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, messages)
+	}
+
+	for _, queueName := range []string{"TcrTestDeadLetterQueue", "TcrTestQueueDeadLetterQueue", "TcrTestDeadLetteredQueue"} {
+		_, err = topologer.QueueDelete(queueName, false, false, false)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, topologer.ExchangeDelete("TcrTestDeadLetterExchange", false, false))
+
+	TestCleanup(t)
+}
+
+func TestConsumerConsumeBatchHandlesPartialBatchOnStop(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestBatchStopQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestBatchStopQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for i := 0; i < 3; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestBatchStopQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	batchSizes := make(chan int, 10)
+	done := make(chan error, 1)
+	go func() {
+		// Neither full nor timed out, the 3 messages wait in a partial batch.
+		done <- consumer.ConsumeBatch(context.Background(), 10, time.Minute, func(batch []*tcr.ReceivedMessage) error {
+			batchSizes <- len(batch)
+			return nil
+		})
+	}()
+
+	timeout := time.After(time.Second * 5)
+	for {
+		messages, _, err := topologer.QueueStats("TcrTestBatchStopQueue")
+		assert.NoError(t, err)
+		if messages == 0 {
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatal("test timeout waiting for the messages to be delivered")
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+	time.Sleep(time.Millisecond * 200) // received into the batch
+
+	assert.NoError(t, consumer.StopConsuming(false, false))
+
+	// Handled before the stop completed.
+	select {
+	case size := <-batchSizes:
+		assert.Equal(t, 3, size)
+	default:
+		t.Fatal("partial batch wasn't handled before the stop completed")
+	}
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for ConsumeBatch to return")
+	}
+
+	// Acknowledged, nothing is redelivered.
+	messages, _, err := topologer.QueueStats("TcrTestBatchStopQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	_, err = topologer.QueueDelete("TcrTestBatchStopQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestConsumerConsumeBatchChannelDropsMidBatch(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	proxy := NewAMQPProxy(t)
+	defer proxy.Close()
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.URI = proxy.URI
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err = topologer.CreateQueue("TcrTestBatchDropQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	for i := 0; i < 3; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestBatchDropQueue"), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestBatchDropQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, cp)
+
+	batches := make(chan []*tcr.ReceivedMessage, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ConsumeBatch(ctx, 10, time.Millisecond*500, func(batch []*tcr.ReceivedMessage) error {
+			batches <- batch
+			return nil
+		})
+	}()
+
+	// The 3 messages are accumulated, then the connection drops before the batch's max wait elapses.
+	time.Sleep(time.Millisecond * 200)
+	proxy.CloseConnections()
+
+	// The partial batch is discarded, not handled, and its messages are redelivered in a new batch.
+	discarded := false
+	for !discarded {
+		select {
+		case err := <-consumer.Errors():
+			discarded = strings.Contains(err.Error(), "discarded a partial batch of 3")
+		case batch := <-batches:
+			t.Fatalf("batch of %d messages from the dropped channel was handled", len(batch))
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the discarded batch")
+		}
+	}
+
+	select {
+	case batch := <-batches:
+		assert.Len(t, batch, 3)
+		for _, msg := range batch {
+			assert.True(t, msg.AMQPDelivery.Redelivered)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("test timeout waiting for the redelivered batch")
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	messages, _, err := topologer.QueueStats("TcrTestBatchDropQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	_, err = topologer.QueueDelete("TcrTestBatchDropQueue", false, false, false)
+	assert.NoError(t, err)
+
+	cp.Shutdown()
+	TestCleanup(t)
+}
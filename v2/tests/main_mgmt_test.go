@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrmgmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagementClientListsTopology(t *testing.T) {
+
+	responses := map[string]string{
+		"/api/queues/%2Ftcr":    `[{"name":"TcrTestQueue","vhost":"/tcr","type":"quorum","durable":true,"arguments":{"x-queue-type":"quorum"},"messages":3,"consumers":1}]`,
+		"/api/exchanges/%2Ftcr": `[{"name":"TcrTestExchange","vhost":"/tcr","type":"topic","durable":true,"internal":false,"arguments":{}}]`,
+		"/api/bindings/%2Ftcr":  `[{"source":"TcrTestExchange","vhost":"/tcr","destination":"TcrTestQueue","destination_type":"queue","routing_key":"tcr.#","arguments":{}}]`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "guest" || password != "guest" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		response, ok := responses[r.URL.EscapedPath()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	seasoning := &tcr.RabbitSeasoning{
+		ManagementConfig: &tcr.ManagementConfig{URL: server.URL + "/", Username: "guest", Password: "guest", Vhost: "/tcr"},
+	}
+
+	client, err := tcrmgmt.NewClientFromSeasoning(seasoning)
+	assert.NoError(t, err)
+
+	queues, err := client.ListQueues(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(queues))
+	if len(queues) == 1 {
+		assert.Equal(t, "TcrTestQueue", queues[0].Name)
+		assert.Equal(t, "quorum", queues[0].Type)
+		assert.Equal(t, 3, queues[0].Messages)
+	}
+
+	exchanges, err := client.ListExchanges(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(exchanges))
+	if len(exchanges) == 1 {
+		assert.Equal(t, "topic", exchanges[0].Type)
+	}
+
+	bindings, err := client.ListBindings(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(bindings))
+	if len(bindings) == 1 {
+		assert.Equal(t, "tcr.#", bindings[0].RoutingKey)
+		assert.Equal(t, "queue", bindings[0].DestinationType)
+	}
+
+	// wrong credentials
+	client, err = tcrmgmt.NewClient(&tcr.ManagementConfig{URL: server.URL, Username: "guest", Password: "wrong"})
+	assert.NoError(t, err)
+
+	_, err = client.ListQueues(context.Background())
+	assert.Error(t, err)
+}
@@ -1,6 +1,9 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -191,3 +194,983 @@ WaitLoop:
 
 	TestCleanup(t)
 }
+
+func TestPublisherFlushWithContext(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	count := 100
+	for i := 0; i < count; i++ {
+		assert.True(t, publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+	}
+
+	// Flush starts AutoPublishing since letters are queued.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	flushed, err := publisher.FlushWithContext(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, count, flushed)
+
+	// The Publisher accepts letters again once flushed.
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	assert.True(t, publisher.QueueLetter(letter))
+
+	flushed, err = publisher.FlushWithContext(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+
+	publisher.Shutdown(false)
+	TestCleanup(t)
+}
+
+func TestPublisherQueueTime(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	assert.True(t, publisher.QueueLetter(letter))
+	assert.False(t, letter.QueuedAt.IsZero())
+
+	// The letter waits in the queue until AutoPublish picks it up.
+	time.Sleep(time.Millisecond * 50)
+	publisher.StartAutoPublishing()
+
+	receipt := <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+	assert.True(t, receipt.QueueTime >= time.Millisecond*50)
+	assert.Equal(t, receipt.QueueTime, publisher.QueueTime())
+
+	publisher.Shutdown(false)
+	TestCleanup(t)
+}
+
+func TestPublisherAutoPublishLetterConfirmationTimeout(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	proxy := NewAMQPProxy(t)
+	defer proxy.Close()
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.URI = proxy.URI
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+	publisher.StartAutoPublishing()
+
+	// The broker never confirms, the letter fails after its own ConfirmationTimeout.
+	proxy.DropConfirms(true)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.ConfirmationTimeout = time.Millisecond * 50
+	assert.True(t, publisher.QueueLetter(letter))
+
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		assert.False(t, receipt.Success)
+		assert.Equal(t, letter.LetterID, receipt.LetterID)
+		assert.Error(t, receipt.Error)
+		assert.True(t, receipt.Latency < time.Duration(Seasoning.PublisherConfig.PublishTimeOutInterval)*time.Millisecond)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the failed receipt")
+	}
+
+	// The next letter, with the PublishTimeOutInterval, isn't held up.
+	proxy.DropConfirms(false)
+
+	letter = tcr.CreateMockRandomLetter("TcrTestQueue")
+	assert.True(t, publisher.QueueLetter(letter))
+
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		assert.True(t, receipt.Success)
+		assert.Equal(t, letter.LetterID, receipt.LetterID)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the receipt")
+	}
+
+	publisher.Shutdown(false)
+	cp.Shutdown()
+	TestCleanup(t)
+}
+
+func TestPublisherDeduplicateLetters(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.DeduplicateLetters = true
+
+	seasoning := *Seasoning
+	seasoning.PublisherConfig = &publisherConfig
+
+	publisher := tcr.NewPublisherFromConfig(&seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	assert.True(t, publisher.QueueLetter(letter))
+	assert.True(t, publisher.QueueLetter(letter)) // already pending, not queued again
+	assert.Equal(t, 1, publisher.QueueDepth())
+
+	publisher.StartAutoPublishing()
+
+	receipt := <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+	assert.Equal(t, letter.LetterID, receipt.LetterID)
+
+	select {
+	case receipt = <-publisher.PublishReceipts():
+		t.Fatalf("letter %d was published twice", receipt.LetterID)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// Once published, the LetterID can be queued again.
+	assert.True(t, publisher.QueueLetter(letter))
+	receipt = <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+
+	publisher.Shutdown(false)
+	TestCleanup(t)
+}
+
+func TestPublisherConfirmationLostOnReconnect(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.MaxCacheChannelCount = 1
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+
+	// The broker closes the channel (404) instead of confirming a publish to a missing exchange.
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Envelope.Exchange = "TcrTestMissingExchange"
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	publisher.PublishWithConfirmationContext(ctx, letter)
+	cancel()
+
+	receipt := <-publisher.PublishReceipts()
+	assert.False(t, receipt.Success)
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrConfirmationLost))
+
+	// The reconnected channel confirms again.
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), time.Second)
+	receipt = <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+
+	cp.Shutdown()
+	TestCleanup(t)
+}
+
+func TestPublisherPublishWithCallback(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letters := []*tcr.Letter{tcr.CreateMockRandomLetter("TcrTestQueue"), tcr.CreateMockRandomLetter("TcrTestQueue")}
+	receipts := make([]chan *tcr.PublishReceipt, len(letters))
+	for i, letter := range letters {
+		callbackReceipts := make(chan *tcr.PublishReceipt, 1)
+		receipts[i] = callbackReceipts
+		publisher.PublishWithCallback(letter, func(receipt *tcr.PublishReceipt) { callbackReceipts <- receipt })
+	}
+
+	for i, letter := range letters {
+		select {
+		case receipt := <-receipts[i]:
+			assert.True(t, receipt.Success)
+			assert.Equal(t, letter.LetterID, receipt.LetterID)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the publish callback")
+		}
+	}
+
+	// Receipts with a callback don't go to the PublishReceipts.
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		t.Fatalf("letter %d receipt was sent to the PublishReceipts", receipt.LetterID)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	TestCleanup(t)
+}
+
+func TestPublisherOutstandingConfirms(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	letters := make([]*tcr.Letter, 10)
+	for i := range letters {
+		letters[i] = tcr.CreateMockRandomLetter("TcrTestQueue")
+	}
+
+	_, err := publisher.PublishBatchWithConfirmation(context.Background(), letters)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	receipts := make(chan *tcr.PublishReceipt, 10)
+	for i := 0; i < 10; i++ {
+		publisher.PublishWithCallback(tcr.CreateMockRandomLetter("TcrTestQueue"), func(receipt *tcr.PublishReceipt) { receipts <- receipt })
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, (<-receipts).Success)
+	}
+
+	_ = publisher.PublishBatch(letters)
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	assert.NoError(t, publisher.PublishTransaction(letters))
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	// Shutdown waits for the background publishes to finish with their confirmations.
+	publisher.Shutdown(false)
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	TestCleanup(t)
+}
+
+func TestPublisherShutdownWithUnconfirmedPublish(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	proxy := NewAMQPProxy(t)
+	defer proxy.Close()
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.URI = proxy.URI
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	// The broker never confirms the publish, its context is never done.
+	proxy.DropConfirms(true)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+	ctx, cancel := context.WithCancel(context.Background())
+	published := make(chan struct{})
+	go func() {
+		publisher.PublishWithConfirmationContext(ctx, tcr.CreateMockRandomLetter("TcrTestQueue"))
+		close(published)
+	}()
+
+	for publisher.OutstandingConfirms() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer shutdownCancel()
+
+	shutdownStart := time.Now()
+	err = publisher.ShutdownWithContext(shutdownCtx, false)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, time.Since(shutdownStart) < time.Second)
+	assert.Equal(t, 1, publisher.OutstandingConfirms())
+
+	cancel()
+	<-published
+	assert.Equal(t, 0, publisher.OutstandingConfirms())
+
+	cp.Shutdown()
+	TestCleanup(t)
+}
+
+func TestPublisherPublishWithConfirmationResult(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	confirmation, err := publisher.PublishWithConfirmationResult(context.Background(), letter)
+	assert.NoError(t, err)
+	assert.NotNil(t, confirmation)
+	assert.Equal(t, letter.LetterID, confirmation.LetterID)
+	assert.True(t, confirmation.Acked)
+	assert.True(t, confirmation.DeliveryTag > 0)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	// Never sent, so no delivery tag.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	confirmation, err = publisher.PublishWithConfirmationResult(ctx, tcr.CreateMockRandomLetter("TcrTestQueue"))
+	assert.True(t, errors.Is(err, tcr.ErrPublishNotSent))
+	assert.False(t, confirmation.Acked)
+	assert.Equal(t, uint64(0), confirmation.DeliveryTag)
+	assert.False(t, (<-publisher.PublishReceipts()).Success)
+
+	publisher.Shutdown(false)
+	TestCleanup(t)
+}
+
+func TestPublisherQueueLetterWithTimeoutWhenFull(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.MaxQueueSize = 1
+
+	seasoning := *Seasoning
+	seasoning.PublisherConfig = &publisherConfig
+
+	publisher := tcr.NewPublisherFromConfig(&seasoning, ConnectionPool)
+
+	err := publisher.QueueLetterWithTimeout(tcr.CreateMockRandomLetter("TcrTestQueue"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, publisher.QueueDepth())
+
+	err = publisher.QueueLetterWithTimeout(tcr.CreateMockRandomLetter("TcrTestQueue"), time.Millisecond*10)
+	assert.Equal(t, tcr.ErrPublishQueueFull, err)
+
+	TestCleanup(t)
+}
+
+func TestPublisherPublishBatch(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	body := tcr.RandomBytes(1000)
+	letters := make([]*tcr.Letter, 10)
+	for i := 0; i < len(letters); i++ {
+		letters[i] = tcr.CreateLetter(uint64(i), "", "TcrTestQueue", body)
+	}
+
+	receipts := publisher.PublishBatch(letters)
+	assert.Equal(t, len(letters), len(receipts))
+
+	for i, receipt := range receipts {
+		assert.True(t, receipt.Success, "receipt %d failed: %v", i, receipt.Error)
+		assert.Equal(t, letters[i].LetterID, receipt.LetterID)
+	}
+
+	TestCleanup(t)
+}
+
+func TestPublisherPublishBatchLargerThanConfirmationBuffer(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.MaxCacheChannelCount = 1 // every publish uses the same confirm mode channel
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(cp)
+	err = topologer.CreateQueue("TcrTestPublishBatchQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+
+	body := tcr.RandomBytes(1000)
+	letters := make([]*tcr.Letter, 250) // more than the channel's confirmation buffer
+	for i := 0; i < len(letters); i++ {
+		letters[i] = tcr.CreateLetter(uint64(i), "", "TcrTestPublishBatchQueue", body)
+	}
+
+	for batch := 0; batch < 2; batch++ {
+		done := make(chan []tcr.PublishReceipt, 1)
+		go func() { done <- publisher.PublishBatch(letters) }()
+
+		select {
+		case receipts := <-done:
+			for i, receipt := range receipts {
+				assert.True(t, receipt.Success, "receipt %d failed: %v", i, receipt.Error)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatal("test timeout waiting for the batch, the channel stalled")
+		}
+	}
+
+	// The channel's confirmations were all read, it still confirms.
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestPublishBatchQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	messages, _, err := topologer.QueueStats("TcrTestPublishBatchQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*len(letters)+1, messages)
+
+	_, err = topologer.QueueDelete("TcrTestPublishBatchQueue", false, false, false)
+	assert.NoError(t, err)
+
+	cp.Shutdown()
+}
+
+func TestPublisherPublishBatchUnroutableMandatoryLetters(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.MaxCacheChannelCount = 1 // every publish uses the same confirm mode channel
+
+	cp, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, cp)
+
+	body := tcr.RandomBytes(100)
+	letters := make([]*tcr.Letter, 250) // more returns than the channel's returns buffer
+	for i := 0; i < len(letters); i++ {
+		letters[i] = tcr.CreateLetter(uint64(i), "", "TcrTestNoSuchQueue", body)
+		letters[i].Envelope.Mandatory = true
+	}
+
+	done := make(chan []tcr.PublishReceipt, 1)
+	go func() { done <- publisher.PublishBatch(letters) }()
+
+	select {
+	case receipts := <-done:
+		for i, receipt := range receipts {
+			assert.True(t, receipt.Success, "receipt %d failed: %v", i, receipt.Error)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("test timeout waiting for the batch, the connection stalled on returns")
+	}
+
+	confirmed := make(chan []tcr.PublishConfirmation, 1)
+	go func() {
+		confirmations, err := publisher.PublishBatchWithConfirmation(context.Background(), letters)
+		assert.NoError(t, err)
+		confirmed <- confirmations
+	}()
+
+	select {
+	case confirmations := <-confirmed:
+		for i, confirmation := range confirmations {
+			assert.True(t, confirmation.Acked, "letter %d wasn't confirmed: %v", i, confirmation.Error)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("test timeout waiting for the confirmed batch, the connection stalled on returns")
+	}
+
+	// The returns were forwarded to Returns.
+	select {
+	case returned := <-publisher.Returns():
+		assert.Equal(t, "TcrTestNoSuchQueue", returned.RoutingKey)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for a returned letter")
+	}
+
+	cp.Shutdown()
+}
+
+func TestPublisherReturnsUnroutableMandatoryPublish(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueueThatDoesNotExist")
+	letter.Envelope.Mandatory = true
+
+	publisher.PublishWithConfirmationTransient(letter, time.Second*5)
+
+	select {
+	case returnMessage := <-publisher.Returns():
+		assert.Equal(t, "TcrTestQueueThatDoesNotExist", returnMessage.RoutingKey)
+		assert.Equal(t, letter.Body, returnMessage.Body)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for returned message")
+	}
+
+	TestCleanup(t)
+}
+
+func TestPublishWithConfirmationUnroutableMandatoryFails(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueueThatDoesNotExist")
+	letter.Envelope.Mandatory = true
+
+	publisher.PublishWithConfirmation(letter, time.Second*5)
+
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		assert.False(t, receipt.Success)
+		assert.Error(t, receipt.Error)
+		assert.Equal(t, letter, receipt.FailedLetter)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for publish receipt")
+	}
+
+	TestCleanup(t)
+}
+
+func TestPublishRetriesOnClosedChannel(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.MaxCacheChannelCount = 1
+
+	connectionPool, err := tcr.NewConnectionPool(&poolConfig)
+	assert.NoError(t, err)
+
+	// Close the only cached channel and put it back as if it were healthy.
+	chanHost := connectionPool.GetChannelFromPool()
+	assert.NoError(t, chanHost.Channel.Close())
+	connectionPool.ReturnChannel(chanHost, false)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, connectionPool)
+	publisher.Publish(tcr.CreateMockRandomLetter("TcrTestQueue"), false)
+
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		assert.True(t, receipt.Success, "publish failed: %v", receipt.Error)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for publish receipt")
+	}
+
+	connectionPool.Shutdown()
+	TestCleanup(t)
+}
+
+func TestPublishWithExpiration(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestExpirationQueue", false, false, true, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestExpirationQueue")
+	letter.Envelope.Expiration = time.Millisecond * 10
+	publisher.PublishWithConfirmation(letter, time.Second)
+
+	receipt := <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+
+	time.Sleep(time.Millisecond * 100)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestExpirationQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	msg, err := consumer.Get("TcrTestExpirationQueue")
+	assert.NoError(t, err)
+	assert.Nil(t, msg) // expired before it was consumed
+
+	_, err = topologer.QueueDelete("TcrTestExpirationQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestPublishWithPriority(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueueFromConfig(&tcr.Queue{
+		Name:        "TcrTestPriorityQueue",
+		AutoDelete:  true,
+		MaxPriority: 10,
+	})
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	priorities := []uint8{1, 5, 9, 0}
+	for _, priority := range priorities {
+		letter := tcr.CreateMockRandomLetter("TcrTestPriorityQueue")
+		letter.Envelope.Priority = priority
+		publisher.PublishWithConfirmation(letter, time.Second)
+
+		receipt := <-publisher.PublishReceipts()
+		assert.True(t, receipt.Success)
+	}
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestPriorityQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	for _, expected := range []uint8{9, 5, 1, 0} {
+		delivery, err := consumer.Get("TcrTestPriorityQueue")
+		assert.NoError(t, err)
+		if assert.NotNil(t, delivery) {
+			assert.Equal(t, expected, delivery.Priority)
+		}
+	}
+
+	_, err = topologer.QueueDelete("TcrTestPriorityQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestPublisherPublishTransaction(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	body := tcr.RandomBytes(1000)
+	letters := make([]*tcr.Letter, 10)
+	for i := 0; i < len(letters); i++ {
+		letters[i] = tcr.CreateLetter(uint64(i), "", "TcrTestQueue", body)
+	}
+
+	assert.NoError(t, publisher.PublishTransaction(letters))
+
+	// the server closes the channel on a missing exchange, so the commit fails
+	letters[len(letters)-1] = tcr.CreateLetter(uint64(len(letters)), "TcrTestMissingExchange", "TcrTestQueue", body)
+	assert.Error(t, publisher.PublishTransaction(letters))
+
+	TestCleanup(t)
+}
+
+func TestPublisherPublishSync(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	assert.NoError(t, publisher.PublishSync(letter))
+
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		t.Fatalf("unexpected receipt for letter %d", receipt.LetterID)
+	default:
+	}
+
+	TestCleanup(t)
+}
+
+func TestPublishReceiptLatency(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), time.Second)
+	receipt := <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+	assert.True(t, receipt.Latency > 0)
+
+	// the server closes the channel on a missing exchange, so the publish times out
+	letter := tcr.CreateLetter(1, "TcrTestMissingExchange", "TcrTestQueue", []byte("latency"))
+	publisher.PublishWithConfirmation(letter, time.Millisecond*100)
+	receipt = <-publisher.PublishReceipts()
+	assert.False(t, receipt.Success)
+	assert.True(t, receipt.Latency >= time.Millisecond*100)
+
+	TestCleanup(t)
+}
+
+func TestPublishSetsTimestampAndAppID(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueueFromConfig(&tcr.Queue{
+		Name:       "TcrTestAppIDQueue",
+		AutoDelete: true,
+	})
+	assert.NoError(t, err)
+
+	seasoning := *Seasoning
+	seasoning.AppID = "tcr-tests"
+	publisher := tcr.NewPublisherFromConfig(&seasoning, ConnectionPool)
+
+	publishStart := time.Now().Add(-time.Second) // broker timestamps have second precision
+
+	letter := tcr.CreateMockRandomLetter("TcrTestAppIDQueue")
+	publisher.PublishWithConfirmation(letter, time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	letter = tcr.CreateMockRandomLetter("TcrTestAppIDQueue")
+	letter.Envelope.AppID = "tcr-override"
+	publisher.PublishWithConfirmation(letter, time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestAppIDQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	for _, expected := range []string{"tcr-tests", "tcr-override"} {
+		delivery, err := consumer.Get("TcrTestAppIDQueue")
+		assert.NoError(t, err)
+		if assert.NotNil(t, delivery) {
+			assert.Equal(t, expected, delivery.AppId)
+			assert.True(t, delivery.Timestamp.After(publishStart))
+		}
+	}
+
+	_, err = topologer.QueueDelete("TcrTestAppIDQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestPublisherPublishStream(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestStreamQueue", false, false, true, false, false, nil)
+	assert.NoError(t, err)
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.StreamChunkSize = 1000
+
+	seasoning := *Seasoning
+	seasoning.PublisherConfig = &publisherConfig
+	seasoning.CompressionConfig = &tcr.CompressionConfig{Enabled: true, Type: tcr.GzipCompressionType}
+	seasoning.EncryptionConfig = &tcr.EncryptionConfig{Enabled: false}
+
+	publisher := tcr.NewPublisherFromConfig(&seasoning, ConnectionPool)
+
+	data := tcr.RandomBytes(2500)
+	envelope := &tcr.Envelope{RoutingKey: "TcrTestStreamQueue", ContentType: "application/octet-stream", DeliveryMode: 2}
+	err = publisher.PublishStream(context.Background(), envelope, bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestStreamQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	received := &bytes.Buffer{}
+	for i := 0; i < 3; i++ {
+		delivery, err := consumer.Get("TcrTestStreamQueue")
+		assert.NoError(t, err)
+		if !assert.NotNil(t, delivery) {
+			break
+		}
+
+		assert.Equal(t, int32(i), delivery.Headers[tcr.StreamChunkHeader])
+		assert.Equal(t, i == 2, delivery.Headers[tcr.StreamLastHeader])
+
+		chunk, err := tcr.DecompressPayload(delivery.Body, seasoning.CompressionConfig)
+		assert.NoError(t, err)
+		received.Write(chunk)
+	}
+
+	assert.Equal(t, data, received.Bytes())
+
+	_, err = topologer.QueueDelete("TcrTestStreamQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestPublisherPublishBatchWithConfirmation(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+
+	body := tcr.RandomBytes(1000)
+	letters := make([]*tcr.Letter, 500) // more than the channel's confirmation buffer
+	for i := 0; i < len(letters); i++ {
+		letters[i] = tcr.CreateLetter(uint64(i), "", "TcrTestQueue", body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	confirmations, err := publisher.PublishBatchWithConfirmation(ctx, letters)
+	assert.NoError(t, err)
+	assert.Equal(t, len(letters), len(confirmations))
+
+	for i, confirmation := range confirmations {
+		assert.True(t, confirmation.Acked, "letter %d not acked: %v", i, confirmation.Error)
+		assert.Equal(t, letters[i].LetterID, confirmation.LetterID)
+		assert.True(t, confirmation.DeliveryTag > 0)
+	}
+
+	TestCleanup(t)
+}
+
+func TestPublisherCircuitBreaker(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.CircuitBreakerThreshold = 2
+	publisherConfig.CircuitBreakerCooldown = 500
+
+	seasoning := *Seasoning
+	seasoning.PublisherConfig = &publisherConfig
+
+	publisher := tcr.NewPublisherFromConfig(&seasoning, ConnectionPool)
+	letter := tcr.CreateLetter(1, "TcrTestCircuitExchange", "TcrTestQueue", []byte("circuit"))
+
+	// the server closes the channel on a missing exchange, so the confirmation never arrives
+	for i := 0; i < publisherConfig.CircuitBreakerThreshold; i++ {
+		publisher.PublishWithConfirmation(letter, time.Millisecond*200)
+		receipt := <-publisher.PublishReceipts()
+		assert.False(t, receipt.Success)
+		assert.False(t, errors.Is(receipt.Error, tcr.ErrCircuitOpen))
+	}
+
+	publisher.PublishWithConfirmation(letter, time.Millisecond*200)
+	receipt := <-publisher.PublishReceipts()
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrCircuitOpen))
+
+	circuits := publisher.CircuitStats()
+	assert.Equal(t, 1, len(circuits))
+	if len(circuits) == 1 {
+		assert.Equal(t, tcr.CircuitOpen, circuits[0].State)
+		assert.Equal(t, "TcrTestCircuitExchange", circuits[0].Exchange)
+	}
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateExchange("TcrTestCircuitExchange", "direct", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	// after the cooldown the half open probe succeeds and closes the circuit
+	time.Sleep(time.Millisecond * 500)
+	publisher.PublishWithConfirmation(letter, time.Second)
+	receipt = <-publisher.PublishReceipts()
+	assert.True(t, receipt.Success)
+	assert.Empty(t, publisher.CircuitStats())
+
+	err = topologer.ExchangeDelete("TcrTestCircuitExchange", false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestPublisherCircuitBreakerBatchTransactionAndStream(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.CircuitBreakerThreshold = 1
+	publisherConfig.CircuitBreakerCooldown = 60000
+
+	seasoning := *Seasoning
+	seasoning.PublisherConfig = &publisherConfig
+
+	publisher := tcr.NewPublisherFromConfig(&seasoning, ConnectionPool)
+	letter := tcr.CreateLetter(1, "TcrTestCircuitBatchExchange", "TcrTestQueue", []byte("circuit"))
+
+	// the server closes the channel on a missing exchange, opening the circuit
+	publisher.PublishWithConfirmation(letter, time.Millisecond*200)
+	receipt := <-publisher.PublishReceipts()
+	assert.False(t, receipt.Success)
+
+	receipts := publisher.PublishBatch([]*tcr.Letter{letter})
+	assert.True(t, errors.Is(receipts[0].Error, tcr.ErrCircuitOpen))
+
+	confirmations, err := publisher.PublishBatchWithConfirmation(context.Background(), []*tcr.Letter{letter})
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(confirmations[0].Error, tcr.ErrCircuitOpen))
+
+	err = publisher.PublishTransaction([]*tcr.Letter{letter})
+	assert.True(t, errors.Is(err, tcr.ErrCircuitOpen))
+
+	err = publisher.PublishStream(context.Background(), letter.Envelope, bytes.NewReader([]byte("stream")))
+	assert.True(t, errors.Is(err, tcr.ErrCircuitOpen))
+
+	TestCleanup(t)
+}
+
+type testTraceKey struct{}
+
+// testTracePropagator propagates the string under testTraceKey in the traceparent header.
+type testTracePropagator struct{}
+
+func (testTracePropagator) Inject(ctx context.Context, headers amqp.Table) {
+
+	if trace, ok := ctx.Value(testTraceKey{}).(string); ok {
+		headers["traceparent"] = trace
+	}
+}
+
+func (testTracePropagator) Extract(ctx context.Context, headers amqp.Table) context.Context {
+
+	if trace, ok := headers["traceparent"].(string); ok {
+		return context.WithValue(ctx, testTraceKey{}, trace)
+	}
+
+	return ctx
+}
+
+func TestPublisherTracePropagator(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestTraceQueue", false, true, false, false, false, nil)
+	assert.NoError(t, err)
+
+	propagator := testTracePropagator{}
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.SetTracePropagator(propagator)
+
+	// Publishing with a context injects its trace context, publishing without one doesn't.
+	ctx := context.WithValue(context.Background(), testTraceKey{}, "00-trace-span-01")
+	publisher.PublishWithConfirmationContext(ctx, tcr.CreateMockRandomLetter("TcrTestTraceQueue"))
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestTraceQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestTraceQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, ConnectionPool)
+
+	msg, err := consumer.Get("TcrTestTraceQueue")
+	assert.NoError(t, err)
+	if assert.NotNil(t, msg) {
+		extracted := propagator.Extract(context.Background(), msg.Headers)
+		assert.Equal(t, "00-trace-span-01", extracted.Value(testTraceKey{}))
+	}
+
+	msg, err = consumer.Get("TcrTestTraceQueue")
+	assert.NoError(t, err)
+	if assert.NotNil(t, msg) {
+		extracted := propagator.Extract(context.Background(), msg.Headers)
+		assert.Nil(t, extracted.Value(testTraceKey{}))
+	}
+
+	_, err = topologer.QueueDelete("TcrTestTraceQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
+
+func TestPublisherTracePropagatorSharedEnvelope(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestTraceSharedQueue", false, true, false, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.SetTracePropagator(testTracePropagator{})
+
+	// One Envelope reused by concurrent publishes, each with its own trace context.
+	envelope := &tcr.Envelope{
+		RoutingKey:   "TcrTestTraceSharedQueue",
+		ContentType:  "application/json",
+		DeliveryMode: 2,
+		Headers:      amqp.Table{"tenant": "a"},
+	}
+
+	publishes := make(chan struct{}, 20)
+	for i := 0; i < cap(publishes); i++ {
+		go func(i int) {
+			ctx := context.WithValue(context.Background(), testTraceKey{}, fmt.Sprintf("00-trace-%d-01", i))
+			publisher.PublishWithContext(ctx, &tcr.Letter{LetterID: uint64(i), Body: []byte("trace"), Envelope: envelope}, true)
+			publishes <- struct{}{}
+		}(i)
+	}
+
+	for i := 0; i < cap(publishes); i++ {
+		select {
+		case <-publishes:
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the publishes")
+		}
+	}
+
+	// The shared Envelope was never written to.
+	assert.Equal(t, amqp.Table{"tenant": "a"}, envelope.Headers)
+
+	_, err = topologer.QueueDelete("TcrTestTraceSharedQueue", false, false, false)
+	assert.NoError(t, err)
+
+	TestCleanup(t)
+}
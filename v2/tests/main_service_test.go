@@ -1,10 +1,18 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fortytw2/leaktest"
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -43,6 +51,70 @@ func TestRabbitServicePublish(t *testing.T) {
 	service.Shutdown(true)
 }
 
+func TestRabbitServicePublishToQueue(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &tcr.EncryptionConfig{}
+
+	receipts := make(chan *tcr.PublishReceipt, 1)
+	service, err := tcr.NewRabbitService(&seasoning, "", "", func(receipt *tcr.PublishReceipt) { receipts <- receipt }, nil)
+	assert.NoError(t, err)
+
+	assert.Error(t, service.PublishToQueue("data", "", "", false, nil))
+	assert.NoError(t, service.PublishToQueue("data", "TcrTestQueue", "", false, nil))
+
+	receipt := <-receipts
+	assert.True(t, receipt.Success)
+
+	service.Shutdown(true)
+}
+
+func TestRabbitServiceNamedPublishers(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &tcr.EncryptionConfig{}
+
+	receipts := make(chan *tcr.PublishReceipt, 10)
+	service, err := tcr.NewRabbitService(&seasoning, "", "", func(receipt *tcr.PublishReceipt) { receipts <- receipt }, nil)
+	assert.NoError(t, err)
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.MaxQueueSize = 10
+	publisherConfig.MaxParallelPublishes = 1
+
+	bulk, err := service.AddPublisher("bulk", &publisherConfig)
+	assert.NoError(t, err)
+
+	_, err = service.AddPublisher("bulk", &publisherConfig)
+	assert.Error(t, err)
+
+	byName, err := service.PublisherByName("bulk")
+	assert.NoError(t, err)
+	assert.Equal(t, bulk, byName)
+
+	_, err = service.PublisherByName("missing")
+	assert.Error(t, err)
+	assert.Error(t, service.PublishWith("missing", "data", "", "TcrTestQueue", "", false, nil))
+
+	// Letters queued with the named Publisher don't wait in the default Publisher's queue.
+	assert.NoError(t, service.QueueLetterWith("bulk", tcr.CreateMockRandomLetter("TcrTestQueue")))
+	assert.NoError(t, service.PublishWith("bulk", "data", "", "TcrTestQueue", "", false, nil))
+	assert.Equal(t, 0, service.Publisher.QueueDepth())
+
+	for i := 0; i < 2; i++ {
+		select {
+		case receipt := <-receipts:
+			assert.True(t, receipt.Success)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the named publisher's receipts")
+		}
+	}
+
+	service.Shutdown(true)
+}
+
 func TestRabbitServicePublishLetter(t *testing.T) {
 	defer leaktest.Check(t)() // Fail on leaked goroutines.
 
@@ -70,3 +142,718 @@ func TestRabbitServicePublishAndConsumeLetter(t *testing.T) {
 
 	service.Shutdown(true)
 }
+
+func TestRabbitServicePublishWithConfirmationTimeout(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	proxy := NewAMQPProxy(t)
+	defer proxy.Close()
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.URI = proxy.URI
+
+	seasoning := *Seasoning
+	seasoning.PoolConfig = &poolConfig
+	seasoning.PublishConfirmationTimeout = 100
+
+	receipts := make(chan *tcr.PublishReceipt, 1)
+	service, err := tcr.NewRabbitService(&seasoning, "", "", func(receipt *tcr.PublishReceipt) { receipts <- receipt }, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, service)
+
+	// The broker's ack is held back past the PublishConfirmationTimeout, the publish fails.
+	proxy.DropConfirms(true)
+
+	data := tcr.RandomBytes(1000)
+	publishStart := time.Now()
+	err = service.PublishWithConfirmation(data, "", "TcrTestQueue", "", false, nil)
+	assert.NoError(t, err)
+
+	select {
+	case receipt := <-receipts:
+		assert.False(t, receipt.Success)
+		assert.Error(t, receipt.Error)
+		assert.True(t, time.Since(publishStart) >= time.Millisecond*100)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for publish receipt")
+	}
+
+	// Acked within the PublishConfirmationTimeout, the publish succeeds.
+	proxy.DropConfirms(false)
+
+	err = service.PublishWithConfirmation(data, "", "TcrTestQueue", "", false, nil)
+	assert.NoError(t, err)
+
+	select {
+	case receipt := <-receipts:
+		assert.True(t, receipt.Success)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for publish receipt")
+	}
+
+	service.Shutdown(true)
+}
+
+func TestRabbitServicePublishLetterMessageID(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	service, err := tcr.NewRabbitService(Seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, service)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.MessageID = "order-1234"
+	assert.NoError(t, service.PublishLetter(letter))
+	assert.Equal(t, "order-1234", letter.MessageID)
+
+	letter = tcr.CreateMockRandomLetter("TcrTestQueue")
+	assert.NoError(t, service.PublishLetter(letter))
+	assert.NotEmpty(t, letter.MessageID)
+
+	service.Shutdown(true)
+}
+
+func TestRabbitServicePublishHeadersRoundTrip(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	service, err := tcr.NewRabbitService(Seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, service)
+
+	service.WithHeader("tenant-id", "tenant-1").WithHeader("schema-version", int32(1))
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = ""
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, service.ConnectionPool)
+	consumer.StartConsuming()
+
+	queueName := ""
+	for i := 0; i < 100 && queueName == ""; i++ {
+		time.Sleep(time.Millisecond * 10)
+		queueName = consumer.GetQueueName()
+	}
+	assert.NotEmpty(t, queueName)
+
+	err = service.Publish(tcr.RandomBytes(100), "", queueName, "", false, amqp.Table{"schema-version": int32(2), "trace": "abc"})
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.Equal(t, "tenant-1", msg.Headers["tenant-id"])
+		assert.Equal(t, int32(2), msg.Headers["schema-version"]) // publish headers take precedence
+		assert.Equal(t, "abc", msg.Headers["trace"])
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for message with headers")
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	service.Shutdown(true)
+}
+
+func TestRabbitServicePublishLetterKeepsCallerHeaders(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	service, err := tcr.NewRabbitService(Seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	service.WithHeader("tenant-id", "tenant-1")
+
+	for _, publish := range []func(*tcr.Letter) error{service.PublishLetter, service.QueueLetter} {
+		headers := amqp.Table{"trace": "abc"}
+		envelope := &tcr.Envelope{RoutingKey: "TcrTestNoQueue", ContentType: "application/json", DeliveryMode: 2, Headers: headers}
+		letter := &tcr.Letter{Body: []byte("headers"), Envelope: envelope}
+
+		assert.NoError(t, publish(letter))
+
+		// The letter has the combined headers, the caller's Envelope and headers are untouched.
+		assert.Equal(t, "tenant-1", letter.Envelope.Headers["tenant-id"])
+		assert.Equal(t, "abc", letter.Envelope.Headers["trace"])
+		assert.Equal(t, amqp.Table{"trace": "abc"}, headers)
+		assert.Equal(t, amqp.Table{"trace": "abc"}, envelope.Headers)
+	}
+
+	service.Shutdown(false)
+}
+
+func TestRabbitServicePublishPayloadPipeline(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	encryptionConfig := *Seasoning.EncryptionConfig
+	encryptionConfig.Enabled = true
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &encryptionConfig
+	seasoning.CompressionConfig = &tcr.CompressionConfig{Enabled: true}
+
+	service, err := tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = ""
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, service.ConnectionPool)
+	consumer.StartConsuming()
+
+	queueName := ""
+	for i := 0; i < 100 && queueName == ""; i++ {
+		time.Sleep(time.Millisecond * 10)
+		queueName = consumer.GetQueueName()
+	}
+	assert.NotEmpty(t, queueName)
+
+	assert.NoError(t, service.Publish(tcr.RandomString(100), "", queueName, "", true, nil))
+	assert.NoError(t, service.PublishData([]byte("raw"), "", queueName, nil))
+
+	// The publishes may be on different channels, so the messages may arrive in either order.
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-consumer.ReceivedMessages():
+			expected := string(msg.Body) != "raw"
+			assert.Equal(t, expected, msg.IsWrapped())
+			assert.Equal(t, expected, msg.IsCompressed())
+			assert.Equal(t, expected, msg.IsEncrypted())
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for message")
+		}
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	service.Shutdown(true)
+}
+
+func TestRabbitServicePublishDefault(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &tcr.EncryptionConfig{}
+	seasoning.DefaultWrapPayload = true
+	seasoning.DefaultMetadata = "defaults"
+
+	service, err := tcr.NewRabbitService(&seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = ""
+
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, service.ConnectionPool)
+	consumer.StartConsuming()
+
+	queueName := ""
+	for i := 0; i < 100 && queueName == ""; i++ {
+		time.Sleep(time.Millisecond * 10)
+		queueName = consumer.GetQueueName()
+	}
+	assert.NotEmpty(t, queueName)
+
+	assert.NoError(t, service.PublishDefault(tcr.RandomString(100), "", queueName, nil))
+	assert.NoError(t, service.PublishWithConfirmationDefault(tcr.RandomString(100), "", queueName, nil))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-consumer.ReceivedMessages():
+			assert.True(t, msg.IsWrapped())
+			assert.Contains(t, string(msg.Body), "defaults")
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for message")
+		}
+	}
+
+	assert.NoError(t, consumer.StopConsuming(false, true))
+	service.Shutdown(true)
+}
+
+func TestRabbitServicePublishWithConfirmationContext(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	service, err := tcr.NewRabbitService(Seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, service)
+
+	data := tcr.RandomBytes(1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	err = service.PublishWithConfirmationContext(ctx, data, "", "TcrTestQueue", "", false, nil)
+	assert.NoError(t, err)
+	cancel()
+
+	// a done context fails before the letter is sent
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	err = service.PublishWithConfirmationContext(ctx, data, "", "TcrTestQueue", "", false, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(err, tcr.ErrPublishNotSent))
+	assert.False(t, errors.Is(err, tcr.ErrPublishUnconfirmed))
+
+	service.Shutdown(true)
+}
+
+func TestRabbitServiceWaitForReady(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.Lazy = true
+
+	seasoning := *Seasoning
+	seasoning.PoolConfig = &poolConfig
+
+	service, err := tcr.NewRabbitService(&seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, service.HealthReport().NotYetConnected)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	assert.NoError(t, service.WaitForReady(ctx))
+	assert.True(t, service.HealthReport().LiveConnections > 0)
+
+	service.Shutdown(true)
+
+	TestCleanup(t)
+}
+
+func TestRabbitServicePing(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	service, err := tcr.NewRabbitService(Seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	assert.NoError(t, service.Ping(ctx))
+	cancel()
+
+	// a done context fails without pinging
+	err = service.Ping(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	service.Shutdown(true)
+}
+
+func TestRabbitServiceRestartConsumer(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	service, err := tcr.NewRabbitService(Seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	assert.Error(t, service.RestartConsumer("missing"))
+
+	stopped, err := service.GetConsumer("TurboCookedRabbitConsumer")
+	assert.NoError(t, err)
+	stopped.StartConsuming()
+
+	assert.NoError(t, service.RestartConsumer("TurboCookedRabbitConsumer"))
+
+	consumer, err := service.GetConsumer("TurboCookedRabbitConsumer")
+	assert.NoError(t, err)
+	assert.NotEqual(t, stopped, consumer)
+	assert.Equal(t, stopped.Config, consumer.Config)
+
+	// The restarted Consumer is consuming.
+	time.Sleep(time.Millisecond * 100)
+	assert.NoError(t, consumer.StopConsuming(true, true))
+
+	service.Shutdown(false)
+}
+
+func TestRabbitServiceRestartConsumerKeepsHowItWasStarted(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	topologer := tcr.NewTopologer(ConnectionPool)
+	err := topologer.CreateQueue("TcrTestRestartQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer"]
+	consumerConfig.QueueName = "TcrTestRestartQueue"
+	seasoning := *Seasoning
+	seasoning.ConsumerConfigs = map[string]*tcr.ConsumerConfig{"TurboCookedRabbitConsumer": &consumerConfig}
+
+	service, err := tcr.NewRabbitService(&seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	// A Consumer that was never started is replaced, not started.
+	assert.NoError(t, service.RestartConsumer("TurboCookedRabbitConsumer"))
+	consumer, err := service.GetConsumer("TurboCookedRabbitConsumer")
+	assert.NoError(t, err)
+	assert.Error(t, consumer.StopConsuming(true, true))
+
+	// A Consumer started with an action is restarted with the action.
+	received := make(chan *tcr.ReceivedMessage, 1)
+	consumer.StartConsumingWithAction(func(msg *tcr.ReceivedMessage) { received <- msg })
+	assert.NoError(t, service.RestartConsumer("TurboCookedRabbitConsumer"))
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestRestartQueue"), time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	select {
+	case <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the restarted action")
+	}
+
+	consumer, err = service.GetConsumer("TurboCookedRabbitConsumer")
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.StopConsuming(true, true))
+
+	// A Consumer processing with a handler can't be restarted.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.ProcessWithHandler(ctx, func(msg *tcr.ReceivedMessage) error { return nil }, 1)
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Error(t, service.RestartConsumer("TurboCookedRabbitConsumer"))
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	_, err = topologer.QueueDelete("TcrTestRestartQueue", false, false, false)
+	assert.NoError(t, err)
+
+	service.Shutdown(false)
+}
+
+func TestRabbitServiceNotInitialized(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	for _, service := range []*tcr.RabbitService{
+		nil,
+		{},
+		{Config: Seasoning},
+		{Config: Seasoning, ConnectionPool: ConnectionPool},
+	} {
+		err := service.Publish("data", "", "TcrTestQueue", "", false, nil)
+		assert.True(t, errors.Is(err, tcr.ErrServiceNotInitialized))
+
+		err = service.PublishWithConfirmation("data", "", "TcrTestQueue", "", false, nil)
+		assert.True(t, errors.Is(err, tcr.ErrServiceNotInitialized))
+
+		err = service.PublishData([]byte("data"), "", "TcrTestQueue", nil)
+		assert.True(t, errors.Is(err, tcr.ErrServiceNotInitialized))
+
+		err = service.PublishLetter(tcr.CreateMockRandomLetter("TcrTestQueue"))
+		assert.True(t, errors.Is(err, tcr.ErrServiceNotInitialized))
+
+		err = service.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue"))
+		assert.True(t, errors.Is(err, tcr.ErrServiceNotInitialized))
+
+		err = service.Ping(context.Background())
+		assert.True(t, errors.Is(err, tcr.ErrServiceNotInitialized))
+	}
+
+	TestCleanup(t)
+}
+
+func TestCreateRabbitServiceWithKeyLength(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	encryptionConfig := *Seasoning.EncryptionConfig
+	encryptionConfig.Enabled = true
+	encryptionConfig.KeyLength = 16
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &encryptionConfig
+
+	service, err := tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, len(service.Config.EncryptionConfig.Hashkey))
+
+	data, err := tcr.CreatePayload([]byte("aes-128"), service.Config.CompressionConfig, service.Config.EncryptionConfig)
+	assert.NoError(t, err)
+
+	buffer := bytes.NewBuffer(data)
+	assert.NoError(t, tcr.ReadPayload(buffer, service.Config.CompressionConfig, service.Config.EncryptionConfig))
+
+	service.Shutdown(true)
+
+	encryptionConfig.KeyLength = 20
+	service, err = tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.True(t, errors.Is(err, tcr.ErrInvalidKeyLength))
+	assert.Nil(t, service)
+
+	TestCleanup(t)
+}
+
+func TestCreateRabbitServiceValidatesArgon2(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	encryptionConfig := *Seasoning.EncryptionConfig
+	encryptionConfig.Enabled = true
+	encryptionConfig.MemoryMultiplier = 0
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &encryptionConfig
+
+	service, err := tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.True(t, errors.Is(err, tcr.ErrInvalidArgon2Config))
+	assert.Nil(t, service)
+
+	encryptionConfig.MemoryMultiplier = 1 << 30
+	service, err = tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.True(t, errors.Is(err, tcr.ErrInvalidArgon2Config))
+	assert.Nil(t, service)
+
+	encryptionConfig.MemoryMultiplier = 8
+	encryptionConfig.TimeConsideration = 0
+	encryptionConfig.Threads = 0
+	service, err = tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), service.Config.EncryptionConfig.TimeConsideration)
+	assert.Equal(t, uint8(1), service.Config.EncryptionConfig.Threads)
+	assert.Equal(t, 32, len(service.Config.EncryptionConfig.Hashkey))
+
+	service.Shutdown(true)
+
+	TestCleanup(t)
+}
+
+func TestRabbitServiceLogger(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	buffer := &bytes.Buffer{}
+	seasoning := *Seasoning
+	seasoning.Logger = tcr.NewStdLogger(log.New(buffer, "", 0))
+
+	service, err := tcr.NewRabbitService(&seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	service.Shutdown(true)
+
+	output := buffer.String()
+	assert.Contains(t, output, "INFO rabbitservice shutting down")
+	assert.Contains(t, output, "INFO connectionpool shut down")
+	assert.Contains(t, output, "INFO rabbitservice shut down")
+
+	TestCleanup(t)
+}
+
+// testMetricsRecorder is a MetricsRecorder counting what it records.
+type testMetricsRecorder struct {
+	lock           sync.Mutex
+	publishSuccess int
+	publishFailure int
+	latencies      []time.Duration
+	consumed       map[string]int
+	poolSize       int
+}
+
+func (r *testMetricsRecorder) IncPublishSuccess() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.publishSuccess++
+}
+
+func (r *testMetricsRecorder) IncPublishFailure() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.publishFailure++
+}
+
+func (r *testMetricsRecorder) ObservePublishLatency(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.latencies = append(r.latencies, d)
+}
+
+func (r *testMetricsRecorder) IncConsumed(consumer string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.consumed[consumer]++
+}
+
+func (r *testMetricsRecorder) SetConnectionPoolSize(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.poolSize = n
+}
+
+func TestRabbitServiceMetricsRecorder(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	consumerConfig := *AckableConsumerConfig
+	consumerConfig.QueueName = "TcrTestMetricsQueue"
+
+	seasoning := *Seasoning
+	seasoning.ConsumerConfigs = map[string]*tcr.ConsumerConfig{consumerConfig.ConsumerName: &consumerConfig}
+
+	receipts := make(chan *tcr.PublishReceipt, 2)
+	service, err := tcr.NewRabbitService(&seasoning, "", "", func(receipt *tcr.PublishReceipt) { receipts <- receipt }, nil)
+	assert.NoError(t, err)
+
+	recorder := &testMetricsRecorder{consumed: make(map[string]int)}
+	service.SetMetricsRecorder(recorder)
+
+	recorder.lock.Lock()
+	assert.Equal(t, int(Seasoning.PoolConfig.MaxConnectionCount), recorder.poolSize)
+	recorder.lock.Unlock()
+
+	err = service.Topologer.CreateQueue("TcrTestMetricsQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	// A confirmed publish is a success with its latency, a publish whose context is already done a failure.
+	service.Publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestMetricsQueue"), time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.Publisher.PublishWithConfirmationContext(ctx, tcr.CreateMockRandomLetter("TcrTestMetricsQueue"))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-receipts:
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for publish receipts")
+		}
+	}
+
+	consumer, err := service.GetConsumer(consumerConfig.ConsumerName)
+	assert.NoError(t, err)
+	consumer.StartConsuming()
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		assert.NoError(t, msg.Acknowledge())
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the published message")
+	}
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	recorder.lock.Lock()
+	assert.Equal(t, 1, recorder.publishSuccess)
+	assert.Equal(t, 1, recorder.publishFailure)
+	assert.Len(t, recorder.latencies, 1)
+	assert.Equal(t, 1, recorder.consumed[consumer.ConsumerName])
+	recorder.lock.Unlock()
+
+	_, err = service.Topologer.QueueDelete("TcrTestMetricsQueue", false, false, false)
+	assert.NoError(t, err)
+
+	service.Shutdown(true)
+
+	recorder.lock.Lock()
+	assert.Equal(t, 0, recorder.poolSize)
+	recorder.lock.Unlock()
+
+	TestCleanup(t)
+}
+
+func TestRabbitServiceRotateEncryptionKeyWhilePublishing(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	encryptionConfig := *Seasoning.EncryptionConfig
+	encryptionConfig.Enabled = true
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestRotationQueue"
+
+	seasoning := *Seasoning
+	seasoning.EncryptionConfig = &encryptionConfig
+	seasoning.ConsumerConfigs = map[string]*tcr.ConsumerConfig{consumerConfig.ConsumerName: &consumerConfig}
+
+	service, err := tcr.NewRabbitService(&seasoning, "PasswordyPassword", "SaltySalt", nil, nil)
+	assert.NoError(t, err)
+
+	err = service.Topologer.CreateQueue("TcrTestRotationQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	decodedMessages, err := service.Consume(consumerConfig.ConsumerName, func() interface{} { return &TestStruct{} }, true)
+	assert.NoError(t, err)
+
+	// Rotate while publishing and consuming, run with -race.
+	rotated := make(chan error, 1)
+	go func() {
+		for i := 0; i < 5; i++ {
+			if err := service.RotateEncryptionKey(fmt.Sprintf("PasswordyPassword%d", i), "SaltySalt"); err != nil {
+				rotated <- err
+				return
+			}
+		}
+		rotated <- nil
+	}()
+
+	count := 100
+	for i := 0; i < count; i++ {
+		assert.NoError(t, service.Publish(&TestStruct{PropertyString1: "Rotated"}, "", "TcrTestRotationQueue", "", true, nil))
+	}
+	assert.NoError(t, <-rotated)
+
+	for i := 0; i < count; i++ {
+		select {
+		case decoded := <-decodedMessages:
+			assert.NoError(t, decoded.Error)
+			assert.Equal(t, "Rotated", decoded.Payload.(*TestStruct).PropertyString1)
+		case <-time.After(time.Second * 5):
+			t.Fatal("test timeout waiting for the published messages")
+		}
+	}
+
+	consumer, err := service.GetConsumer(consumerConfig.ConsumerName)
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.StopConsuming(false, true))
+
+	_, err = service.Topologer.QueueDelete("TcrTestRotationQueue", false, false, false)
+	assert.NoError(t, err)
+
+	service.Shutdown(true)
+}
+
+func TestRabbitServiceConsumeClosesOnStop(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestConsumeQueue"
+
+	seasoning := *Seasoning
+	seasoning.ConsumerConfigs = map[string]*tcr.ConsumerConfig{consumerConfig.ConsumerName: &consumerConfig}
+
+	service, err := tcr.NewRabbitService(&seasoning, "", "", nil, nil)
+	assert.NoError(t, err)
+
+	err = service.Topologer.CreateQueue("TcrTestConsumeQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	count := 3
+	for i := 0; i < count; i++ {
+		assert.NoError(t, service.Publish(&TestStruct{PropertyString1: "Decoded"}, "", "TcrTestConsumeQueue", "", false, nil))
+	}
+
+	decodedMessages, err := service.Consume(consumerConfig.ConsumerName, func() interface{} { return &TestStruct{} }, false)
+	assert.NoError(t, err)
+
+	consumer, err := service.GetConsumer(consumerConfig.ConsumerName)
+	assert.NoError(t, err)
+
+	// The channel is ranged over until the consumer stops.
+	decoded := make(chan int)
+	go func() {
+		received := 0
+		for decodedMessage := range decodedMessages {
+			assert.NoError(t, decodedMessage.Error)
+			assert.Equal(t, "Decoded", decodedMessage.Payload.(*TestStruct).PropertyString1)
+
+			received++
+			if received == count {
+				assert.NoError(t, consumer.StopConsuming(false, false))
+			}
+		}
+		decoded <- received
+	}()
+
+	select {
+	case received := <-decoded:
+		assert.Equal(t, count, received)
+	case <-time.After(time.Second * 5):
+		t.Fatal("test timeout waiting for the decoded messages to close")
+	}
+
+	// A disabled consumer never closes the channel, so it isn't consumed.
+	consumer.Enabled = false
+	_, err = service.Consume(consumerConfig.ConsumerName, func() interface{} { return &TestStruct{} }, false)
+	assert.Error(t, err)
+
+	_, err = service.Topologer.QueueDelete("TcrTestConsumeQueue", false, false, false)
+	assert.NoError(t, err)
+
+	service.Shutdown(true)
+}
@@ -1,7 +1,14 @@
 package main_test
 
 import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
@@ -55,3 +62,181 @@ func BenchCleanup(b *testing.B) {
 	RabbitService.Topologer.QueueDelete("TcrTestQueue", false, false, false)
 	RabbitService.Shutdown(true)
 }
+
+// AMQP 0-9-1 frame and method ids used by the AMQPProxy.
+const (
+	amqpFrameHeaderSize = 7
+	amqpFrameMethod     = 1
+	amqpClassBasic      = 60
+	amqpMethodQos       = 10
+	amqpMethodAck       = 80
+)
+
+// AMQPQos is a basic.qos sent by a client through the AMQPProxy.
+type AMQPQos struct {
+	PrefetchCount uint16
+	Global        bool
+}
+
+// AMQPProxy forwards AMQP connections to the broker, dropping the publish confirmations (basic.ack sent by the
+// broker) while DropConfirms is set, the same as a broker that never confirms, and recording the basic.qos sent by
+// the clients.
+type AMQPProxy struct {
+	URI          string // the Seasoning URI with the proxy address, for the PoolConfig
+	listener     net.Listener
+	target       string
+	dropConfirms int32
+	qos          []AMQPQos
+	conns        []net.Conn
+	lock         *sync.Mutex
+}
+
+// NewAMQPProxy starts an AMQPProxy to the broker of the Seasoning URI.
+func NewAMQPProxy(t *testing.T) *AMQPProxy {
+
+	uri, err := url.Parse(Seasoning.PoolConfig.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := &AMQPProxy{
+		listener: listener,
+		target:   uri.Host,
+		lock:     &sync.Mutex{},
+	}
+
+	uri.Host = listener.Addr().String()
+	proxy.URI = uri.String()
+
+	go proxy.accept()
+	return proxy
+}
+
+// DropConfirms sets whether the broker's publish confirmations are dropped.
+func (proxy *AMQPProxy) DropConfirms(drop bool) {
+
+	var value int32
+	if drop {
+		value = 1
+	}
+
+	atomic.StoreInt32(&proxy.dropConfirms, value)
+}
+
+// Qos returns every basic.qos sent through the proxy.
+func (proxy *AMQPProxy) Qos() []AMQPQos {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+
+	return append([]AMQPQos(nil), proxy.qos...)
+}
+
+// CloseConnections drops the proxied connections, the same as a network failure, new connections are still accepted.
+func (proxy *AMQPProxy) CloseConnections() {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+
+	for _, conn := range proxy.conns {
+		conn.Close()
+	}
+	proxy.conns = nil
+}
+
+// Close stops the proxy and closes its connections.
+func (proxy *AMQPProxy) Close() {
+
+	proxy.listener.Close()
+	proxy.CloseConnections()
+}
+
+func (proxy *AMQPProxy) accept() {
+
+	for {
+		client, err := proxy.listener.Accept()
+		if err != nil {
+			return // closed
+		}
+
+		server, err := net.Dial("tcp", proxy.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		proxy.lock.Lock()
+		proxy.conns = append(proxy.conns, client, server)
+		proxy.lock.Unlock()
+
+		go proxy.forward(client, server, true)
+		go proxy.forward(server, client, false)
+	}
+}
+
+// forward copies the frames read from src to dst, fromClient telling the direction, until either connection closes.
+func (proxy *AMQPProxy) forward(src net.Conn, dst net.Conn, fromClient bool) {
+
+	defer src.Close()
+	defer dst.Close()
+
+	reader := bufio.NewReader(src)
+	if fromClient { // the protocol header preceding the frames
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+
+		if _, err := dst.Write(header); err != nil {
+			return
+		}
+	}
+
+	for {
+		header := make([]byte, amqpFrameHeaderSize)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+
+		frame := make([]byte, amqpFrameHeaderSize+int(binary.BigEndian.Uint32(header[3:]))+1) // and the frame end
+		copy(frame, header)
+		if _, err := io.ReadFull(reader, frame[amqpFrameHeaderSize:]); err != nil {
+			return
+		}
+
+		if !proxy.inspect(frame, fromClient) {
+			continue
+		}
+
+		if _, err := dst.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// inspect records the basic.qos frames and returns false for frames to drop.
+func (proxy *AMQPProxy) inspect(frame []byte, fromClient bool) bool {
+
+	payload := frame[amqpFrameHeaderSize : len(frame)-1]
+	if frame[0] != amqpFrameMethod || len(payload) < 4 || binary.BigEndian.Uint16(payload) != amqpClassBasic {
+		return true
+	}
+
+	method := binary.BigEndian.Uint16(payload[2:])
+	switch {
+	case fromClient && method == amqpMethodQos && len(payload) >= 11:
+		proxy.lock.Lock()
+		proxy.qos = append(proxy.qos, AMQPQos{
+			PrefetchCount: binary.BigEndian.Uint16(payload[8:]), // after the long prefetch-size
+			Global:        payload[10]&1 == 1,
+		})
+		proxy.lock.Unlock()
+	case !fromClient && method == amqpMethodAck:
+		return atomic.LoadInt32(&proxy.dropConfirms) == 0
+	}
+
+	return true
+}
@@ -1,10 +1,12 @@
 package main_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/streadway/amqp"
@@ -96,3 +98,351 @@ func TestCreateQuorumQueue(t *testing.T) {
 	_, err = topologer.QueueDelete("TcrTestQuorumQueue", false, false, false)
 	assert.NoError(t, err)
 }
+
+func TestCreateInvalidQuorumQueue(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateQueue("TcrTestInvalidQuorumQueue", false, true, true, false, false, amqp.Table{
+		"x-queue-type": tcr.QueueTypeQuorum,
+	})
+	assert.True(t, errors.Is(err, tcr.ErrInvalidQuorumQueue))
+
+	err = topologer.CreateQueue("TcrTestInvalidQuorumQueue", false, true, false, true, false, amqp.Table{
+		"x-queue-type": tcr.QueueTypeQuorum,
+	})
+	assert.True(t, errors.Is(err, tcr.ErrInvalidQuorumQueue))
+
+	queue := &tcr.Queue{
+		Name:        "TcrTestInvalidQuorumQueue",
+		Type:        tcr.QueueTypeQuorum,
+		MaxPriority: 10,
+	}
+
+	err = topologer.CreateQueueFromConfig(queue)
+	assert.True(t, errors.Is(err, tcr.ErrInvalidQuorumQueue))
+
+	// exclusive and auto delete are disregarded for a quorum Queue from config
+	queue = &tcr.Queue{
+		Name:       "TcrTestInvalidQuorumQueue",
+		Type:       tcr.QueueTypeQuorum,
+		Exclusive:  true,
+		AutoDelete: true,
+		Args:       amqp.Table{"x-max-length": int32(100)},
+	}
+
+	err = topologer.CreateQueueFromConfig(queue)
+	assert.NoError(t, err)
+	assert.Equal(t, tcr.QueueTypeQuorum, queue.Args[tcr.QueueTypeArg])
+
+	_, err = topologer.QueueDelete("TcrTestInvalidQuorumQueue", false, false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestQueueStats(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateQueue("TcrTestStatsQueue", false, false, true, false, false, nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, connectionPool)
+	for i := 0; i < 3; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestStatsQueue"), time.Second)
+	}
+
+	messages, consumers, err := topologer.QueueStats("TcrTestStatsQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, messages)
+	assert.Equal(t, 0, consumers)
+
+	consumerConfig := *ConsumerConfig
+	consumerConfig.QueueName = "TcrTestStatsQueue"
+	consumer := tcr.NewConsumerFromConfig(&consumerConfig, connectionPool)
+
+	depth, err := consumer.QueueDepth()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, depth)
+
+	_, _, err = topologer.QueueStats("TcrTestQueueThatDoesNotExist")
+	assert.Error(t, err)
+
+	_, err = topologer.QueueDelete("TcrTestStatsQueue", false, false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestEnsureQueue(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	queue := &tcr.Queue{
+		Name:    "TcrTestEnsureQueue",
+		Durable: true,
+		Args:    amqp.Table{"x-max-length": int32(100)},
+	}
+
+	created, err := topologer.EnsureQueue(queue)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	created, err = topologer.EnsureQueue(queue)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	mismatched := &tcr.Queue{
+		Name:    "TcrTestEnsureQueue",
+		Durable: true,
+		Args:    amqp.Table{"x-max-length": int32(200)},
+	}
+
+	created, err = topologer.EnsureQueue(mismatched)
+	assert.False(t, created)
+	assert.True(t, errors.Is(err, tcr.ErrTopologyMismatch))
+
+	_, err = topologer.QueueDelete("TcrTestEnsureQueue", false, false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestCreateQueueFromConfigWithArgs(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateQueueFromConfig(&tcr.Queue{
+		Name:    "TcrTestQuorumArgsQueue",
+		Durable: true,
+		Args: amqp.Table{
+			"x-queue-type":           tcr.QueueTypeQuorum,
+			"x-dead-letter-exchange": "",
+		},
+	})
+	assert.NoError(t, err)
+
+	err = topologer.CreateQueueFromConfig(&tcr.Queue{
+		Name: "TcrTestMaxLengthQueue",
+		Args: amqp.Table{
+			"x-max-length": int32(2),
+			"x-overflow":   "drop-head",
+		},
+	})
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, connectionPool)
+	for i := 0; i < 3; i++ {
+		publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestMaxLengthQueue"), time.Second)
+	}
+
+	messages, _, err := topologer.QueueStats("TcrTestMaxLengthQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, messages)
+
+	_, err = topologer.QueueDelete("TcrTestQuorumArgsQueue", false, false, false)
+	assert.NoError(t, err)
+
+	_, err = topologer.QueueDelete("TcrTestMaxLengthQueue", false, false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestBindQueueMany(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateExchange("TcrTestBindManyExchange", "topic", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.CreateQueue("TcrTestBindManyQueue", false, false, true, false, false, nil)
+	assert.NoError(t, err)
+
+	routingKeys := []string{"orders.*", "orders.created", "orders.updated"}
+	err = topologer.BindQueueMany("TcrTestBindManyQueue", "TcrTestBindManyExchange", routingKeys, nil)
+	assert.NoError(t, err)
+
+	err = topologer.BindQueueMany("TcrTestBindManyQueue", "TcrTestMissingExchange", routingKeys, nil)
+	var bindErr *tcr.BindingError
+	if assert.True(t, errors.As(err, &bindErr)) {
+		assert.Equal(t, routingKeys, bindErr.RoutingKeys)
+		assert.Len(t, bindErr.Errors, len(routingKeys))
+	}
+
+	_, err = topologer.QueueDelete("TcrTestBindManyQueue", false, false, false)
+	assert.NoError(t, err)
+
+	err = topologer.ExchangeDelete("TcrTestBindManyExchange", false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestCreateExchangeWithAlternateExchange(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateExchangeFromConfig(&tcr.Exchange{Name: "TcrTestFallbackExchange", Type: "fanout"})
+	assert.NoError(t, err)
+
+	args := amqp.Table{}
+	err = topologer.CreateExchangeFromConfig(&tcr.Exchange{
+		Name:              "TcrTestPrimaryExchange",
+		Type:              "direct",
+		AlternateExchange: "TcrTestFallbackExchange",
+		Args:              args,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, args) // the caller's args aren't changed
+
+	err = topologer.CreateQueue("TcrTestFallbackQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.QueueBind(&tcr.QueueBinding{QueueName: "TcrTestFallbackQueue", ExchangeName: "TcrTestFallbackExchange"})
+	assert.NoError(t, err)
+
+	// Nothing is bound to the primary exchange, the mandatory letter is routed by the alternate exchange instead of returned.
+	letter := tcr.CreateLetter(1, "TcrTestPrimaryExchange", "unrouted", []byte("fallback"))
+	letter.Envelope.Mandatory = true
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, connectionPool)
+	publisher.PublishWithConfirmation(letter, time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	messages, _, err := topologer.QueueStats("TcrTestFallbackQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, messages)
+
+	_, err = topologer.QueueDelete("TcrTestFallbackQueue", false, false, false)
+	assert.NoError(t, err)
+
+	err = topologer.ExchangeDelete("TcrTestPrimaryExchange", false, false)
+	assert.NoError(t, err)
+
+	err = topologer.ExchangeDelete("TcrTestFallbackExchange", false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestCreateDelayedExchange(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateExchangeFromConfig(&tcr.Exchange{Name: "TcrTestDelayedExchange", DelayedType: "direct"})
+	if err != nil {
+		connectionPool.Shutdown()
+		t.Skipf("rabbitmq_delayed_message_exchange plugin isn't enabled: %s", err)
+	}
+
+	err = topologer.CreateQueue("TcrTestDelayedQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.QueueBind(&tcr.QueueBinding{QueueName: "TcrTestDelayedQueue", ExchangeName: "TcrTestDelayedExchange", RoutingKey: "TcrTestDelayedQueue"})
+	assert.NoError(t, err)
+
+	letter := tcr.CreateLetter(1, "TcrTestDelayedExchange", "TcrTestDelayedQueue", []byte("delayed")).WithDelay(time.Second)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, connectionPool)
+	publisher.PublishWithConfirmation(letter, time.Second)
+	assert.True(t, (<-publisher.PublishReceipts()).Success)
+
+	// The exchange holds the letter until the delay is up.
+	messages, _, err := topologer.QueueStats("TcrTestDelayedQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messages)
+
+	time.Sleep(time.Millisecond * 1500)
+
+	messages, _, err = topologer.QueueStats("TcrTestDelayedQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, messages)
+
+	_, err = topologer.QueueDelete("TcrTestDelayedQueue", false, false, false)
+	assert.NoError(t, err)
+
+	err = topologer.ExchangeDelete("TcrTestDelayedExchange", false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
+
+func TestBindExchange(t *testing.T) {
+
+	connectionPool, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
+	assert.NoError(t, err)
+
+	topologer := tcr.NewTopologer(connectionPool)
+
+	err = topologer.CreateExchange("TcrTestSourceExchange", "topic", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.CreateExchange("TcrTestDestinationExchange", "fanout", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.CreateQueue("TcrTestDestinationQueue", false, false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = topologer.QueueBind(&tcr.QueueBinding{QueueName: "TcrTestDestinationQueue", ExchangeName: "TcrTestDestinationExchange"})
+	assert.NoError(t, err)
+
+	err = topologer.BindExchange("TcrTestDestinationExchange", "TcrTestSourceExchange", "orders.*", nil)
+	assert.NoError(t, err)
+
+	publisher := tcr.NewPublisherFromConfig(Seasoning, connectionPool)
+	publish := func() {
+		publisher.PublishWithConfirmation(tcr.CreateLetter(1, "TcrTestSourceExchange", "orders.created", []byte("order")), time.Second)
+		assert.True(t, (<-publisher.PublishReceipts()).Success)
+	}
+
+	// Delivered through the destination exchange's bound queue.
+	publish()
+
+	messages, _, err := topologer.QueueStats("TcrTestDestinationQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, messages)
+
+	err = topologer.UnbindExchange("TcrTestDestinationExchange", "TcrTestSourceExchange", "orders.*", nil)
+	assert.NoError(t, err)
+
+	// No longer routed once unbound.
+	publish()
+
+	messages, _, err = topologer.QueueStats("TcrTestDestinationQueue")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, messages)
+
+	_, err = topologer.QueueDelete("TcrTestDestinationQueue", false, false, false)
+	assert.NoError(t, err)
+
+	err = topologer.ExchangeDelete("TcrTestDestinationExchange", false, false)
+	assert.NoError(t, err)
+
+	err = topologer.ExchangeDelete("TcrTestSourceExchange", false, false)
+	assert.NoError(t, err)
+
+	connectionPool.Shutdown()
+}
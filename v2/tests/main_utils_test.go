@@ -3,6 +3,7 @@ package main_test
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,6 +49,62 @@ func TestCompressAndDecompressWithZstd(t *testing.T) {
 	assert.Equal(t, data, buffer.String())
 }
 
+// compressionBenchmarkPayload is a large JSON message with the repetition typical of real records.
+func compressionBenchmarkPayload(b *testing.B) []byte {
+
+	records := make([]*TestStruct, 500)
+	for i := range records {
+		records[i] = &TestStruct{
+			PropertyString1: fmt.Sprintf("record-%d", i),
+			PropertyString2: tcr.RandomString(32),
+			PropertyString3: tcr.RepeatedRandomString(16, 4),
+			PropertyString4: "SuperStreetFighter2TurboMBisonDidNothingWrong",
+		}
+	}
+
+	var json = jsoniter.ConfigFastest
+	data, err := json.Marshal(records)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return data
+}
+
+func BenchmarkCompressWithGzip(b *testing.B) {
+
+	data := compressionBenchmarkPayload(b)
+	buffer := &bytes.Buffer{}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.Reset()
+		if err := tcr.CompressWithGzip(data, buffer); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(data))/float64(buffer.Len()), "ratio")
+}
+
+func BenchmarkCompressWithZstd(b *testing.B) {
+
+	data := compressionBenchmarkPayload(b)
+	buffer := &bytes.Buffer{}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.Reset()
+		if err := tcr.CompressWithZstd(data, buffer); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(data))/float64(buffer.Len()), "ratio")
+}
+
 func TestGetHashWithArgon2(t *testing.T) {
 
 	password := "SuperStreetFighter2Turbo"
@@ -370,3 +428,396 @@ func TestRandomStringFromSource(t *testing.T) {
 
 	assert.NotEqual(t, randoString, anotherRandoString)
 }
+
+func TestCreateWrappedPayloadAndUnwrapMessage(t *testing.T) {
+
+	password := "SuperStreetFighter2Turbo"
+	salt := "MBisonDidNothingWrong"
+
+	hashy := tcr.GetHashWithArgon(password, salt, 1, 12, 64, 32)
+
+	encrypt := &tcr.EncryptionConfig{
+		Enabled:           true,
+		Hashkey:           hashy,
+		Type:              tcr.AesSymmetricType,
+		TimeConsideration: 1,
+		Threads:           6,
+	}
+
+	compression := &tcr.CompressionConfig{
+		Enabled: true,
+		Type:    tcr.ZstdCompressionType,
+	}
+
+	test := &TestStruct{
+		PropertyString1: tcr.RandomString(5000),
+		PropertyString2: tcr.RandomString(5000),
+		PropertyString3: tcr.RandomString(5000),
+		PropertyString4: tcr.RandomString(5000),
+	}
+
+	data, err := tcr.CreateWrappedPayload(test, 1, "TestMetaData", compression, encrypt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, len(data))
+
+	msg := tcr.NewMessage(false, data, nil, 0, nil)
+
+	outputData := &TestStruct{}
+	err = msg.Unwrap(outputData, compression, encrypt)
+	assert.NoError(t, err)
+	assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+	assert.Equal(t, test.PropertyString2, outputData.PropertyString2)
+	assert.Equal(t, test.PropertyString3, outputData.PropertyString3)
+	assert.Equal(t, test.PropertyString4, outputData.PropertyString4)
+}
+
+func TestReadWrappedPayloadChecksumMismatch(t *testing.T) {
+
+	test := &TestStruct{
+		PropertyString1: tcr.RandomString(5000),
+		PropertyString2: tcr.RandomString(5000),
+	}
+
+	for _, checksumType := range []string{tcr.Crc32ChecksumType, tcr.Sha256ChecksumType} {
+		compression := &tcr.CompressionConfig{
+			Enabled:  true,
+			Type:     tcr.GzipCompressionType,
+			Checksum: checksumType,
+		}
+
+		data, err := tcr.CreateWrappedPayload(test, 1, "", compression, &tcr.EncryptionConfig{})
+		assert.NoError(t, err)
+
+		outputData := &TestStruct{}
+		wrappedBody, err := tcr.ReadWrappedPayload(data, outputData, compression, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, checksumType, wrappedBody.Body.ChecksumType)
+		assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+
+		// Flip a byte of the compressed data.
+		wrappedBody.Body.Data[len(wrappedBody.Body.Data)/2] ^= 0xff
+		corrupted, err := jsoniter.ConfigFastest.Marshal(wrappedBody)
+		assert.NoError(t, err)
+
+		_, err = tcr.ReadWrappedPayload(corrupted, &TestStruct{}, compression, nil)
+		assert.True(t, errors.Is(err, tcr.ErrChecksumMismatch))
+	}
+
+	_, err := tcr.CreateWrappedPayload(test, 1, "", &tcr.CompressionConfig{Checksum: "md5"}, &tcr.EncryptionConfig{})
+	assert.Error(t, err)
+}
+
+func TestUnwrapMessageErrorsOnUnwrappedBody(t *testing.T) {
+
+	msg := tcr.NewMessage(false, []byte("\x68\x65\x6c\x6c\x6f\x20\x77\x6f\x72\x6c\x64"), nil, 0, nil)
+
+	outputData := &TestStruct{}
+	err := msg.Unwrap(outputData, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestReadWrappedPayloadAfterKeyRotation(t *testing.T) {
+
+	oldKey := tcr.GetHashWithArgon("SuperStreetFighter2Turbo", "MBisonDidNothingWrong", 1, 12, 64, 32)
+	newKey := tcr.GetHashWithArgon("StreetFighterAlpha3", "AkumaDidEverythingWrong", 1, 12, 64, 32)
+
+	oldEncrypt := &tcr.EncryptionConfig{
+		Enabled: true,
+		Hashkey: oldKey,
+		KeyID:   tcr.GetKeyID(oldKey),
+		Type:    tcr.AesSymmetricType,
+	}
+
+	newEncrypt := &tcr.EncryptionConfig{
+		Enabled:        true,
+		Hashkey:        newKey,
+		KeyID:          tcr.GetKeyID(newKey),
+		DecryptionKeys: map[string][]byte{tcr.GetKeyID(oldKey): oldKey},
+		Type:           tcr.AesSymmetricType,
+	}
+
+	compression := &tcr.CompressionConfig{Enabled: false}
+
+	test := &TestStruct{PropertyString1: tcr.RandomString(100)}
+
+	oldData, err := tcr.CreateWrappedPayload(test, 1, "", compression, oldEncrypt)
+	assert.NoError(t, err)
+
+	newData, err := tcr.CreateWrappedPayload(test, 2, "", compression, newEncrypt)
+	assert.NoError(t, err)
+
+	for _, data := range [][]byte{oldData, newData} {
+		outputData := &TestStruct{}
+		_, err = tcr.ReadWrappedPayload(data, outputData, compression, newEncrypt)
+		assert.NoError(t, err)
+		assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+	}
+
+	// Without the old key the payload can't be decrypted.
+	_, err = tcr.ReadWrappedPayload(oldData, &TestStruct{}, compression, &tcr.EncryptionConfig{
+		Enabled: true,
+		Hashkey: newKey,
+		KeyID:   tcr.GetKeyID(newKey),
+		Type:    tcr.AesSymmetricType,
+	})
+	assert.Error(t, err)
+}
+
+func TestCreatePayloadThenDecryptAndDecompress(t *testing.T) {
+
+	hashy := tcr.GetHashWithArgon("SuperStreetFighter2Turbo", "MBisonDidNothingWrong", 1, 12, 64, 32)
+
+	encrypt := &tcr.EncryptionConfig{
+		Enabled: true,
+		Hashkey: hashy,
+		Type:    tcr.AesSymmetricType,
+	}
+
+	compression := &tcr.CompressionConfig{
+		Enabled: true,
+		Type:    tcr.ZstdCompressionType,
+	}
+
+	test := &TestStruct{
+		PropertyString1: tcr.RandomString(5000),
+		PropertyString2: tcr.RandomString(5000),
+	}
+
+	var json = jsoniter.ConfigFastest
+	var input interface{} = test
+	original, err := json.Marshal(&input)
+	assert.NoError(t, err)
+
+	data, err := tcr.CreatePayload(test, compression, encrypt)
+	assert.NoError(t, err)
+
+	decrypted, err := tcr.DecryptPayload(data, encrypt)
+	assert.NoError(t, err)
+
+	decompressed, err := tcr.DecompressPayload(decrypted, compression)
+	assert.NoError(t, err)
+	assert.Equal(t, original, decompressed)
+}
+
+func TestAesGcmCorruptedPayloadFailsAuthentication(t *testing.T) {
+
+	hashy := tcr.GetHashWithArgon("SuperStreetFighter2Turbo", "MBisonDidNothingWrong", 1, 12, 64, 32)
+
+	encrypt := &tcr.EncryptionConfig{
+		Enabled: true,
+		Hashkey: hashy,
+		Type:    tcr.AesGcmSymmetricType,
+	}
+
+	compression := &tcr.CompressionConfig{Enabled: false}
+
+	data, err := tcr.CreatePayload(&TestStruct{PropertyString1: tcr.RandomString(100)}, compression, encrypt)
+	assert.NoError(t, err)
+
+	_, err = tcr.DecryptPayload(data, encrypt)
+	assert.NoError(t, err)
+
+	// Flip a bit in the ciphertext after the nonce.
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+	corrupted[len(corrupted)-1] ^= 0x01
+
+	plaintext, err := tcr.DecryptPayload(corrupted, encrypt)
+	assert.Error(t, err)
+	assert.Nil(t, plaintext)
+}
+
+func TestCreatePayloadCompressionMinSizeBytes(t *testing.T) {
+
+	compression := &tcr.CompressionConfig{
+		Enabled:      true,
+		Type:         tcr.ZstdCompressionType,
+		MinSizeBytes: 1024,
+	}
+
+	encrypt := &tcr.EncryptionConfig{Enabled: false}
+
+	small := &TestStruct{PropertyString1: "small"}
+	large := &TestStruct{PropertyString1: tcr.RepeatedRandomString(100, 50)}
+
+	for _, test := range []*TestStruct{small, large} {
+		data, err := tcr.CreatePayload(test, compression, encrypt)
+		assert.NoError(t, err)
+
+		buffer := bytes.NewBuffer(data)
+		err = tcr.ReadPayload(buffer, compression, encrypt)
+		assert.NoError(t, err)
+
+		var json = jsoniter.ConfigFastest
+		outputData := &TestStruct{}
+		err = json.Unmarshal(buffer.Bytes(), outputData)
+		assert.NoError(t, err)
+		assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+	}
+
+	smallData, err := tcr.CreateWrappedPayload(small, 1, "", compression, encrypt)
+	assert.NoError(t, err)
+
+	wrappedBody, err := tcr.ReadWrappedPayload(smallData, &TestStruct{}, compression, encrypt)
+	assert.NoError(t, err)
+	assert.False(t, wrappedBody.Body.Compressed)
+
+	largeData, err := tcr.CreateWrappedPayload(large, 2, "", compression, encrypt)
+	assert.NoError(t, err)
+
+	outputData := &TestStruct{}
+	wrappedBody, err = tcr.ReadWrappedPayload(largeData, outputData, compression, encrypt)
+	assert.NoError(t, err)
+	assert.True(t, wrappedBody.Body.Compressed)
+	assert.Equal(t, large.PropertyString1, outputData.PropertyString1)
+}
+
+func TestCreatePayloadWithJSONConfig(t *testing.T) {
+
+	compression := &tcr.CompressionConfig{Enabled: false}
+	encrypt := &tcr.EncryptionConfig{Enabled: false}
+
+	test := &TestStruct{PropertyString1: "https://example.com/?a=<b>&c=d"}
+
+	data, err := tcr.CreatePayloadWithJSONConfig(test, &tcr.JSONConfig{}, compression, encrypt)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "<b>&c=d")
+	assert.False(t, bytes.HasSuffix(data, []byte("\n")))
+
+	escaped, err := tcr.CreatePayloadWithJSONConfig(test, &tcr.JSONConfig{EscapeHTML: true}, compression, encrypt)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(escaped), "<b>")
+	assert.Contains(t, string(escaped), "\\u003c")
+
+	indented, err := tcr.CreatePayloadWithJSONConfig(test, &tcr.JSONConfig{Indent: "  "}, compression, encrypt)
+	assert.NoError(t, err)
+	assert.Contains(t, string(indented), "\n  \"PropertyString1\"")
+
+	var json = jsoniter.ConfigFastest
+	outputData := &TestStruct{}
+	err = json.Unmarshal(indented, outputData)
+	assert.NoError(t, err)
+	assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+
+	wrapped, err := tcr.CreateWrappedPayloadWithJSONConfig(test, 1, "", &tcr.JSONConfig{}, compression, encrypt)
+	assert.NoError(t, err)
+
+	_, err = tcr.ReadWrappedPayload(wrapped, outputData, compression, encrypt)
+	assert.NoError(t, err)
+	assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+}
+
+func TestReadWrappedPayloadVersions(t *testing.T) {
+
+	compression := &tcr.CompressionConfig{Enabled: false}
+	encrypt := &tcr.EncryptionConfig{Enabled: false}
+
+	test := &TestStruct{PropertyString1: tcr.RandomString(100)}
+
+	data, err := tcr.CreateWrappedPayload(test, 1, "", compression, encrypt)
+	assert.NoError(t, err)
+
+	outputData := &TestStruct{}
+	wrappedBody, err := tcr.ReadWrappedPayload(data, outputData, compression, encrypt)
+	assert.NoError(t, err)
+	assert.Equal(t, tcr.WrappedBodyVersion, wrappedBody.Version)
+	assert.Equal(t, test.PropertyString1, outputData.PropertyString1)
+
+	var json = jsoniter.ConfigFastest
+	wrappedBody.Version = tcr.WrappedBodyVersion + 1
+	data, err = json.Marshal(wrappedBody)
+	assert.NoError(t, err)
+
+	_, err = tcr.ReadWrappedPayload(data, &TestStruct{}, compression, encrypt)
+	assert.Error(t, err)
+}
+
+func TestReceivedMessageTypedHeaders(t *testing.T) {
+
+	now := time.Now().UTC().Truncate(time.Second)
+	headers := amqp.Table{
+		"x-string":       "value",
+		"x-int32":        int32(32),
+		"x-int64":        int64(64),
+		"x-uint8":        uint8(8),
+		"x-time":         now,
+		"x-time-rfc3339": now.Format(time.RFC3339),
+	}
+
+	msg := tcr.NewMessage(false, nil, headers, 0, nil)
+
+	value, ok := msg.HeaderString("x-string")
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	_, ok = msg.HeaderString("x-int32")
+	assert.False(t, ok)
+
+	for key, expected := range map[string]int64{"x-int32": 32, "x-int64": 64, "x-uint8": 8} {
+		number, ok := msg.HeaderInt(key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, number)
+	}
+
+	_, ok = msg.HeaderInt("x-missing")
+	assert.False(t, ok)
+
+	for _, key := range []string{"x-time", "x-time-rfc3339"} {
+		timestamp, ok := msg.HeaderTime(key)
+		assert.True(t, ok)
+		assert.True(t, now.Equal(timestamp))
+	}
+}
+
+func TestReceivedMessageDecodeJSON(t *testing.T) {
+
+	msg, err := tcr.NewMessageFromDelivery(false, nil, &amqp.Delivery{
+		DeliveryTag: 42,
+		ContentType: "application/json",
+		Body:        []byte(`{"Name":"tcr","Count":3}`),
+	})
+	assert.NoError(t, err)
+
+	out := struct {
+		Name  string
+		Count int
+	}{}
+	assert.NoError(t, msg.DecodeJSON(&out))
+	assert.Equal(t, "tcr", out.Name)
+	assert.Equal(t, 3, out.Count)
+
+	msg.Body = []byte(`{"Name":`)
+	err = msg.DecodeJSON(&out)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "42")
+
+	msg.ContentType = "text/plain"
+	assert.Error(t, msg.DecodeJSON(&out))
+}
+
+func TestNewMessageFromDeliveryProperties(t *testing.T) {
+
+	delivery := &amqp.Delivery{
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		CorrelationId:   "correlation-1",
+		MessageId:       "message-1",
+		ReplyTo:         "TcrReplyQueue",
+		Type:            "OrderCreated",
+		Priority:        5,
+		AppId:           "tcr-tests",
+		Expiration:      "60000",
+	}
+
+	msg, err := tcr.NewMessageFromDelivery(true, nil, delivery)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, "gzip", msg.ContentEncoding)
+	assert.Equal(t, "correlation-1", msg.CorrelationId)
+	assert.Equal(t, "message-1", msg.MessageID)
+	assert.Equal(t, "TcrReplyQueue", msg.ReplyTo)
+	assert.Equal(t, "OrderCreated", msg.Type)
+	assert.Equal(t, uint8(5), msg.Priority)
+	assert.Equal(t, "tcr-tests", msg.AppID)
+	assert.Equal(t, "60000", msg.AMQPDelivery.Expiration)
+}